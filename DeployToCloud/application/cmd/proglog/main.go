@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 	"os/signal"
 
 	"github.com/sethvargo/go-envconfig"
@@ -20,14 +21,21 @@ func main() {
 }
 
 func run() error {
+	// certs runs before a node's config is there
+	if len(os.Args) > 1 && os.Args[1] == "certs" {
+		return runCerts(os.Args[2:])
+	}
 	ctx := context.Background()
-	var cfg *config.Env
-	if err := envconfig.Process(ctx, &cfg); err != nil {
+	cfg := &config.Env{}
+	if err := envconfig.Process(ctx, cfg); err != nil {
 		return err
 	}
 	if err := setupLogger(cfg); err != nil {
 		return err
 	}
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		return runRecover(cfg, os.Args[2:])
+	}
 
 	service, err := di.InitializeService(cfg)
 	if err != nil {
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/hashicorp/raft"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/config"
+	"github.com/travisjeffery/proglog/internal/di"
+)
+
+// runRecover rewrites the raft configuration of this stopped node so it can
+// start after the cluster lost quorum:
+//
+//	proglog recover [-peers peers.json]
+//
+// peers.json uses hashicorp raft's format, e.g.
+// [{"id": "proglog-0", "address": "10.0.0.1:8400", "non_voter": false}].
+// Without it the node recovers as a single-node cluster.
+func runRecover(cfg *config.Env, args []string) error {
+	fs := flag.NewFlagSet("recover", flag.ContinueOnError)
+	peers := fs.String("peers", "", "peers.json listing the servers to recover to, defaults to this node alone")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var servers []raft.Server
+	if *peers != "" {
+		configuration, err := raft.ReadConfigJSON(*peers)
+		if err != nil {
+			return err
+		}
+		servers = configuration.Servers
+	}
+
+	recovery, err := di.InitializeRecovery(cfg)
+	if err != nil {
+		return err
+	}
+	err = recovery.Recover(servers)
+	if cerr := recovery.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		zap.L().Info("recovered raft configuration", zap.String("peers", *peers))
+	}
+	return err
+}
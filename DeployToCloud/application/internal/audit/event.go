@@ -0,0 +1,58 @@
+// Package audit records who was allowed or denied what, and the admin
+// actions they took, to an append-only sink.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	KindAuthorization = "authorization"
+	KindAdmin         = "admin"
+
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+type IAuditor interface {
+	// Record completes the event with the time, and the peer address and
+	// trace id of the ctx, and writes it unless it's sampled out.
+	Record(ctx context.Context, e Event)
+	// Close closes the sink once nothing is recorded anymore.
+	Close() error
+}
+
+// Event is a line of the audit log.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Subject  string    `json:"subject"`
+	Action   string    `json:"action"`
+	Object   string    `json:"object,omitempty"`
+	Decision string    `json:"decision,omitempty"`
+	// Request is the admin RPC's request.
+	Request json.RawMessage `json:"request,omitempty"`
+	// Code is the admin RPC's status code.
+	Code    string `json:"code,omitempty"`
+	Peer    string `json:"peer,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+	// SampleRate is the share of the event's kind that's recorded, when
+	// it's sampled.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+func withContext(ctx context.Context, e Event) Event {
+	e.Time = time.Now().UTC()
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.Peer = p.Addr.String()
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		e.TraceID = span.SpanContext().TraceID.String()
+	}
+	return e
+}
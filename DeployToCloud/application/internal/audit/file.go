@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ReadAction's allowed decisions are the ones sampled.
+	ReadAction = "consume"
+	// RaftAction's allowed decisions aren't recorded, raft authorizes every
+	// append, heartbeat and vote between peers.
+	RaftAction = "raft"
+
+	defaultMaxBytes   = 100 << 20
+	defaultMaxBackups = 5
+)
+
+// File appends events to a JSON-lines file. Once the file would grow past
+// MaxBytes it's renamed to file.1, shifting older backups up to file.N and
+// dropping the oldest, and a new file is started.
+type File struct {
+	args FileArgs
+
+	mu sync.Mutex
+	// f is nil once a failed rotation closed it without reopening it, the
+	// next write reopens it.
+	f      *os.File
+	size   int64
+	rand   *rand.Rand
+	logger *zap.Logger
+}
+
+type FileArgs struct {
+	File string
+	// MaxBytes is the size the file is rotated at, 100MiB when zero.
+	MaxBytes int64
+	// MaxBackups is the number of rotated files kept, 5 when zero.
+	MaxBackups int
+	// ReadSampleRate is the share of allowed reads that's recorded, from 0
+	// to 1. Denials and writes are always recorded, and allowed raft RPCs
+	// never are.
+	ReadSampleRate float64
+}
+
+var _ IAuditor = (*File)(nil)
+
+func NewFile(args FileArgs) (*File, error) {
+	if args.File == "" {
+		return nil, errors.New("audit log needs a file")
+	}
+	if args.ReadSampleRate < 0 || args.ReadSampleRate > 1 {
+		return nil, fmt.Errorf("audit read sample rate %v isn't between 0 and 1", args.ReadSampleRate)
+	}
+	if args.MaxBytes <= 0 {
+		args.MaxBytes = defaultMaxBytes
+	}
+	if args.MaxBackups <= 0 {
+		args.MaxBackups = defaultMaxBackups
+	}
+	a := &File{
+		args: args,
+		//nolint:gosec //reason: sampling needn't be unpredictable
+		rand:   rand.New(rand.NewSource(rand.Int63())),
+		logger: zap.L().Named("audit"),
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *File) Record(ctx context.Context, e Event) {
+	if e.Kind == KindAuthorization && e.Decision == Allow && e.Action == RaftAction {
+		return
+	}
+	if e.Kind == KindAuthorization && e.Decision == Allow && e.Action == ReadAction {
+		if !a.sample() {
+			return
+		}
+		e.SampleRate = a.args.ReadSampleRate
+	}
+	b, err := json.Marshal(withContext(ctx, e))
+	if err != nil {
+		a.logger.Error("failed to encode audit event", zap.Error(err))
+		return
+	}
+	if err := a.write(append(b, '\n')); err != nil {
+		a.logger.Error("failed to write audit event", zap.Error(err))
+	}
+}
+
+func (a *File) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+func (a *File) sample() bool {
+	switch a.args.ReadSampleRate {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rand.Float64() < a.args.ReadSampleRate
+}
+
+func (a *File) write(b []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil && a.size > 0 && a.size+int64(len(b)) > a.args.MaxBytes {
+		if err := a.rotate(); err != nil {
+			a.logger.Error("failed to rotate audit log", zap.Error(err))
+		}
+	}
+	if a.f == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	n, err := a.f.Write(b)
+	a.size += int64(n)
+	return err
+}
+
+// rotate shifts the backups and starts a new file, or keeps appending to the
+// current one when it can't be moved. Callers hold mu.
+func (a *File) rotate() error {
+	err := a.f.Close()
+	a.f = nil
+	if err != nil {
+		return err
+	}
+	err = a.shift()
+	if oerr := a.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+func (a *File) shift() error {
+	for i := a.args.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(a.backup(i), a.backup(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(a.args.File, a.backup(1))
+}
+
+func (a *File) open() error {
+	f, err := os.OpenFile(a.args.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+func (a *File) backup(i int) string {
+	return fmt.Sprintf("%s.%d", a.args.File, i)
+}
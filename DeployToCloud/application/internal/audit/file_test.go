@@ -0,0 +1,177 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/audit"
+)
+
+func TestFile(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, file string){
+		"records events":               testRecord,
+		"rotates the file":             testRotate,
+		"appends when it can't rotate": testRotateRenameFailure,
+		"reopens after a failed open":  testRotateOpenFailure,
+		"samples allowed reads":        testSample,
+		"skips allowed raft RPCs":      testSkipRaft,
+		"rejects invalid sample rate":  testInvalidSampleRate,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, filepath.Join(t.TempDir(), "audit.log"))
+		})
+	}
+}
+
+func testRecord(t *testing.T, file string) {
+	a, err := NewFile(FileArgs{File: file, ReadSampleRate: 1})
+	require.NoError(t, err)
+	a.Record(context.Background(), Event{
+		Kind:     KindAuthorization,
+		Subject:  "root",
+		Action:   "produce",
+		Object:   "*",
+		Decision: Allow,
+	})
+	a.Record(context.Background(), Event{
+		Kind:    KindAdmin,
+		Subject: "root",
+		Action:  "Grant",
+		Request: json.RawMessage(`{"subject":"alice"}`),
+		Code:    "OK",
+	})
+	require.NoError(t, a.Close())
+
+	events := readEvents(t, file)
+	require.Len(t, events, 2)
+	require.Equal(t, KindAuthorization, events[0].Kind)
+	require.Equal(t, Allow, events[0].Decision)
+	require.False(t, events[0].Time.IsZero())
+	require.Equal(t, "Grant", events[1].Action)
+	require.JSONEq(t, `{"subject":"alice"}`, string(events[1].Request))
+}
+
+func testRotate(t *testing.T, file string) {
+	a, err := NewFile(FileArgs{File: file, MaxBytes: 1, MaxBackups: 2, ReadSampleRate: 1})
+	require.NoError(t, err)
+	for _, subject := range []string{"a", "b", "c", "d"} {
+		a.Record(context.Background(), Event{Kind: KindAdmin, Subject: subject})
+	}
+	require.NoError(t, a.Close())
+
+	for f, subject := range map[string]string{file: "d", file + ".1": "c", file + ".2": "b"} {
+		events := readEvents(t, f)
+		require.Len(t, events, 1)
+		require.Equal(t, subject, events[0].Subject)
+	}
+	_, err = os.Stat(file + ".3")
+	require.True(t, os.IsNotExist(err))
+}
+
+func testRotateRenameFailure(t *testing.T, file string) {
+	a, err := NewFile(FileArgs{File: file, MaxBytes: 1, MaxBackups: 1, ReadSampleRate: 1})
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(file+".1", 0o755))
+	for _, subject := range []string{"a", "b"} {
+		a.Record(context.Background(), Event{Kind: KindAdmin, Subject: subject})
+	}
+	// once the backup can be moved to, rotation resumes
+	require.NoError(t, os.Remove(file+".1"))
+	a.Record(context.Background(), Event{Kind: KindAdmin, Subject: "c"})
+	require.NoError(t, a.Close())
+
+	require.Equal(t, []string{"a", "b"}, subjects(readEvents(t, file+".1")))
+	require.Equal(t, []string{"c"}, subjects(readEvents(t, file)))
+}
+
+func testRotateOpenFailure(t *testing.T, file string) {
+	dir := filepath.Join(filepath.Dir(file), "audit")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	file = filepath.Join(dir, filepath.Base(file))
+	a, err := NewFile(FileArgs{File: file, MaxBytes: 1, MaxBackups: 1, ReadSampleRate: 1})
+	require.NoError(t, err)
+	a.Record(context.Background(), Event{Kind: KindAdmin, Subject: "a"})
+	// without its directory the file can neither be rotated nor reopened
+	require.NoError(t, os.Rename(dir, dir+".moved"))
+	a.Record(context.Background(), Event{Kind: KindAdmin, Subject: "b"})
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	a.Record(context.Background(), Event{Kind: KindAdmin, Subject: "c"})
+	require.NoError(t, a.Close())
+
+	require.Equal(t, []string{"c"}, subjects(readEvents(t, file)))
+}
+
+func testSample(t *testing.T, file string) {
+	for _, rate := range []float64{0, 1} {
+		a, err := NewFile(FileArgs{File: file, ReadSampleRate: rate})
+		require.NoError(t, err)
+		for _, decision := range []string{Allow, Deny} {
+			a.Record(context.Background(), Event{
+				Kind:     KindAuthorization,
+				Action:   ReadAction,
+				Decision: decision,
+			})
+		}
+		require.NoError(t, a.Close())
+	}
+
+	events := readEvents(t, file)
+	require.Len(t, events, 3)
+	require.Equal(t, Deny, events[0].Decision)
+	require.Equal(t, Allow, events[1].Decision)
+	require.Equal(t, float64(1), events[1].SampleRate)
+	require.Equal(t, Deny, events[2].Decision)
+}
+
+func testSkipRaft(t *testing.T, file string) {
+	a, err := NewFile(FileArgs{File: file, ReadSampleRate: 1})
+	require.NoError(t, err)
+	for _, decision := range []string{Allow, Deny} {
+		a.Record(context.Background(), Event{
+			Kind:     KindAuthorization,
+			Subject:  "root",
+			Action:   RaftAction,
+			Decision: decision,
+		})
+	}
+	require.NoError(t, a.Close())
+
+	events := readEvents(t, file)
+	require.Len(t, events, 1)
+	require.Equal(t, Deny, events[0].Decision)
+}
+
+func testInvalidSampleRate(t *testing.T, file string) {
+	_, err := NewFile(FileArgs{File: file, ReadSampleRate: 2})
+	require.Error(t, err)
+}
+
+func readEvents(t *testing.T, file string) []Event {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func subjects(events []Event) []string {
+	subjects := make([]string, 0, len(events))
+	for _, e := range events {
+		subjects = append(subjects, e.Subject)
+	}
+	return subjects
+}
@@ -0,0 +1,262 @@
+// Package ca issues the certificates proglog's servers, peers and clients
+// authenticate with, replacing the cfssl setup the Makefile used to run.
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCAValidity   = 5 * 365 * 24 * time.Hour
+	defaultCertValidity = 365 * 24 * time.Hour
+	// backdate covers clocks that lag behind the CA's.
+	backdate = 5 * time.Minute
+)
+
+// CA signs certificates with its key.
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pem  []byte
+}
+
+type CAArgs struct {
+	// CommonName defaults to proglog CA.
+	CommonName string
+	// Validity defaults to five years.
+	Validity time.Duration
+}
+
+// Profile is what a certificate is used for, like cfssl's signing profiles.
+type Profile int
+
+const (
+	// Server certificates may authenticate clients too, so that a node can
+	// use one certificate for its listener and its peer connections.
+	Server Profile = iota
+	Client
+)
+
+type CertArgs struct {
+	CommonName string
+	// Hosts are the certificate's SANs: IP addresses, email addresses, URIs
+	// with a scheme, like spiffe://example.org/ns/x/sa/y, and otherwise DNS
+	// names.
+	Hosts   []string
+	Profile Profile
+	// Validity defaults to a year.
+	Validity time.Duration
+}
+
+// Cert is a PEM encoded certificate and its key.
+type Cert struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// New creates a CA with a self-signed certificate.
+func New(args CAArgs) (*CA, error) {
+	if args.CommonName == "" {
+		args.CommonName = "proglog CA"
+	}
+	if args.Validity <= 0 {
+		args.Validity = defaultCAValidity
+	}
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(args.CommonName, args.Validity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key, pem: encode("CERTIFICATE", der)}, nil
+}
+
+// Load reads a CA written by WriteFiles, or any PEM encoded CA certificate
+// and its PKCS #8, PKCS #1 or SEC 1 key.
+func Load(certFile, keyFile string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in %q", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%q isn't a CA certificate", certFile)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", keyFile, err)
+	}
+	return &CA{cert: cert, key: key, pem: encode("CERTIFICATE", block.Bytes)}, nil
+}
+
+// CertPEM returns the CA's certificate, which servers and clients verify
+// their peers against.
+func (ca *CA) CertPEM() []byte {
+	return ca.pem
+}
+
+// Issue creates a key and a certificate signed by the CA.
+func (ca *CA) Issue(args CertArgs) (*Cert, error) {
+	if args.CommonName == "" {
+		return nil, errors.New("certificate needs a common name")
+	}
+	if args.Validity <= 0 {
+		args.Validity = defaultCertValidity
+	}
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(args.CommonName, args.Validity)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	switch args.Profile {
+	case Server:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	case Client:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		return nil, fmt.Errorf("unknown certificate profile: %d", args.Profile)
+	}
+	if err := addHosts(template, args.Hosts); err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cert{CertPEM: encode("CERTIFICATE", der), KeyPEM: encode("PRIVATE KEY", keyDER)}, nil
+}
+
+// WriteFiles writes the CA to name.pem and name-key.pem in dir, the names
+// cfssljson -bare used.
+func (ca *CA) WriteFiles(dir, name string) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		return err
+	}
+	return (&Cert{CertPEM: ca.pem, KeyPEM: encode("PRIVATE KEY", keyDER)}).WriteFiles(dir, name)
+}
+
+// WriteFiles writes the certificate to name.pem and its key to name-key.pem
+// in dir.
+func (c *Cert) WriteFiles(dir, name string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), c.CertPEM, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+"-key.pem"), c.KeyPEM, 0o600)
+}
+
+func newKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-backdate),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func addHosts(template *x509.Certificate, hosts []string) error {
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+			continue
+		}
+		if email, err := mail.ParseAddress(host); err == nil && email.Address == host {
+			template.EmailAddresses = append(template.EmailAddresses, host)
+			continue
+		}
+		if strings.Contains(host, "://") {
+			uri, err := url.Parse(host)
+			if err != nil {
+				return fmt.Errorf("invalid uri host %q: %w", host, err)
+			}
+			template.URIs = append(template.URIs, uri)
+			continue
+		}
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	return nil
+}
+
+func parseKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no private key")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key: %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key")
+}
+
+func encode(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
@@ -0,0 +1,89 @@
+package ca_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/ca"
+)
+
+func TestIssue(t *testing.T) {
+	ca, err := New(CAArgs{})
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(ca.CertPEM()))
+
+	for scenario, tc := range map[string]struct {
+		args    CertArgs
+		dnsName string
+		usage   x509.ExtKeyUsage
+		ok      bool
+	}{
+		"server certificate verifies for its ip":  {args: CertArgs{CommonName: "127.0.0.1", Hosts: []string{"127.0.0.1", "localhost"}}, dnsName: "127.0.0.1", usage: x509.ExtKeyUsageServerAuth, ok: true},
+		"server certificate verifies for its dns": {args: CertArgs{CommonName: "127.0.0.1", Hosts: []string{"127.0.0.1", "localhost"}}, dnsName: "localhost", usage: x509.ExtKeyUsageServerAuth, ok: true},
+		"server certificate is a client's too":    {args: CertArgs{CommonName: "127.0.0.1", Hosts: []string{"127.0.0.1"}}, usage: x509.ExtKeyUsageClientAuth, ok: true},
+		"server certificate fails for other host": {args: CertArgs{CommonName: "127.0.0.1", Hosts: []string{"127.0.0.1"}}, dnsName: "example.com", usage: x509.ExtKeyUsageServerAuth},
+		"client certificate verifies":             {args: CertArgs{CommonName: "alice", Profile: Client}, usage: x509.ExtKeyUsageClientAuth, ok: true},
+		"client certificate isn't a server's":     {args: CertArgs{CommonName: "alice", Profile: Client}, usage: x509.ExtKeyUsageServerAuth},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			cert, err := ca.Issue(tc.args)
+			require.NoError(t, err)
+			pair, err := tls.X509KeyPair(cert.CertPEM, cert.KeyPEM)
+			require.NoError(t, err)
+			leaf, err := x509.ParseCertificate(pair.Certificate[0])
+			require.NoError(t, err)
+			require.Equal(t, tc.args.CommonName, leaf.Subject.CommonName)
+			_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: tc.dnsName, KeyUsages: []x509.ExtKeyUsage{tc.usage}})
+			if tc.ok {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestIssueHosts(t *testing.T) {
+	ca, err := New(CAArgs{})
+	require.NoError(t, err)
+	cert, err := ca.Issue(CertArgs{
+		CommonName: "workload",
+		Hosts:      []string{"spiffe://example.org/ns/x/sa/y", "node.example.org", "::1", "ops@example.org"},
+	})
+	require.NoError(t, err)
+	pair, err := tls.X509KeyPair(cert.CertPEM, cert.KeyPEM)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	require.Len(t, leaf.URIs, 1)
+	require.Equal(t, "spiffe://example.org/ns/x/sa/y", leaf.URIs[0].String())
+	require.Equal(t, []string{"node.example.org"}, leaf.DNSNames)
+	require.Len(t, leaf.IPAddresses, 1)
+	require.Equal(t, []string{"ops@example.org"}, leaf.EmailAddresses)
+}
+
+func TestWriteConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	args := FilesArgs{Dir: dir, ServerHosts: []string{"127.0.0.1"}, Clients: []string{"root", "nobody"}}
+	require.NoError(t, WriteConfigFiles(args))
+	for _, name := range []string{"ca", "server", "root-client", "nobody-client"} {
+		_, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem"))
+		require.NoError(t, err, name)
+	}
+
+	// rewriting the files keeps the CA
+	ca, err := Load(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	require.NoError(t, err)
+	require.NoError(t, WriteConfigFiles(args))
+	reloaded, err := Load(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	require.NoError(t, err)
+	require.Equal(t, ca.CertPEM(), reloaded.CertPEM())
+
+	_, err = Load(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	require.Error(t, err)
+}
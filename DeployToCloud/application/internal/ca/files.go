@@ -0,0 +1,67 @@
+package ca
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type FilesArgs struct {
+	Dir string
+	// ServerHosts are the server certificate's SANs, its common name is the
+	// first of them.
+	ServerHosts []string
+	// Clients are the common names of the client certificates.
+	Clients  []string
+	Validity time.Duration
+}
+
+// WriteConfigFiles writes the files a local cluster and the tests read from
+// the config dir: ca.pem, server.pem and <client>-client.pem for each client,
+// each with its -key.pem. A CA already in the dir signs the certificates,
+// so that rotating them doesn't change what peers trust.
+func WriteConfigFiles(args FilesArgs) error {
+	if len(args.ServerHosts) == 0 {
+		return errors.New("server certificate needs a host")
+	}
+	ca, err := loadOrCreate(args.Dir)
+	if err != nil {
+		return err
+	}
+	server, err := ca.Issue(CertArgs{
+		CommonName: args.ServerHosts[0],
+		Hosts:      args.ServerHosts,
+		Profile:    Server,
+		Validity:   args.Validity,
+	})
+	if err != nil {
+		return err
+	}
+	if err := server.WriteFiles(args.Dir, "server"); err != nil {
+		return err
+	}
+	for _, client := range args.Clients {
+		cert, err := ca.Issue(CertArgs{CommonName: client, Profile: Client, Validity: args.Validity})
+		if err != nil {
+			return err
+		}
+		if err := cert.WriteFiles(args.Dir, client+"-client"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadOrCreate(dir string) (*CA, error) {
+	certFile := filepath.Join(dir, "ca.pem")
+	if _, err := os.Stat(certFile); !errors.Is(err, fs.ErrNotExist) {
+		return Load(certFile, filepath.Join(dir, "ca-key.pem"))
+	}
+	ca, err := New(CAArgs{})
+	if err != nil {
+		return nil, err
+	}
+	return ca, ca.WriteFiles(dir, "ca")
+}
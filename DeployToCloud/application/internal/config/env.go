@@ -20,8 +20,54 @@ type Env struct {
 	BindAddr       string        `env:"HOST_NAME,default=127.0.0.1:8401"`
 	StartJoinAddrs []string      `env:"START_JOIN_ADDRS"`
 
-	AclModelFile  string `env:"ACL_MODEL_FILE"`
+	// Role is "voter" or "nonvoter". Nonvoters replicate the log and serve
+	// reads without growing the quorum.
+	Role string `env:"ROLE,default=voter"`
+
+	ProduceWindow int           `env:"PRODUCE_WINDOW,default=64"`
+	DrainTimeout  time.Duration `env:"DRAIN_TIMEOUT,default=10s"`
+
+	// LogName names the log in the objects of the produce and consume ACL
+	// rules, <log>/<partition>.
+	LogName      string `env:"LOG_NAME,default=proglog"`
+	AclModelFile string `env:"ACL_MODEL_FILE"`
+	// AclPolicyFile applies until the first Grant or Revoke seeds the
+	// policy replicated through partition 0 with it.
 	AclPolicyFile string `env:"ACL_POLICY_FILE"`
+	// AclReloadInterval is how often the ACL files are checked for edits.
+	AclReloadInterval time.Duration `env:"ACL_RELOAD_INTERVAL,default=1s"`
+
+	// RpcAuthMode is how the RPC listener authenticates clients: mtls,
+	// optional-mtls, bearer, jwt or insecure-local.
+	RpcAuthMode string `env:"RPC_AUTH_MODE,default=mtls"`
+	// RpcAuthTokensFile holds a "subject token" pair per line for bearer.
+	RpcAuthTokensFile string `env:"RPC_AUTH_TOKENS_FILE"`
+	// jwt verifies tokens with the keys of the JWKS file, reread when it
+	// changes, and takes the subject from the claim, sub by default.
+	RpcAuthJWKSFile    string `env:"RPC_AUTH_JWKS_FILE"`
+	RpcAuthJWTIssuer   string `env:"RPC_AUTH_JWT_ISSUER"`
+	RpcAuthJWTAudience string `env:"RPC_AUTH_JWT_AUDIENCE"`
+	RpcAuthJWTSubject  string `env:"RPC_AUTH_JWT_SUBJECT_CLAIM,default=sub"`
+	// RpcAuthCertSubject maps client certificates to subjects: cn, uri or
+	// dns, optionally followed by :regexp picking the SAN and the part of it
+	// to take, e.g. uri:^spiffe://example\.org/ for SPIFFE IDs. Peers
+	// authenticate with it too, so their certificates need a matching name.
+	RpcAuthCertSubject string `env:"RPC_AUTH_CERT_SUBJECT,default=cn"`
+
+	// QuotaFile limits the produce and consume requests and bytes per second
+	// of each subject, with a "subject action requests/s bytes/s" line per
+	// quota and * as the default subject. Without it there are no quotas.
+	QuotaFile string `env:"QUOTA_FILE"`
+	// QuotaReloadInterval is how often the quota file is checked for edits.
+	QuotaReloadInterval time.Duration `env:"QUOTA_RELOAD_INTERVAL,default=1s"`
+
+	// AuditFile is a JSON-lines log of every authorization decision and
+	// admin RPC, rotated at AuditMaxBytes. Without it nothing is audited.
+	AuditFile       string `env:"AUDIT_FILE"`
+	AuditMaxBytes   int64  `env:"AUDIT_MAX_BYTES,default=104857600"`
+	AuditMaxBackups int    `env:"AUDIT_MAX_BACKUPS,default=5"`
+	// AuditReadSampleRate is the share of allowed consumes that's audited.
+	AuditReadSampleRate float64 `env:"AUDIT_READ_SAMPLE_RATE,default=1"`
 
 	ServerTLSCertFile string `env:"SERVER_TLS_CERT_FILE"`
 	ServerTLSKeyFile  string `env:"SERVER_TLS_KEY_FILE"`
@@ -31,13 +77,49 @@ type Env struct {
 	PeerTLSKeyFile  string `env:"PEER_TLS_KEY_FILE"`
 	PeerTLSCaFile   string `env:"PEER_TLS_CA_FILE"`
 
+	// TlsReloadInterval is how often the server and peer certificate files
+	// are checked for rotations.
+	TlsReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL,default=1s"`
+
 	MaxStoreBytes uint64 `env:"MAX_STORE_BYTES"`
 	MaxIndexBytes uint64 `env:"MAX_INDEX_BYTES"`
 	InitialOffset uint64 `env:"INITIAL_OFFSET,default=1"`
 
+	// BootstrapExpect is the number of voters that form a new cluster. Once
+	// that many voters without raft state have joined through serf, they
+	// bootstrap raft together. Zero never bootstraps: the node waits to be
+	// added to an existing cluster, so only nodes meant to form one set it.
+	BootstrapExpect    int           `env:"BOOTSTRAP_EXPECT,default=0"`
 	BootstrapTimeout   time.Duration `env:"BOOTSTRAP_TIMEOUT,default=3s"`
 	HeartbeatTimeout   time.Duration `env:"HEARTBEAT_TIMEOUT"`
 	ElectionTimeout    time.Duration `env:"ELECTION_TIMEOUT"`
 	LeaderLeaseTimeout time.Duration `env:"LEADER_LEASE_TIMEOUT"`
 	CommitTimeout      time.Duration `env:"COMMIT_TIMEOUT"`
+	BatchApplyCh       bool          `env:"BATCH_APPLY_CH,default=true"`
+	MaxAppendEntries   int           `env:"MAX_APPEND_ENTRIES,default=256"`
+
+	// raft snapshots every SnapshotInterval once SnapshotThreshold logs were
+	// applied since the last snapshot and then compacts its log down to
+	// TrailingLogs, so lower values keep the raft log directory smaller.
+	SnapshotInterval  time.Duration `env:"SNAPSHOT_INTERVAL,default=120s"`
+	SnapshotThreshold uint64        `env:"SNAPSHOT_THRESHOLD,default=8192"`
+	TrailingLogs      uint64        `env:"TRAILING_LOGS,default=10240"`
+	SnapshotRetain    int           `env:"SNAPSHOT_RETAIN,default=1"`
+	TransportMaxPool  int           `env:"TRANSPORT_MAX_POOL,default=5"`
+	TransportTimeout  time.Duration `env:"TRANSPORT_TIMEOUT,default=10s"`
+	// RaftTransport is tcp for raft's own protocol on the mux or grpc for
+	// the Raft service on the grpc server.
+	RaftTransport string `env:"RAFT_TRANSPORT,default=tcp"`
+	// RaftPeerIdentity is a glob the common name or a SAN of a raft peer's
+	// certificate must match, unless the peer is already in the partition's
+	// raft configuration. A joining node knows no servers yet, so the glob
+	// should match every node. It's required when peer TLS is on.
+	RaftPeerIdentity string `env:"RAFT_PEER_IDENTITY"`
+
+	// Partitions is the number of partitions of the log. Each partition is
+	// replicated by its own raft group and is led independently.
+	Partitions int `env:"PARTITIONS,default=1"`
+	// ReplicationFactor is the number of voters replicating each partition.
+	// Zero replicates every partition on every voter.
+	ReplicationFactor int `env:"REPLICATION_FACTOR,default=0"`
 }
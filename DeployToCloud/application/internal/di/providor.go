@@ -2,116 +2,301 @@ package di
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/soheilhy/cmux"
 
+	"github.com/travisjeffery/proglog/internal/audit"
 	"github.com/travisjeffery/proglog/internal/config"
 	"github.com/travisjeffery/proglog/internal/grpc/auth"
+	"github.com/travisjeffery/proglog/internal/grpc/quota"
+	"github.com/travisjeffery/proglog/internal/grpc/server"
 	"github.com/travisjeffery/proglog/internal/log"
 	"github.com/travisjeffery/proglog/internal/membership"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
 	"github.com/travisjeffery/proglog/internal/raft"
+	"github.com/travisjeffery/proglog/internal/service"
 	innertls "github.com/travisjeffery/proglog/internal/tls"
 )
 
 func ProvideSegmentConfig(cfg *config.Env) log.Config {
 	return log.Config{
+		DataDir:       cfg.DataDir,
 		MaxStoreBytes: cfg.MaxStoreBytes,
 		MaxIndexBytes: cfg.MaxIndexBytes,
 		InitialOffset: cfg.InitialOffset,
 	}
 }
 
-func ProvideACLArgs(cfg *config.Env) auth.Args {
+func ProvideACLArgs(cfg *config.Env, p *raft.Partitions, auditor audit.IAuditor) auth.Args {
 	return auth.Args{
-		ModelFile:  cfg.AclModelFile,
-		PolicyFile: cfg.AclPolicyFile,
+		ModelFile:      cfg.AclModelFile,
+		PolicyFile:     cfg.AclPolicyFile,
+		ReloadInterval: cfg.AclReloadInterval,
+		Store:          p.Policies(),
+		Auditor:        auditor,
 	}
 }
 
-func isBootstrap(nodeName string) bool {
-	ss := strings.Split(nodeName, "-")
-	return ss[len(ss)-1] == "0"
+// ProvideAuditor returns the audit log, or nil when there's no audit file.
+func ProvideAuditor(cfg *config.Env) (audit.IAuditor, error) {
+	if cfg.AuditFile == "" {
+		return nil, nil
+	}
+	return audit.NewFile(audit.FileArgs{
+		File:           cfg.AuditFile,
+		MaxBytes:       cfg.AuditMaxBytes,
+		MaxBackups:     cfg.AuditMaxBackups,
+		ReadSampleRate: cfg.AuditReadSampleRate,
+	})
+}
+
+func authMode(cfg *config.Env) (string, error) {
+	if cfg.RpcAuthMode == "" {
+		return auth.ModeMTLS, nil
+	}
+	return cfg.RpcAuthMode, auth.ValidateMode(cfg.RpcAuthMode)
+}
+
+func ProvideAuthenticatorArgs(cfg *config.Env, tlsConfig innertls.Config) (auth.AuthenticatorArgs, error) {
+	mode, err := authMode(cfg)
+	if err != nil {
+		return auth.AuthenticatorArgs{}, err
+	}
+	certSubject, err := auth.ParseCertSubject(cfg.RpcAuthCertSubject)
+	if err != nil {
+		return auth.AuthenticatorArgs{}, err
+	}
+	args := auth.AuthenticatorArgs{
+		Mode:             mode,
+		CertSubject:      certSubject,
+		ListenerVerifies: tlsConfig.ServerTLSConfig != nil && tlsConfig.ServerTLSConfig.VerifyPeerCertificate != nil,
+	}
+	switch mode {
+	case auth.ModeBearer:
+		if cfg.RpcAuthTokensFile == "" {
+			return auth.AuthenticatorArgs{}, fmt.Errorf("%s auth needs RPC_AUTH_TOKENS_FILE", mode)
+		}
+		args.Tokens, err = auth.NewTokens(cfg.RpcAuthTokensFile)
+	case auth.ModeJWT:
+		args.Tokens, err = auth.NewJWTVerifier(auth.JWTArgs{
+			JWKSFile:     cfg.RpcAuthJWKSFile,
+			Issuer:       cfg.RpcAuthJWTIssuer,
+			Audience:     cfg.RpcAuthJWTAudience,
+			SubjectClaim: cfg.RpcAuthJWTSubject,
+		})
+	}
+	if err != nil {
+		return auth.AuthenticatorArgs{}, err
+	}
+	return args, nil
 }
 
-func ProvideRaftArgs(cfg *config.Env) raft.Args {
-	return raft.Args{
+// ProvideLimiter returns the quota limiter, or nil when there's no quota
+// file.
+func ProvideLimiter(cfg *config.Env) (quota.ILimiter, error) {
+	if cfg.QuotaFile == "" {
+		return nil, nil
+	}
+	return quota.NewLimiter(quota.Args{File: cfg.QuotaFile, ReloadInterval: cfg.QuotaReloadInterval})
+}
+
+func ProvideServerArgs(cfg *config.Env) server.Args {
+	return server.Args{
+		ProduceWindow: cfg.ProduceWindow,
+		LogName:       cfg.LogName,
+	}
+}
+
+func ProvideServiceArgs(cfg *config.Env) service.Args {
+	return service.Args{
+		DrainTimeout: cfg.DrainTimeout,
+	}
+}
+
+func rpcAddr(cfg *config.Env) (string, error) {
+	host, _, err := net.SplitHostPort(cfg.BindAddr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", host, cfg.RpcPort), nil
+}
+
+func ProvideRaftArgs(cfg *config.Env) (raft.Args, error) {
+	// raft is served from the rpc port through the mux, not the serf port
+	addr, err := rpcAddr(cfg)
+	if err != nil {
+		return raft.Args{}, err
+	}
+	args := raft.Args{
 		DataDir:            cfg.DataDir,
 		NodeName:           cfg.NodeName,
-		IsBootstrap:        isBootstrap(cfg.NodeName),
-		BindAddr:           cfg.BindAddr,
+		BindAddr:           addr,
 		BootstrapTimeout:   cfg.BootstrapTimeout,
 		HeartbeatTimeout:   cfg.HeartbeatTimeout,
 		ElectionTimeout:    cfg.ElectionTimeout,
 		LeaderLeaseTimeout: cfg.LeaderLeaseTimeout,
 		CommitTimeout:      cfg.CommitTimeout,
+		BatchApplyCh:       cfg.BatchApplyCh,
+		MaxAppendEntries:   cfg.MaxAppendEntries,
+		SnapshotInterval:   cfg.SnapshotInterval,
+		SnapshotThreshold:  cfg.SnapshotThreshold,
+		TrailingLogs:       cfg.TrailingLogs,
+		SnapshotRetain:     cfg.SnapshotRetain,
+		TransportMaxPool:   cfg.TransportMaxPool,
+		TransportTimeout:   cfg.TransportTimeout,
+		Partitions:         cfg.Partitions,
+		ReplicationFactor:  cfg.ReplicationFactor,
 	}
+	if err := raft.ValidateArgs(args); err != nil {
+		return raft.Args{}, err
+	}
+	return args, nil
+}
+
+func role(cfg *config.Env) (string, error) {
+	switch cfg.Role {
+	case "", membership.RoleVoter:
+		return membership.RoleVoter, nil
+	case membership.RoleNonvoter:
+		return membership.RoleNonvoter, nil
+	}
+	return "", fmt.Errorf("unknown role: %s", cfg.Role)
 }
 
 func ProvideMembershipArgs(cfg *config.Env) (membership.Args, error) {
+	if cfg.BootstrapExpect < 0 {
+		return membership.Args{}, fmt.Errorf("invalid bootstrap expect: %d", cfg.BootstrapExpect)
+	}
+	// every node may list the same join addresses, including its own
 	var as []string
-	if !isBootstrap(cfg.NodeName) {
-		as = cfg.StartJoinAddrs
+	for _, a := range cfg.StartJoinAddrs {
+		if a != cfg.BindAddr {
+			as = append(as, a)
+		}
 	}
-	host, _, err := net.SplitHostPort(cfg.BindAddr)
+	addr, err := rpcAddr(cfg)
+	if err != nil {
+		return membership.Args{}, err
+	}
+	r, err := role(cfg)
 	if err != nil {
 		return membership.Args{}, err
 	}
-	rpcAddr := fmt.Sprintf("%s:%d", host, cfg.RpcPort)
 	return membership.Args{
-		NodeName:       cfg.NodeName,
-		Tags:           map[string]string{"rpc_addr": rpcAddr},
-		BindAddr:       cfg.BindAddr,
-		RPCAddr:        rpcAddr,
-		StartJoinAddrs: as,
+		NodeName: cfg.NodeName,
+		Tags: map[string]string{
+			membership.RPCAddrTag: addr,
+			membership.RoleTag:    r,
+		},
+		BindAddr:        cfg.BindAddr,
+		RPCAddr:         addr,
+		StartJoinAddrs:  as,
+		BootstrapExpect: cfg.BootstrapExpect,
 	}, nil
 }
 
 func ProvideInnerTLSConfig(cfg *config.Env) (innertls.Config, error) {
-	var err error
 	var serverTLSConfig *tls.Config
 	if cfg.ServerTLSCertFile != "" && cfg.ServerTLSKeyFile != "" {
-		serverTLSConfig, err = innertls.SetupTLS(innertls.Args{
-			CertFile: cfg.ServerTLSCertFile,
-			KeyFile:  cfg.ServerTLSKeyFile,
-			CAFile:   cfg.ServerTLSCaFile,
-			Server:   true,
+		w, err := innertls.NewCertWatcher(innertls.WatcherArgs{
+			Args: innertls.Args{
+				CertFile: cfg.ServerTLSCertFile,
+				KeyFile:  cfg.ServerTLSKeyFile,
+				CAFile:   cfg.ServerTLSCaFile,
+				Server:   true,
+			},
+			Name:           "server",
+			ReloadInterval: cfg.TlsReloadInterval,
 		})
 		if err != nil {
 			return innertls.Config{}, err
 		}
+		serverTLSConfig = w.Config()
 	}
 
 	var peerTLSConfig *tls.Config
 	if cfg.PeerTLSCertFile != "" && cfg.PeerTLSKeyFile != "" {
-		peerTLSConfig, err = innertls.SetupTLS(innertls.Args{
-			CertFile: cfg.PeerTLSCertFile,
-			KeyFile:  cfg.PeerTLSKeyFile,
-			CAFile:   cfg.PeerTLSCaFile,
+		w, err := innertls.NewCertWatcher(innertls.WatcherArgs{
+			Args: innertls.Args{
+				CertFile: cfg.PeerTLSCertFile,
+				KeyFile:  cfg.PeerTLSKeyFile,
+				CAFile:   cfg.PeerTLSCaFile,
+			},
+			Name:           "peer",
+			ReloadInterval: cfg.TlsReloadInterval,
 		})
 		if err != nil {
 			return innertls.Config{}, err
 		}
+		peerTLSConfig = w.Config()
 	}
 
 	return innertls.Config{ServerTLSConfig: serverTLSConfig, PeerTLSConfig: peerTLSConfig}, nil
 }
 
-func ProvideTLSConfig(cfg innertls.Config) *tls.Config {
-	return cfg.ServerTLSConfig
+func ProvideTransports(cfg *config.Env, mux cmux.CMux, tlsConfig innertls.Config) (raft.Transports, error) {
+	switch cfg.RaftTransport {
+	case "", raft.TransportTCP:
+		identity, err := raftPeerIdentity(cfg, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return raft.NewStreamLayer(mux, tlsConfig, identity)
+	case raft.TransportGRPC:
+		addr, err := rpcAddr(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return raft.NewGRPCTransports(addr, tlsConfig.PeerTLSConfig), nil
+	}
+	return nil, fmt.Errorf("unknown raft transport: %s", cfg.RaftTransport)
 }
 
-func ProvideLog(cfg log.Config) (*log.Log, error) {
-	logDir := filepath.Join(cfg.DataDir, "log")
-	if err := os.MkdirAll(logDir, 0o755); err != nil {
+// raftPeerIdentity requires the identity raft peers must present when peer
+// TLS is on. Without it a node joining after bootstrap knows no servers and
+// would reject its leader, and no default is narrow enough: this node's own
+// certificate may be issued per node, or shared with clients.
+func raftPeerIdentity(cfg *config.Env, tlsConfig innertls.Config) (string, error) {
+	if cfg.RaftPeerIdentity == "" && tlsConfig.PeerTLSConfig != nil {
+		return "", errors.New("peer TLS needs RAFT_PEER_IDENTITY")
+	}
+	return cfg.RaftPeerIdentity, nil
+}
+
+// ProvideRaftServer returns the Raft service when raft runs over grpc.
+func ProvideRaftServer(transports raft.Transports) pb.RaftServer {
+	if t, ok := transports.(*raft.GRPCTransports); ok {
+		return t
+	}
+	return nil
+}
+
+// ProvideTLSConfig returns the RPC listener's TLS config for its auth mode,
+// none for insecure-local which may only listen on loopback. Every other
+// mode needs a server certificate.
+func ProvideTLSConfig(cfg *config.Env, tlsConfig innertls.Config) (*tls.Config, error) {
+	mode, err := authMode(cfg)
+	if err != nil {
 		return nil, err
 	}
-	cfg.DataDir = logDir
-	return log.NewLog(cfg)
+	if mode == auth.ModeInsecureLocal {
+		addr, err := rpcAddr(cfg)
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("%s auth can't listen on %s", mode, addr)
+		}
+		return nil, nil
+	}
+	if tlsConfig.ServerTLSConfig == nil {
+		return nil, fmt.Errorf("%s auth needs SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE", mode)
+	}
+	return innertls.WithClientAuth(tlsConfig.ServerTLSConfig, auth.ClientAuth(mode)), nil
 }
 
 func ProvideMux(cfg *config.Env) (cmux.CMux, error) {
@@ -126,13 +311,3 @@ func ProvideMux(cfg *config.Env) (cmux.CMux, error) {
 	}
 	return cmux.New(ln), nil
 }
-
-func ProvideLogStore(cfg *config.Env, l *log.Log) (*raft.LogStore, error) {
-	logConfig := l.Config
-	logConfig.InitialOffset = 1
-	logDir := filepath.Join(cfg.DataDir, "raft", "log")
-	if err := os.MkdirAll(logDir, 0o755); err != nil {
-		return nil, err
-	}
-	return raft.NewLogStore(logConfig)
-}
@@ -12,38 +12,61 @@ import (
 	"github.com/travisjeffery/proglog/internal/membership"
 	"github.com/travisjeffery/proglog/internal/raft"
 	"github.com/travisjeffery/proglog/internal/raftapp"
+	"github.com/travisjeffery/proglog/internal/rebalance"
 	"github.com/travisjeffery/proglog/internal/service"
 )
 
 var raftSet = wire.NewSet(
-	ProvideLogStore,
 	ProvideInnerTLSConfig,
 	ProvideMux,
 	ProvideRaftArgs,
-	raft.NewStreamLayer,
-	raft.NewFSM,
-	raft.NewRaft,
+	ProvideTransports,
+	ProvideRaftServer,
+	raft.NewPartitions,
 )
 
 func InitializeService(env *config.Env) (*service.Service, error) {
 	wire.Build(
 		ProvideSegmentConfig,
-		ProvideLog,
 		raftSet,
-		raftapp.NewResource,
+		raftapp.NewResources,
 		raftapp.NewGetServers,
+		raftapp.NewAdmin,
+		raftapp.NewPeers,
+		rebalance.NewRebalancer,
+		ProvideLimiter,
+		ProvideServerArgs,
+		server.NewDrain,
 		server.NewGRPCServer,
 		raftapp.NewMembershipHandler,
+		ProvideAuditor,
 		ProvideACLArgs,
 		auth.NewAuthorizer,
+		ProvideAuthenticatorArgs,
+		auth.NewAuthenticator,
 		ProvideMembershipArgs,
 		ProvideTLSConfig,
 		membership.NewMembership,
-		wire.Bind(new(raftapp.IResource), new(*raftapp.Resource)),
+		wire.Bind(new(raftapp.IResources), new(*raftapp.Resources)),
 		wire.Bind(new(raftapp.IMembershipHandler), new(*raftapp.MembershipHandler)),
 		wire.Bind(new(raftapp.IServers), new(*raftapp.Servers)),
+		wire.Bind(new(raftapp.IAdmin), new(*raftapp.Admin)),
+		wire.Bind(new(raftapp.IPeers), new(*raftapp.Peers)),
+		wire.Bind(new(raftapp.IPolicyModel), new(*auth.Authorizer)),
+		wire.Bind(new(rebalance.IRebalancer), new(*rebalance.Rebalancer)),
 		wire.Bind(new(auth.IAuthorizer), new(*auth.Authorizer)),
+		wire.Bind(new(auth.IAuthenticator), new(*auth.Authenticator)),
+		ProvideServiceArgs,
 		service.NewService,
 	)
 	return nil, nil
 }
+
+func InitializeRecovery(env *config.Env) (*raft.Recovery, error) {
+	wire.Build(
+		ProvideSegmentConfig,
+		ProvideRaftArgs,
+		raft.NewRecovery,
+	)
+	return nil, nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/travisjeffery/proglog/internal/membership"
 	"github.com/travisjeffery/proglog/internal/raft"
 	"github.com/travisjeffery/proglog/internal/raftapp"
+	"github.com/travisjeffery/proglog/internal/rebalance"
 	"github.com/travisjeffery/proglog/internal/service"
 )
 
@@ -26,34 +27,48 @@ func InitializeService(env *config.Env) (*service.Service, error) {
 		return nil, err
 	}
 	logConfig := ProvideSegmentConfig(env)
-	log, err := ProvideLog(logConfig)
+	tlsConfig, err := ProvideInnerTLSConfig(env)
 	if err != nil {
 		return nil, err
 	}
-	logStore, err := ProvideLogStore(env, log)
+	transports, err := ProvideTransports(env, cMux, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
-	tlsConfig, err := ProvideInnerTLSConfig(env)
+	args, err := ProvideRaftArgs(env)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := raft.NewPartitions(logConfig, transports, args)
+	if err != nil {
+		return nil, err
+	}
+	resources := raftapp.NewResources(partitions)
+	authenticatorArgs, err := ProvideAuthenticatorArgs(env, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
-	streamLayer := raft.NewStreamLayer(cMux, tlsConfig)
-	args := ProvideRaftArgs(env)
-	raftRaft, err := raft.NewRaft(log, logStore, streamLayer, args)
+	authenticator, err := auth.NewAuthenticator(authenticatorArgs)
 	if err != nil {
 		return nil, err
 	}
-	resource := raftapp.NewResource(log, raftRaft)
-	authArgs := ProvideACLArgs(env)
-	authorizer := auth.NewAuthorizer(authArgs)
-	servers := raftapp.NewGetServers(raftRaft)
-	config2 := ProvideTLSConfig(tlsConfig)
-	grpcServer, err := server.NewGRPCServer(resource, authorizer, servers, config2)
+	iAuditor, err := ProvideAuditor(env)
 	if err != nil {
 		return nil, err
 	}
-	membershipHandler := raftapp.NewMembershipHandler(raftRaft)
+	authArgs := ProvideACLArgs(env, partitions, iAuditor)
+	authorizer, err := auth.NewAuthorizer(authArgs)
+	if err != nil {
+		return nil, err
+	}
+	iLimiter, err := ProvideLimiter(env)
+	if err != nil {
+		return nil, err
+	}
+	peers := raftapp.NewPeers(tlsConfig)
+	servers := raftapp.NewGetServers(partitions, peers)
+	admin := raftapp.NewAdmin(partitions, peers, authorizer)
+	membershipHandler := raftapp.NewMembershipHandler(partitions)
 	membershipArgs, err := ProvideMembershipArgs(env)
 	if err != nil {
 		return nil, err
@@ -62,15 +77,42 @@ func InitializeService(env *config.Env) (*service.Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	serviceService := service.NewService(cMux, raftRaft, grpcServer, membershipMembership)
+	rebalancer := rebalance.NewRebalancer(membershipMembership, peers)
+	raftServer := ProvideRaftServer(transports)
+	drain := server.NewDrain()
+	config2, err := ProvideTLSConfig(env, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	serverArgs := ProvideServerArgs(env)
+	grpcServer, err := server.NewGRPCServer(resources, authenticator, authorizer, iLimiter, iAuditor, servers, admin, rebalancer, raftServer, drain, config2, serverArgs)
+	if err != nil {
+		return nil, err
+	}
+	serviceArgs := ProvideServiceArgs(env)
+	serviceService := service.NewService(cMux, partitions, grpcServer, membershipMembership, drain, iAuditor, serviceArgs)
 	return serviceService, nil
 }
 
+func InitializeRecovery(env *config.Env) (*raft.Recovery, error) {
+	logConfig := ProvideSegmentConfig(env)
+	args, err := ProvideRaftArgs(env)
+	if err != nil {
+		return nil, err
+	}
+	recovery, err := raft.NewRecovery(logConfig, args)
+	if err != nil {
+		return nil, err
+	}
+	return recovery, nil
+}
+
 // wire.go:
 
 var raftSet = wire.NewSet(
-	ProvideLogStore,
 	ProvideInnerTLSConfig,
 	ProvideMux,
-	ProvideRaftArgs, raft.NewStreamLayer, raft.NewFSM, raft.NewRaft,
+	ProvideRaftArgs,
+	ProvideTransports,
+	ProvideRaftServer, raft.NewPartitions,
 )
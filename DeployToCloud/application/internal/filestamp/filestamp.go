@@ -0,0 +1,23 @@
+// Package filestamp tells whether a file that's reloaded on edit changed
+// since it was last read, by its modification time and size.
+package filestamp
+
+import (
+	"os"
+	"time"
+)
+
+// Stamp is comparable, so a file changed when its stamps differ.
+type Stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Of returns the file's current stamp.
+func Of(file string) (Stamp, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return Stamp{}, err
+	}
+	return Stamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
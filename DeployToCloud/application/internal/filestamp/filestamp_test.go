@@ -0,0 +1,29 @@
+package filestamp_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/filestamp"
+)
+
+func TestOf(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, []byte("a"), 0o600))
+	first, err := Of(file)
+	require.NoError(t, err)
+	again, err := Of(file)
+	require.NoError(t, err)
+	require.Equal(t, first, again)
+
+	require.NoError(t, os.WriteFile(file, []byte("ab"), 0o600))
+	edited, err := Of(file)
+	require.NoError(t, err)
+	require.NotEqual(t, first, edited)
+
+	_, err = Of(filepath.Join(t.TempDir(), "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
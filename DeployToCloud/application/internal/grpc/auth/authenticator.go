@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ModeMTLS requires a verified client certificate.
+	ModeMTLS = "mtls"
+	// ModeOptionalMTLS authenticates clients without a certificate as
+	// AnonymousSubject.
+	ModeOptionalMTLS = "optional-mtls"
+	// ModeBearer requires a bearer token, or a verified client certificate
+	// so that peers keep working.
+	ModeBearer = "bearer"
+	// ModeJWT is ModeBearer with the tokens verified as JWTs.
+	ModeJWT = "jwt"
+	// ModeInsecureLocal serves plaintext to loopback clients only, all
+	// authenticated as LocalSubject.
+	ModeInsecureLocal = "insecure-local"
+
+	AnonymousSubject = "anonymous"
+	LocalSubject     = "local"
+)
+
+type IAuthenticator interface {
+	// Authenticate returns the subject the Authorizer checks the caller's
+	// requests against.
+	Authenticate(ctx context.Context) (string, error)
+}
+
+// ITokenVerifier maps a bearer token to its subject.
+type ITokenVerifier interface {
+	Verify(token string) (string, error)
+}
+
+type Authenticator struct {
+	mode             string
+	tokens           ITokenVerifier
+	certSubject      *CertSubject
+	listenerVerifies bool
+}
+
+type AuthenticatorArgs struct {
+	Mode string
+	// Tokens verifies bearer tokens in ModeBearer and ModeJWT.
+	Tokens ITokenVerifier
+	// CertSubject maps client certificates to subjects, by their common
+	// name when it's nil.
+	CertSubject *CertSubject
+	// ListenerVerifies is set when the listener's TLS config verifies client
+	// certificates in VerifyPeerCertificate, as a CertWatcher's does, which
+	// leaves VerifiedChains empty. Only then is a certificate without a
+	// verified chain taken.
+	ListenerVerifies bool
+}
+
+func NewAuthenticator(args AuthenticatorArgs) (*Authenticator, error) {
+	if err := ValidateMode(args.Mode); err != nil {
+		return nil, err
+	}
+	if isBearer(args.Mode) && args.Tokens == nil {
+		return nil, fmt.Errorf("%s auth needs a token verifier", args.Mode)
+	}
+	if args.CertSubject == nil {
+		args.CertSubject = &CertSubject{source: SourceCommonName}
+	}
+	return &Authenticator{
+		mode:             args.Mode,
+		tokens:           args.Tokens,
+		certSubject:      args.CertSubject,
+		listenerVerifies: args.ListenerVerifies,
+	}, nil
+}
+
+func ValidateMode(mode string) error {
+	switch mode {
+	case ModeMTLS, ModeOptionalMTLS, ModeBearer, ModeJWT, ModeInsecureLocal:
+		return nil
+	}
+	return fmt.Errorf("unknown auth mode: %s", mode)
+}
+
+// ClientAuth returns how a listener in mode verifies client certificates.
+func ClientAuth(mode string) tls.ClientAuthType {
+	if mode == ModeMTLS {
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.VerifyClientCertIfGiven
+}
+
+func (a *Authenticator) Authenticate(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.New(codes.Unknown, "couldn't find peer info").Err()
+	}
+	if a.mode == ModeInsecureLocal {
+		if !isLoopback(p.Addr) {
+			return "", status.Newf(codes.Unauthenticated, "%s isn't a local client", p.Addr).Err()
+		}
+		return LocalSubject, nil
+	}
+	if isBearer(a.mode) {
+		if token, err := grpc_auth.AuthFromMD(ctx, "bearer"); err == nil {
+			subject, err := a.tokens.Verify(token)
+			if err != nil {
+				return "", status.New(codes.Unauthenticated, err.Error()).Err()
+			}
+			return subject, nil
+		}
+	}
+	if p.AuthInfo == nil {
+		return "", status.New(codes.Unauthenticated, "no transport security being used").Err()
+	}
+	t, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return "", status.New(codes.Unauthenticated, "failed to cast AuthInfo").Err()
+	}
+	if cert := a.clientCertificate(t.State); cert != nil {
+		subject, err := a.certSubject.Subject(cert)
+		if err != nil {
+			return "", status.New(codes.Unauthenticated, err.Error()).Err()
+		}
+		return subject, nil
+	}
+	if a.mode == ModeOptionalMTLS {
+		return AnonymousSubject, nil
+	}
+	return "", status.New(codes.Unauthenticated, "no verified client certificate").Err()
+}
+
+// clientCertificate returns the client's verified certificate. Listeners
+// whose CAs reload verify it in VerifyPeerCertificate, which leaves
+// VerifiedChains empty, so their presented certificate is taken instead.
+func (a *Authenticator) clientCertificate(state tls.ConnectionState) *x509.Certificate {
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		return state.VerifiedChains[0][0]
+	}
+	if a.listenerVerifies && len(state.PeerCertificates) > 0 {
+		return state.PeerCertificates[0]
+	}
+	return nil
+}
+
+func isBearer(mode string) bool {
+	return mode == ModeBearer || mode == ModeJWT
+}
+
+func isLoopback(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
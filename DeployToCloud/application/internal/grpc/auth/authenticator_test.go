@@ -0,0 +1,103 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	. "github.com/travisjeffery/proglog/internal/grpc/auth"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("# tokens\nalice s3cret\n"), 0o600))
+	tokens, err := NewTokens(tokensFile)
+	require.NoError(t, err)
+
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	for scenario, tc := range map[string]struct {
+		mode             string
+		certSubject      string
+		listenerVerifies bool
+		ctx              context.Context
+		subject          string
+	}{
+		"mtls takes the certificate's common name":       {mode: ModeMTLS, ctx: withCert(local, "root"), subject: "root"},
+		"mtls rejects tls without a certificate":         {mode: ModeMTLS, ctx: withCert(local, "")},
+		"mtls takes a certificate the callback verified": {mode: ModeMTLS, listenerVerifies: true, ctx: withPeerCert(local, "root"), subject: "root"},
+		"mtls rejects an unverified certificate":         {mode: ModeMTLS, ctx: withPeerCert(local, "root")},
+		"mtls maps the certificate with the rule":        {mode: ModeMTLS, certSubject: "cn:^r(oo)t$", ctx: withCert(local, "root"), subject: "oo"},
+		"mtls rejects a certificate the rule can't map":  {mode: ModeMTLS, certSubject: "uri", ctx: withCert(local, "root")},
+		"mtls rejects plaintext":                         {mode: ModeMTLS, ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: local})},
+		"optional mtls takes the certificate":            {mode: ModeOptionalMTLS, ctx: withCert(local, "root"), subject: "root"},
+		"optional mtls is anonymous without one":         {mode: ModeOptionalMTLS, ctx: withCert(local, ""), subject: AnonymousSubject},
+		"bearer takes the token's subject":               {mode: ModeBearer, ctx: withToken(withCert(local, ""), "s3cret"), subject: "alice"},
+		"bearer rejects an unknown token":                {mode: ModeBearer, ctx: withToken(withCert(local, "root"), "guess")},
+		"bearer falls back to the certificate":           {mode: ModeBearer, ctx: withCert(local, "root"), subject: "root"},
+		"bearer rejects a caller without either":         {mode: ModeBearer, ctx: withCert(local, "")},
+		"insecure local accepts loopback clients":        {mode: ModeInsecureLocal, ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: local}), subject: LocalSubject},
+		"insecure local rejects remote clients":          {mode: ModeInsecureLocal, ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: remote})},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			certSubject, err := ParseCertSubject(tc.certSubject)
+			require.NoError(t, err)
+			authenticator, err := NewAuthenticator(AuthenticatorArgs{
+				Mode:             tc.mode,
+				Tokens:           tokens,
+				CertSubject:      certSubject,
+				ListenerVerifies: tc.listenerVerifies,
+			})
+			require.NoError(t, err)
+			subject, err := authenticator.Authenticate(tc.ctx)
+			if tc.subject == "" {
+				require.Equal(t, codes.Unauthenticated, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.subject, subject)
+		})
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	_, err := NewAuthenticator(AuthenticatorArgs{Mode: "kerberos"})
+	require.Error(t, err)
+	_, err = NewAuthenticator(AuthenticatorArgs{Mode: ModeBearer})
+	require.Error(t, err)
+	_, err = NewAuthenticator(AuthenticatorArgs{Mode: ModeJWT})
+	require.Error(t, err)
+}
+
+// withCert returns the context of a tls peer that presented a certificate
+// with the common name, or none when it's empty.
+func withCert(addr net.Addr, commonName string) context.Context {
+	var state tls.ConnectionState
+	if commonName != "" {
+		state.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr, AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+// withPeerCert is withCert for listeners verifying certificates in
+// VerifyPeerCertificate, which leaves VerifiedChains empty.
+func withPeerCert(addr net.Addr, commonName string) context.Context {
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: commonName}}}}
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr, AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+}
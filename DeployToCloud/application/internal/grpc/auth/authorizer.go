@@ -1,36 +1,349 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/casbin/casbin"
+	"github.com/casbin/casbin/model"
+	"github.com/casbin/casbin/persist"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"github.com/travisjeffery/proglog/internal/audit"
+	"github.com/travisjeffery/proglog/internal/filestamp"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+)
+
+const defaultReloadInterval = time.Second
+
+var (
+	ErrInvalidPolicy  = errors.New("invalid policy rule")
+	errReadOnlyPolicy = errors.New("the replicated policy changes through raft")
 )
 
 type IAuthorizer interface {
-	Authorize(subject, object, action string) error
+	// Authorize decides whether the subject may take the action on the
+	// object. ctx is the caller's, for the audit log.
+	Authorize(ctx context.Context, subject, object, action string) error
 }
 
+// IPolicyStore is the replicated policy, which replaces the policy file once
+// it's seeded.
+type IPolicyStore interface {
+	// Version changes whenever the policies do.
+	Version() uint64
+	Policies() (policies []*pb.Policy, version uint64, seeded bool)
+}
+
+// Authorizer enforces the casbin model and policy files. The files are
+// checked for changes at most every ReloadInterval and a valid edit replaces
+// the enforcer in place, while an invalid one is logged and ignored. Changes
+// of the replicated policy are picked up by the next request.
 type Authorizer struct {
-	enforcer *casbin.Enforcer
+	args     Args
+	enforcer atomic.Value // *casbin.Enforcer
+
+	mu        sync.Mutex
+	nextCheck int64
+	stamps    [2]filestamp.Stamp
+	version   uint64
+	logger    *zap.Logger
 }
 
 type Args struct {
 	ModelFile  string
 	PolicyFile string
+	// ReloadInterval is how often the files are checked for changes, 1s
+	// when zero.
+	ReloadInterval time.Duration
+	// Store is the replicated policy, the policy file is used alone when
+	// it's nil.
+	Store IPolicyStore
+	// Auditor records every decision when it's set.
+	Auditor audit.IAuditor
 }
 
-func NewAuthorizer(args Args) *Authorizer {
-	enforcer := casbin.NewEnforcer(args.ModelFile, args.PolicyFile)
-	return &Authorizer{enforcer: enforcer}
+func NewAuthorizer(args Args) (*Authorizer, error) {
+	if args.ReloadInterval <= 0 {
+		args.ReloadInterval = defaultReloadInterval
+	}
+	a := &Authorizer{args: args, logger: zap.L().Named("authorizer")}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
-func (a *Authorizer) Authorize(subject, object, action string) error {
-	if a.enforcer.Enforce(subject, object, action) {
+func (a *Authorizer) Authorize(ctx context.Context, subject, object, action string) error {
+	a.maybeReload()
+	//nolint:forcetypeassert //reason: only enforcers are stored
+	allowed := a.enforcer.Load().(*casbin.Enforcer).Enforce(subject, object, action)
+	if a.args.Auditor != nil {
+		decision := audit.Deny
+		if allowed {
+			decision = audit.Allow
+		}
+		a.args.Auditor.Record(ctx, audit.Event{
+			Kind:     audit.KindAuthorization,
+			Subject:  subject,
+			Action:   action,
+			Object:   object,
+			Decision: decision,
+		})
+	}
+	if allowed {
 		return nil
 	}
 	msg := fmt.Sprintf("%s not permitted to %s to %s", subject, action, object)
 	st := status.New(codes.PermissionDenied, msg)
 	return st.Err()
 }
+
+// Reload reads the model file and the replicated policy, or the policy file
+// while it isn't seeded, and swaps the enforcer in when they're valid.
+func (a *Authorizer) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reload()
+}
+
+// FilePolicies returns the rules of the policy file, which seed the
+// replicated policy.
+func (a *Authorizer) FilePolicies() ([]*pb.Policy, error) {
+	enforcer, err := newEnforcer(a.args.ModelFile, a.args.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(enforcer); err != nil {
+		return nil, err
+	}
+	return policies(enforcer.GetModel()), nil
+}
+
+// ValidatePolicy checks the rule against the model.
+func (a *Authorizer) ValidatePolicy(policy *pb.Policy) error {
+	//nolint:forcetypeassert //reason: only enforcers are stored
+	m := a.enforcer.Load().(*casbin.Enforcer).GetModel()
+	want, ok := ruleFields(m, policy.GetPtype())
+	if !ok {
+		return fmt.Errorf("%w: unknown rule type %q", ErrInvalidPolicy, policy.GetPtype())
+	}
+	if len(policy.GetFields()) != want {
+		return fmt.Errorf("%w: %s rules have %d fields", ErrInvalidPolicy, policy.GetPtype(), want)
+	}
+	for _, field := range policy.GetFields() {
+		if field == "" {
+			return fmt.Errorf("%w: empty field", ErrInvalidPolicy)
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) maybeReload() {
+	now := time.Now().UnixNano()
+	replicated := a.storeChanged()
+	if !replicated && now < atomic.LoadInt64(&a.nextCheck) {
+		return
+	}
+	// wait for a replicated change so that it applies to this request
+	if replicated {
+		a.mu.Lock()
+	} else if !a.mu.TryLock() {
+		return
+	}
+	defer a.mu.Unlock()
+	changed := a.storeChanged()
+	if now >= atomic.LoadInt64(&a.nextCheck) {
+		atomic.StoreInt64(&a.nextCheck, now+int64(a.args.ReloadInterval))
+		stamps, err := a.stampFiles()
+		if err != nil {
+			a.logger.Error("failed to check acl file", zap.Error(err))
+			return
+		}
+		changed = changed || stamps != a.stamps
+	}
+	if !changed {
+		return
+	}
+	if err := a.reload(); err != nil {
+		a.logger.Error("rejected acl edit, keeping the previous policy",
+			zap.String("model", a.args.ModelFile),
+			zap.String("policy", a.args.PolicyFile),
+			zap.Bool("replicated", a.storeSeeded()),
+			zap.Error(err))
+		return
+	}
+	a.logger.Info("reloaded acl policy",
+		zap.String("policy", a.args.PolicyFile),
+		zap.Bool("replicated", a.storeSeeded()))
+}
+
+func (a *Authorizer) storeChanged() bool {
+	return a.args.Store != nil && a.args.Store.Version() != atomic.LoadUint64(&a.version)
+}
+
+func (a *Authorizer) storeSeeded() bool {
+	if a.args.Store == nil {
+		return false
+	}
+	_, _, seeded := a.args.Store.Policies()
+	return seeded
+}
+
+func (a *Authorizer) stampFiles() ([2]filestamp.Stamp, error) {
+	var stamps [2]filestamp.Stamp
+	for i, file := range []string{a.args.ModelFile, a.args.PolicyFile} {
+		var err error
+		if stamps[i], err = filestamp.Of(file); err != nil {
+			return stamps, err
+		}
+	}
+	return stamps, nil
+}
+
+// reload remembers the files and replicated policy it read even when they're
+// invalid, so that the same edit isn't retried.
+func (a *Authorizer) reload() error {
+	stamps, err := a.stampFiles()
+	if err != nil {
+		return err
+	}
+	a.stamps = stamps
+	var rules []*pb.Policy
+	seeded := false
+	if a.args.Store != nil {
+		var version uint64
+		rules, version, seeded = a.args.Store.Policies()
+		atomic.StoreUint64(&a.version, version)
+	}
+	var enforcer *casbin.Enforcer
+	if seeded {
+		enforcer, err = newEnforcer(a.args.ModelFile)
+		if err == nil {
+			// the enforcer ignores errors loading its adapter's policy
+			enforcer.SetAdapter(policyAdapter(rules))
+			err = enforcer.LoadPolicy()
+		}
+	} else {
+		enforcer, err = newEnforcer(a.args.ModelFile, a.args.PolicyFile)
+	}
+	if err != nil {
+		return err
+	}
+	if err := validate(enforcer); err != nil {
+		return err
+	}
+	a.enforcer.Store(enforcer)
+	return nil
+}
+
+// newEnforcer creates an enforcer whose matchers may also call spiffeMatch
+// and objectMatch.
+func newEnforcer(params ...interface{}) (*casbin.Enforcer, error) {
+	enforcer, err := casbin.NewEnforcerSafe(params...)
+	if err != nil {
+		return nil, err
+	}
+	enforcer.AddFunction("spiffeMatch", spiffeMatchFunc)
+	enforcer.AddFunction("objectMatch", objectMatchFunc)
+	return enforcer, nil
+}
+
+// validate rejects policies that would deny everyone: no allow rules, rules
+// with the wrong number of fields, or a matcher that fails to evaluate.
+func validate(enforcer *casbin.Enforcer) error {
+	m := enforcer.GetModel()
+	p, ok := m["p"]["p"]
+	if !ok {
+		return errors.New("model has no policy definition")
+	}
+	if len(p.Policy) == 0 {
+		return errors.New("policy has no rules")
+	}
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range m[sec] {
+			want, _ := ruleFields(m, ptype)
+			for _, rule := range ast.Policy {
+				if len(rule) != want {
+					return fmt.Errorf("%s rule %v doesn't have %d fields", ptype, rule, want)
+				}
+			}
+		}
+	}
+	rvals := make([]interface{}, len(m["r"]["r"].Tokens))
+	for i := range rvals {
+		rvals[i] = ""
+	}
+	_, err := enforcer.EnforceSafe(rvals...)
+	return err
+}
+
+// ruleFields returns how many fields rules of type ptype have.
+func ruleFields(m model.Model, ptype string) (int, bool) {
+	if p, ok := m["p"][ptype]; ok {
+		return len(p.Tokens), true
+	}
+	// role definitions have no tokens, their value is like "_, _"
+	if g, ok := m["g"][ptype]; ok {
+		return strings.Count(g.Value, "_"), true
+	}
+	return 0, false
+}
+
+// policies returns the model's rules, ordered by type.
+func policies(m model.Model) []*pb.Policy {
+	var rules []*pb.Policy
+	for _, sec := range []string{"p", "g"} {
+		ptypes := make([]string, 0, len(m[sec]))
+		for ptype := range m[sec] {
+			ptypes = append(ptypes, ptype)
+		}
+		sort.Strings(ptypes)
+		for _, ptype := range ptypes {
+			for _, rule := range m[sec][ptype].Policy {
+				rules = append(rules, &pb.Policy{Ptype: ptype, Fields: rule})
+			}
+		}
+	}
+	return rules
+}
+
+// policyAdapter loads the replicated rules into casbin's model. It's read
+// only: rules change through raft.
+type policyAdapter []*pb.Policy
+
+var _ persist.Adapter = policyAdapter(nil)
+
+func (a policyAdapter) LoadPolicy(m model.Model) error {
+	for _, rule := range a {
+		if _, ok := ruleFields(m, rule.Ptype); !ok {
+			return fmt.Errorf("%w: unknown rule type %q", ErrInvalidPolicy, rule.Ptype)
+		}
+		m.AddPolicy(rule.Ptype[:1], rule.Ptype, rule.Fields)
+	}
+	return nil
+}
+
+func (a policyAdapter) SavePolicy(model.Model) error {
+	return errReadOnlyPolicy
+}
+
+func (a policyAdapter) AddPolicy(string, string, []string) error {
+	return errReadOnlyPolicy
+}
+
+func (a policyAdapter) RemovePolicy(string, string, []string) error {
+	return errReadOnlyPolicy
+}
+
+func (a policyAdapter) RemoveFilteredPolicy(string, string, int, ...string) error {
+	return errReadOnlyPolicy
+}
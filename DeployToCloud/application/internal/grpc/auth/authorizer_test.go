@@ -0,0 +1,207 @@
+package auth_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/travisjeffery/proglog/internal/grpc/auth"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	innertls "github.com/travisjeffery/proglog/internal/tls"
+	"github.com/travisjeffery/proglog/test"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(test.Run(m))
+}
+
+const policy = `p, root, *, produce
+p, root, *, consume
+p, alice, orders, produce
+p, carol, orders/1, consume
+p, writers, payments*, produce
+p, spiffe://example.org/ns/payments, payments*, produce
+p, spiffe://example.org, metrics, consume
+g, bob, writers
+`
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	rewrite(t, policyFile, []byte(policy))
+	authorizer, err := NewAuthorizer(Args{ModelFile: innertls.ACLModelFile, PolicyFile: policyFile})
+	require.NoError(t, err)
+
+	for scenario, tc := range map[string]struct {
+		subject, object, action string
+		allowed                 bool
+	}{
+		"wildcard object covers every log":   {subject: "root", object: "orders/0", action: "consume", allowed: true},
+		"rule for the log":                   {subject: "alice", object: "orders/0", action: "produce", allowed: true},
+		"rule for the log covers partitions": {subject: "alice", object: "orders/3", action: "produce", allowed: true},
+		"rule for another log":               {subject: "alice", object: "payments/0", action: "produce"},
+		"rule for another action":            {subject: "alice", object: "orders/0", action: "consume"},
+		"rule for the partition":             {subject: "carol", object: "orders/1", action: "consume", allowed: true},
+		"rule for another partition":         {subject: "carol", object: "orders/0", action: "consume"},
+		"partition rule for another log":     {subject: "carol", object: "payments/1", action: "consume"},
+		"inherited from a role":              {subject: "bob", object: "payments-eu/0", action: "produce", allowed: true},
+		"role's prefix doesn't match":        {subject: "bob", object: "orders/0", action: "produce"},
+		"unknown subject":                    {subject: "nobody", object: "orders/0", action: "produce"},
+		"spiffe id under a path prefix":      {subject: "spiffe://example.org/ns/payments/sa/api", object: "payments/0", action: "produce", allowed: true},
+		"spiffe id of another path":          {subject: "spiffe://example.org/ns/payments-eu/sa/api", object: "payments/0", action: "produce"},
+		"spiffe id of the trust domain":      {subject: "spiffe://example.org/ns/orders/sa/api", object: "metrics/0", action: "consume", allowed: true},
+		"spiffe id of another domain":        {subject: "spiffe://example.com/ns/payments/sa/api", object: "payments/0", action: "produce"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			err := authorizer.Authorize(ctx, tc.subject, tc.object, tc.action)
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.Equal(t, codes.PermissionDenied, status.Code(err))
+			}
+		})
+	}
+}
+
+func TestAuthorizerReload(t *testing.T) {
+	ctx := context.Background()
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	rewrite(t, policyFile, []byte(policy))
+	authorizer, err := NewAuthorizer(Args{
+		ModelFile:      innertls.ACLModelFile,
+		PolicyFile:     policyFile,
+		ReloadInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.Error(t, authorizer.Authorize(ctx, "carol", "orders/0", "consume"))
+
+	rewrite(t, policyFile, []byte(policy+"p, carol, orders, consume\n"))
+	require.Eventually(t, func() bool {
+		return authorizer.Authorize(ctx, "carol", "orders/0", "consume") == nil
+	}, time.Second, 5*time.Millisecond)
+
+	for scenario, edit := range map[string]string{
+		"empty policy":        "",
+		"rule missing fields": policy + "p, carol, orders\n",
+		"unknown rule type":   policy + "x, carol, orders, produce\n",
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			rewrite(t, policyFile, []byte(edit))
+			require.Error(t, authorizer.Reload())
+			time.Sleep(5 * time.Millisecond)
+			require.NoError(t, authorizer.Authorize(ctx, "carol", "orders/0", "consume"))
+			require.NoError(t, authorizer.Authorize(ctx, "root", "orders/0", "produce"))
+		})
+	}
+}
+
+func TestNewAuthorizerRejectsInvalidPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	rewrite(t, policyFile, []byte("p, root\n"))
+	_, err := NewAuthorizer(Args{ModelFile: innertls.ACLModelFile, PolicyFile: policyFile})
+	require.Error(t, err)
+}
+
+func TestAuthorizerReplicatedPolicy(t *testing.T) {
+	ctx := context.Background()
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	rewrite(t, policyFile, []byte(policy))
+	store := &policyStore{}
+	authorizer, err := NewAuthorizer(Args{
+		ModelFile:      innertls.ACLModelFile,
+		PolicyFile:     policyFile,
+		ReloadInterval: time.Hour,
+		Store:          store,
+	})
+	require.NoError(t, err)
+	// the policy file applies until the replicated policy is seeded
+	require.NoError(t, authorizer.Authorize(ctx, "alice", "orders/0", "produce"))
+
+	// a replicated change applies to the next request
+	store.set(
+		&pb.Policy{Ptype: "p", Fields: []string{"writers", "orders/0", "produce"}},
+		&pb.Policy{Ptype: "g", Fields: []string{"carol", "writers"}},
+	)
+	require.NoError(t, authorizer.Authorize(ctx, "carol", "orders/0", "produce"))
+	require.Error(t, authorizer.Authorize(ctx, "alice", "orders/0", "produce"))
+
+	// and an invalid one keeps the previous policy
+	store.set(
+		&pb.Policy{Ptype: "p", Fields: []string{"root", "*", "produce"}},
+		&pb.Policy{Ptype: "x", Fields: []string{"carol"}},
+	)
+	require.NoError(t, authorizer.Authorize(ctx, "carol", "orders/0", "produce"))
+	require.Error(t, authorizer.Authorize(ctx, "root", "orders/0", "produce"))
+}
+
+func TestFilePolicies(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	rewrite(t, policyFile, []byte(policy))
+	authorizer, err := NewAuthorizer(Args{ModelFile: innertls.ACLModelFile, PolicyFile: policyFile})
+	require.NoError(t, err)
+	policies, err := authorizer.FilePolicies()
+	require.NoError(t, err)
+	require.Len(t, policies, 8)
+	require.Equal(t, "p", policies[0].Ptype)
+	require.Equal(t, []string{"root", "*", "produce"}, policies[0].Fields)
+	require.Equal(t, "g", policies[7].Ptype)
+	require.Equal(t, []string{"bob", "writers"}, policies[7].Fields)
+}
+
+func TestValidatePolicy(t *testing.T) {
+	authorizer, err := NewAuthorizer(Args{ModelFile: innertls.ACLModelFile, PolicyFile: innertls.ACLPolicyFile})
+	require.NoError(t, err)
+	for scenario, tc := range map[string]struct {
+		policy *pb.Policy
+		valid  bool
+	}{
+		"allow rule":        {policy: &pb.Policy{Ptype: "p", Fields: []string{"alice", "orders", "produce"}}, valid: true},
+		"role":              {policy: &pb.Policy{Ptype: "g", Fields: []string{"alice", "writers"}}, valid: true},
+		"missing fields":    {policy: &pb.Policy{Ptype: "p", Fields: []string{"alice", "orders"}}},
+		"empty field":       {policy: &pb.Policy{Ptype: "g", Fields: []string{"alice", ""}}},
+		"unknown rule type": {policy: &pb.Policy{Ptype: "x", Fields: []string{"alice"}}},
+		"no rule":           {},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			err := authorizer.ValidatePolicy(tc.policy)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrInvalidPolicy)
+			}
+		})
+	}
+}
+
+// policyStore is a replicated policy changed by set.
+type policyStore struct {
+	mu       sync.Mutex
+	version  uint64
+	policies []*pb.Policy
+}
+
+func (s *policyStore) set(policies ...*pb.Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+	s.version++
+}
+
+func (s *policyStore) Version() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+func (s *policyStore) Policies() ([]*pb.Policy, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policies, s.version, s.version > 0
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SourceCommonName takes the subject from the certificate's common name.
+	SourceCommonName = "cn"
+	// SourceURI takes it from a URI SAN, like a SPIFFE ID.
+	SourceURI = "uri"
+	// SourceDNS takes it from a DNS SAN.
+	SourceDNS = "dns"
+
+	subjectGroup = "subject"
+)
+
+// CertSubject maps a client certificate to its subject with a rule:
+//
+//	source[:regexp]
+//
+// where source is cn, uri or dns. The subject is the first of the
+// certificate's names from the source that matches the regexp, or the part
+// of it the group named subject, or else the first group, captured. E.g.
+// uri:^spiffe://example\.org/ takes the SPIFFE ID of the example.org trust
+// domain, and dns:^(.+)\.clients\.example\.org$ the host of a DNS SAN.
+type CertSubject struct {
+	source string
+	re     *regexp.Regexp
+	group  int
+}
+
+// ParseCertSubject parses the rule, an empty one takes the common name.
+func ParseCertSubject(rule string) (*CertSubject, error) {
+	source, expr, hasExpr := strings.Cut(rule, ":")
+	if source == "" {
+		source = SourceCommonName
+	}
+	switch source {
+	case SourceCommonName, SourceURI, SourceDNS:
+	default:
+		return nil, fmt.Errorf("unknown certificate subject source: %s", source)
+	}
+	s := &CertSubject{source: source}
+	if !hasExpr {
+		return s, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate subject rule %q: %w", rule, err)
+	}
+	s.re = re
+	if s.group = re.SubexpIndex(subjectGroup); s.group < 0 && re.NumSubexp() > 0 {
+		s.group = 1
+	}
+	return s, nil
+}
+
+// Subject returns the subject of the certificate, or an error when none of
+// its names match the rule.
+func (s *CertSubject) Subject(cert *x509.Certificate) (string, error) {
+	for _, name := range s.names(cert) {
+		if s.re == nil {
+			return name, nil
+		}
+		m := s.re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		if s.group > 0 {
+			if m[s.group] == "" {
+				continue
+			}
+			return m[s.group], nil
+		}
+		return name, nil
+	}
+	if s.re == nil {
+		return "", fmt.Errorf("certificate has no %s", s.source)
+	}
+	return "", fmt.Errorf("certificate has no %s matching %s", s.source, s.re)
+}
+
+func (s *CertSubject) names(cert *x509.Certificate) []string {
+	switch s.source {
+	case SourceURI:
+		names := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			names = append(names, uri.String())
+		}
+		return names
+	case SourceDNS:
+		return cert.DNSNames
+	}
+	if cert.Subject.CommonName == "" {
+		return nil
+	}
+	return []string{cert.Subject.CommonName}
+}
+
+// SpiffeMatch tells whether the SPIFFE ID is in the pattern's trust domain
+// and its path is the pattern's path or below it, so that
+// spiffe://example.org matches the whole trust domain and
+// spiffe://example.org/ns/x every workload of namespace x.
+func SpiffeMatch(id, pattern string) bool {
+	idURL, err := parseSpiffeID(id)
+	if err != nil {
+		return false
+	}
+	patternURL, err := parseSpiffeID(pattern)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(idURL.Host, patternURL.Host) {
+		return false
+	}
+	prefix := strings.TrimSuffix(patternURL.Path, "/")
+	return idURL.Path == prefix || strings.HasPrefix(idURL.Path, prefix+"/")
+}
+
+// spiffeMatchFunc is SpiffeMatch for casbin matchers.
+func spiffeMatchFunc(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("spiffeMatch takes an id and a pattern")
+	}
+	id, _ := args[0].(string)
+	pattern, _ := args[1].(string)
+	return SpiffeMatch(id, pattern), nil
+}
+
+func parseSpiffeID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("not a spiffe id: %s", id)
+	}
+	// dot segments would escape the path prefix
+	if u.Path != "" && path.Clean(u.Path) != u.Path && path.Clean(u.Path)+"/" != u.Path {
+		return nil, fmt.Errorf("spiffe id has dot segments: %s", id)
+	}
+	return u, nil
+}
@@ -0,0 +1,75 @@
+package auth_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/grpc/auth"
+)
+
+func TestCertSubject(t *testing.T) {
+	workload := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "api"},
+		DNSNames: []string{"api.payments.svc", "api.clients.example.org"},
+		URIs: []*url.URL{
+			{Scheme: "https", Host: "example.org", Path: "/api"},
+			{Scheme: "spiffe", Host: "example.org", Path: "/ns/payments/sa/api"},
+		},
+	}
+	for scenario, tc := range map[string]struct {
+		rule    string
+		subject string
+	}{
+		"empty rule takes the common name":    {rule: "", subject: "api"},
+		"common name":                         {rule: "cn", subject: "api"},
+		"first uri san":                       {rule: "uri", subject: "https://example.org/api"},
+		"uri san matching the regexp":         {rule: `uri:^spiffe://example\.org/`, subject: "spiffe://example.org/ns/payments/sa/api"},
+		"first group of the regexp":           {rule: `uri:^spiffe://example\.org/ns/([^/]+)/`, subject: "payments"},
+		"named group of the regexp":           {rule: `dns:^(.+)\.(?P<subject>[^.]+)\.svc$`, subject: "payments"},
+		"dns san matching the regexp":         {rule: `dns:\.example\.org$`, subject: "api.clients.example.org"},
+		"no san matching the regexp":          {rule: `uri:^spiffe://example\.com/`},
+		"common name not matching the regexp": {rule: "cn:^root$"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			certSubject, err := ParseCertSubject(tc.rule)
+			require.NoError(t, err)
+			subject, err := certSubject.Subject(workload)
+			if tc.subject == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.subject, subject)
+		})
+	}
+
+	_, err := ParseCertSubject("email")
+	require.Error(t, err)
+	_, err = ParseCertSubject("uri:(")
+	require.Error(t, err)
+}
+
+func TestSpiffeMatch(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		id, pattern string
+		match       bool
+	}{
+		"same id":                   {id: "spiffe://example.org/ns/x/sa/y", pattern: "spiffe://example.org/ns/x/sa/y", match: true},
+		"trust domain":              {id: "spiffe://example.org/ns/x/sa/y", pattern: "spiffe://example.org", match: true},
+		"trust domain's case":       {id: "spiffe://Example.org/ns/x/sa/y", pattern: "spiffe://example.org", match: true},
+		"path prefix":               {id: "spiffe://example.org/ns/x/sa/y", pattern: "spiffe://example.org/ns/x/", match: true},
+		"prefix of a path segment":  {id: "spiffe://example.org/ns/xy/sa/y", pattern: "spiffe://example.org/ns/x"},
+		"another trust domain":      {id: "spiffe://example.com/ns/x/sa/y", pattern: "spiffe://example.org"},
+		"dot segments":              {id: "spiffe://example.org/ns/x/../y/sa/z", pattern: "spiffe://example.org/ns/x"},
+		"not a spiffe id":           {id: "https://example.org/ns/x", pattern: "spiffe://example.org"},
+		"pattern isn't a spiffe id": {id: "root", pattern: "root"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, tc.match, SpiffeMatch(tc.id, tc.pattern))
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/filestamp"
+)
+
+const defaultSubjectClaim = "sub"
+
+// JWTVerifier verifies bearer tokens that are JWTs signed by a key of a
+// JWKS file, which is read again whenever it changes on disk.
+type JWTVerifier struct {
+	file         string
+	issuer       string
+	audience     string
+	subjectClaim string
+	parser       *jwt.Parser
+
+	mu     sync.Mutex
+	stamp  filestamp.Stamp
+	keys   map[string]crypto.PublicKey
+	logger *zap.Logger
+}
+
+type JWTArgs struct {
+	JWKSFile string
+	Issuer   string
+	Audience string
+	// SubjectClaim is the claim holding the casbin subject, sub when empty.
+	SubjectClaim string
+}
+
+var _ ITokenVerifier = (*JWTVerifier)(nil)
+
+func NewJWTVerifier(args JWTArgs) (*JWTVerifier, error) {
+	if args.JWKSFile == "" || args.Issuer == "" || args.Audience == "" {
+		return nil, errors.New("jwt auth needs a jwks file, an issuer and an audience")
+	}
+	if args.SubjectClaim == "" {
+		args.SubjectClaim = defaultSubjectClaim
+	}
+	v := &JWTVerifier{
+		file:         args.JWKSFile,
+		issuer:       args.Issuer,
+		audience:     args.Audience,
+		subjectClaim: args.SubjectClaim,
+		parser: &jwt.Parser{ValidMethods: []string{
+			"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512",
+		}},
+		logger: zap.L().Named("jwt"),
+	}
+	if _, err := v.load(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) Verify(token string) (string, error) {
+	keys, err := v.load()
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{}
+	_, err = v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	now := time.Now().Unix()
+	switch {
+	case !claims.VerifyExpiresAt(now, true):
+		return "", fmt.Errorf("%w: expired or without expiry", ErrInvalidToken)
+	case !claims.VerifyIssuer(v.issuer, true):
+		return "", fmt.Errorf("%w: issuer isn't %s", ErrInvalidToken, v.issuer)
+	case !claims.VerifyAudience(v.audience, true):
+		return "", fmt.Errorf("%w: audience isn't %s", ErrInvalidToken, v.audience)
+	}
+	subject, _ := claims[v.subjectClaim].(string)
+	if subject == "" {
+		return "", fmt.Errorf("%w: no %s claim", ErrInvalidToken, v.subjectClaim)
+	}
+	return subject, nil
+}
+
+// load returns the keys of the JWKS file, reading it again if it changed.
+// A file that fails to read or parse keeps the keys read before.
+func (v *JWTVerifier) load() (map[string]crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	st, err := filestamp.Of(v.file)
+	if err != nil {
+		if v.keys != nil {
+			return v.keys, nil
+		}
+		return nil, err
+	}
+	if v.keys != nil && st == v.stamp {
+		return v.keys, nil
+	}
+	var keys map[string]crypto.PublicKey
+	b, err := os.ReadFile(v.file)
+	if err == nil {
+		keys, err = parseJWKS(b)
+	}
+	if err != nil {
+		if v.keys == nil {
+			return nil, fmt.Errorf("%s: %w", v.file, err)
+		}
+		v.logger.Warn("keeping the previous jwks", zap.String("file", v.file), zap.Error(err))
+		keys = v.keys
+	}
+	v.keys, v.stamp = keys, st
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the RSA and EC signing keys of the set by their id.
+func parseJWKS(b []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point isn't on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
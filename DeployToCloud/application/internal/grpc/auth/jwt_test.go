@@ -0,0 +1,170 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/grpc/auth"
+)
+
+const (
+	issuer   = "https://issuer.example"
+	audience = "proglog"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksFile, map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey})
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   issuer,
+			"aud":   audience,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"sub":   "alice",
+			"email": "alice@example.com",
+		}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	for scenario, tc := range map[string]struct {
+		token        string
+		subjectClaim string
+		subject      string
+	}{
+		"rsa signed token":           {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, valid()), subject: "alice"},
+		"ec signed token":            {token: mint(t, jwt.SigningMethodES256, "ec", ecKey, valid()), subject: "alice"},
+		"audience in a list":         {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("aud", []string{"other", audience})), subject: "alice"},
+		"subject from another claim": {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, valid()), subjectClaim: "email", subject: "alice@example.com"},
+		"expired token":              {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("exp", time.Now().Add(-time.Minute).Unix()))},
+		"token without expiry":       {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("exp", nil))},
+		"wrong issuer":               {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("iss", "https://evil.example"))},
+		"wrong audience":             {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("aud", "other"))},
+		"missing subject claim":      {token: mint(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("sub", nil))},
+		"unknown key id":             {token: mint(t, jwt.SigningMethodRS256, "gone", rsaKey, valid())},
+		"signed by another key":      {token: mint(t, jwt.SigningMethodRS256, "rsa", otherKey, valid())},
+		"hmac with the public key":   {token: mint(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), valid())},
+		"not a jwt":                  {token: "s3cret"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			verifier, err := NewJWTVerifier(JWTArgs{
+				JWKSFile:     jwksFile,
+				Issuer:       issuer,
+				Audience:     audience,
+				SubjectClaim: tc.subjectClaim,
+			})
+			require.NoError(t, err)
+			subject, err := verifier.Verify(tc.token)
+			if tc.subject == "" {
+				require.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.subject, subject)
+		})
+	}
+}
+
+func TestJWTVerifierReloadsJWKS(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksFile, map[string]crypto.PublicKey{"old": &oldKey.PublicKey})
+	verifier, err := NewJWTVerifier(JWTArgs{JWKSFile: jwksFile, Issuer: issuer, Audience: audience})
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{"iss": issuer, "aud": audience, "exp": time.Now().Add(time.Minute).Unix(), "sub": "alice"}
+	oldToken := mint(t, jwt.SigningMethodRS256, "old", oldKey, claims)
+	newToken := mint(t, jwt.SigningMethodRS256, "new", newKey, claims)
+	_, err = verifier.Verify(oldToken)
+	require.NoError(t, err)
+	_, err = verifier.Verify(newToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// rotate the key
+	writeJWKS(t, jwksFile, map[string]crypto.PublicKey{"new": &newKey.PublicKey})
+	_, err = verifier.Verify(newToken)
+	require.NoError(t, err)
+	_, err = verifier.Verify(oldToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// a broken file keeps the keys read before
+	rewrite(t, jwksFile, []byte("{"))
+	_, err = verifier.Verify(newToken)
+	require.NoError(t, err)
+}
+
+func mint(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+// writeJWKS writes the keys as a JWKS file.
+func writeJWKS(t *testing.T, file string, keys map[string]crypto.PublicKey) {
+	t.Helper()
+	enc := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "RSA", "kid": kid, "use": "sig", "n": enc(k.N), "e": enc(big.NewInt(int64(k.E))),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "EC", "kid": kid, "crv": "P-256", "x": enc(k.X), "y": enc(k.Y),
+			})
+		}
+	}
+	b, err := json.Marshal(set)
+	require.NoError(t, err)
+	rewrite(t, file, b)
+}
+
+// rewrite writes the file a second after its last modification, so that the
+// change is seen even within the file system's timestamp granularity.
+func rewrite(t *testing.T, file string, b []byte) {
+	t.Helper()
+	modTime := time.Now()
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	require.NoError(t, os.WriteFile(file, b, 0o600))
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+}
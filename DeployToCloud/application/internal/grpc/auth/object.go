@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/util"
+)
+
+// Object is the ACL object of a partition of the log, like orders/1.
+func Object(log string, partition uint32) string {
+	return fmt.Sprintf("%s/%d", log, partition)
+}
+
+// ObjectMatch reports whether a rule's object covers obj. Rules name a
+// partition like orders/1 or every partition of a log like orders, and may
+// end with a * to match a prefix.
+func ObjectMatch(obj, pattern string) bool {
+	if util.KeyMatch(obj, pattern) {
+		return true
+	}
+	log, _, ok := strings.Cut(obj, "/")
+	return ok && util.KeyMatch(log, pattern)
+}
+
+// objectMatchFunc is ObjectMatch for casbin matchers.
+func objectMatchFunc(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("objectMatch takes an object and a pattern")
+	}
+	obj, _ := args[0].(string)
+	pattern, _ := args[1].(string)
+	return ObjectMatch(obj, pattern), nil
+}
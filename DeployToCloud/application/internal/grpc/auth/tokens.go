@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// Tokens are static bearer tokens read from a file with a "subject token"
+// pair per line. Blank lines and lines starting with # are skipped.
+type Tokens struct {
+	subjects map[string]string
+}
+
+func NewTokens(file string) (*Tokens, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &Tokens{subjects: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a subject and a token", file, n)
+		}
+		t.subjects[fields[1]] = fields[0]
+	}
+	return t, scanner.Err()
+}
+
+func (t *Tokens) Verify(token string) (string, error) {
+	for known, subject := range t.subjects {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return subject, nil
+		}
+	}
+	return "", ErrInvalidToken
+}
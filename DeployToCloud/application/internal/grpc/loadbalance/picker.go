@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"context"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,11 +16,20 @@ func init() {
 }
 
 type Picker struct {
-	mu        sync.RWMutex
+	mu     sync.RWMutex
+	leader balancer.SubConn
+	// partitions are the replicas of each partition, calls are only routed
+	// to servers that replicate their partition
+	partitions map[uint32]*replicas
+	current    uint64
+	logger     *zap.Logger
+}
+
+type replicas struct {
 	leader    balancer.SubConn
 	followers []balancer.SubConn
-	current   uint64
-	logger    *zap.Logger
+	// nonvoters are preferred for consuming so reads stay off the quorum
+	nonvoters []balancer.SubConn
 }
 
 var (
@@ -31,23 +41,46 @@ func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 	p.logger = zap.L().Named("picker")
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	followers := make([]balancer.SubConn, 0, len(buildInfo.ReadySCs))
-	for sc, scInfo := range buildInfo.ReadySCs {
-		isLeader, ok := scInfo.Address.Attributes.Value("is_leader").(bool)
+	partitions := make(map[uint32]*replicas)
+	replicasOf := func(partition uint32) *replicas {
+		r, ok := partitions[partition]
 		if !ok {
-			p.logger.Warn("not found attributes is_leader",
-				zap.String("address", scInfo.Address.Addr),
-				zap.String("server_name", scInfo.Address.ServerName))
-			followers = append(followers, sc)
-			continue
+			r = &replicas{}
+			partitions[partition] = r
 		}
-		if isLeader {
+		return r
+	}
+	p.leader = nil
+	for sc, scInfo := range buildInfo.ReadySCs {
+		attrs := scInfo.Address.Attributes
+		if isLeader, _ := attrs.Value("is_leader").(bool); isLeader {
 			p.leader = sc
-			continue
 		}
-		followers = append(followers, sc)
+		leads := map[uint32]bool{}
+		leaderPartitions, _ := attrs.Value("leader_partitions").([]uint32)
+		for _, partition := range leaderPartitions {
+			leads[partition] = true
+			replicasOf(partition).leader = sc
+		}
+		voterPartitions, _ := attrs.Value("voter_partitions").([]uint32)
+		for _, partition := range voterPartitions {
+			if !leads[partition] {
+				r := replicasOf(partition)
+				r.followers = append(r.followers, sc)
+			}
+		}
+		nonvoterPartitions, _ := attrs.Value("nonvoter_partitions").([]uint32)
+		for _, partition := range nonvoterPartitions {
+			r := replicasOf(partition)
+			r.nonvoters = append(r.nonvoters, sc)
+		}
+		if len(voterPartitions)+len(nonvoterPartitions) == 0 {
+			p.logger.Warn("server replicates no partitions",
+				zap.String("address", scInfo.Address.Addr),
+				zap.String("server_name", scInfo.Address.ServerName))
+		}
 	}
-	p.followers = followers
+	p.partitions = partitions
 	return p
 }
 
@@ -55,10 +88,11 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	var result balancer.PickResult
-	if strings.Contains(info.FullMethodName, "Produce") || len(p.followers) == 0 {
-		result.SubConn = p.leader
+	partition := partitionFrom(info.Ctx)
+	if strings.Contains(info.FullMethodName, "Produce") {
+		result.SubConn = p.leaderOf(partition)
 	} else if strings.Contains(info.FullMethodName, "Consume") {
-		result.SubConn = p.nextFollower()
+		result.SubConn = p.nextReplica(partition)
 	}
 	if result.SubConn == nil {
 		return result, balancer.ErrNoSubConnAvailable
@@ -66,8 +100,46 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	return result, nil
 }
 
-func (p *Picker) nextFollower() balancer.SubConn {
+// leaderOf returns the leader of the partition, falling back to the leader
+// of partition 0.
+func (p *Picker) leaderOf(partition uint32) balancer.SubConn {
+	if r, ok := p.partitions[partition]; ok && r.leader != nil {
+		return r.leader
+	}
+	return p.leader
+}
+
+// nextReplica round-robins over the partition's nonvoters, falling back to
+// its followers and then to its leader.
+func (p *Picker) nextReplica(partition uint32) balancer.SubConn {
+	r, ok := p.partitions[partition]
+	if !ok {
+		return nil
+	}
+	replicas := r.nonvoters
+	if len(replicas) == 0 {
+		replicas = r.followers
+	}
+	if len(replicas) == 0 {
+		return r.leader
+	}
 	cur := atomic.AddUint64(&p.current, uint64(1))
-	idx := int(cur % uint64(len(p.followers)))
-	return p.followers[idx]
+	idx := int(cur % uint64(len(replicas)))
+	return replicas[idx]
+}
+
+type partitionKey struct{}
+
+// WithPartition routes the calls made with ctx to the partition's leader,
+// or to its replicas for consumes. The request must name the same partition.
+func WithPartition(ctx context.Context, partition uint32) context.Context {
+	return context.WithValue(ctx, partitionKey{}, partition)
+}
+
+func partitionFrom(ctx context.Context) uint32 {
+	if ctx == nil {
+		return 0
+	}
+	partition, _ := ctx.Value(partitionKey{}).(uint32)
+	return partition
 }
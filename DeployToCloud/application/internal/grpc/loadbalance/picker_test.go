@@ -1,6 +1,7 @@
 package loadbalance_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,6 +11,7 @@ import (
 	"google.golang.org/grpc/resolver"
 
 	"github.com/travisjeffery/proglog/internal/grpc/loadbalance"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
 )
 
 func TestPickerNoSubConnAvailable(t *testing.T) {
@@ -39,27 +41,99 @@ func TestPickerProducesToLeader(t *testing.T) {
 	}
 }
 
+func TestPickerProducesToPartitionLeader(t *testing.T) {
+	picker, subConns := setupTest()
+	for partition := uint32(0); partition < 3; partition++ {
+		info := balancer.PickInfo{
+			FullMethodName: "/log.vX.Log/Produce",
+			Ctx:            loadbalance.WithPartition(context.Background(), partition),
+		}
+		pick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[partition], pick.SubConn)
+	}
+}
+
 func TestPickerConsumesFromFollowers(t *testing.T) {
 	picker, subConns := setupTest()
 	info := balancer.PickInfo{
 		FullMethodName: "/log.vX.Log/Consume",
 	}
+	// followers are picked in turn, in whatever order they were built
+	var picks []balancer.SubConn
 	for i := 0; i < 5; i++ {
 		pick, err := picker.Pick(info)
 		require.NoError(t, err)
-		require.Equal(t, subConns[i%2+1], pick.SubConn)
+		picks = append(picks, pick.SubConn)
+	}
+	require.ElementsMatch(t, []balancer.SubConn{subConns[1], subConns[2]}, picks[:2])
+	for i := 2; i < len(picks); i++ {
+		require.Equal(t, picks[i%2], picks[i])
 	}
 }
 
-func setupTest() (*loadbalance.Picker, []*subConn) {
+func TestPickerConsumesFromNonvoters(t *testing.T) {
+	picker, subConns := setupTest(pb.Suffrage_SUFFRAGE_NONVOTER)
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+	}
+	for i := 0; i < 5; i++ {
+		pick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[3], pick.SubConn)
+	}
+}
+
+func TestPickerConsumesFromPartitionReplicas(t *testing.T) {
+	picker, subConns := setupTest(pb.Suffrage_SUFFRAGE_NONVOTER)
+	// partition 1 has no nonvoter, so it's consumed from its followers
+	ctx := loadbalance.WithPartition(context.Background(), 1)
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+		Ctx:            ctx,
+	}
+	for i := 0; i < 5; i++ {
+		pick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Contains(t, []balancer.SubConn{subConns[0], subConns[2]}, pick.SubConn)
+	}
+
+	// no ready server replicates partition 3
+	info.Ctx = loadbalance.WithPartition(context.Background(), 3)
+	_, err := picker.Pick(info)
+	require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
+
+// setupTest builds a picker over a leader, two voting followers and a server
+// per given suffrage. The ith voter leads partition i, and every voter
+// replicates partitions 0 to 2 while the others only replicate partition 0.
+func setupTest(suffrages ...pb.Suffrage) (*loadbalance.Picker, []*subConn) {
 	var subConns []*subConn
 	buildInfo := base.PickerBuildInfo{
 		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
 	}
-	for i := 0; i < 3; i++ {
+	suffrages = append([]pb.Suffrage{
+		pb.Suffrage_SUFFRAGE_VOTER,
+		pb.Suffrage_SUFFRAGE_VOTER,
+		pb.Suffrage_SUFFRAGE_VOTER,
+	}, suffrages...)
+	for i, suffrage := range suffrages {
 		sc := &subConn{}
+		var leaderPartitions, voterPartitions, nonvoterPartitions []uint32
+		if suffrage == pb.Suffrage_SUFFRAGE_VOTER {
+			leaderPartitions = []uint32{uint32(i)}
+			voterPartitions = []uint32{0, 1, 2}
+		} else {
+			nonvoterPartitions = []uint32{0}
+		}
 		addr := resolver.Address{
-			Attributes: attributes.New("is_leader", i == 0),
+			Attributes: attributes.New(
+				"is_leader", i == 0,
+				"suffrage", suffrage,
+				"leader_partitions", leaderPartitions,
+				"voter_partitions", voterPartitions,
+				"nonvoter_partitions", nonvoterPartitions,
+			),
 		}
 		// 0th sub conn is the leader
 		sc.UpdateAddresses([]resolver.Address{addr})
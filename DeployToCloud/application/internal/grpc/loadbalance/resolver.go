@@ -40,7 +40,7 @@ func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts re
 	if opts.DialCreds != nil {
 		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
 	}
-	r.serviceConfig = r.clientConn.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%q":{}}]}`, Name))
+	r.serviceConfig = r.clientConn.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, Name))
 	var err error
 	r.resolverConn, err = grpc.Dial(target.Endpoint, dialOpts...)
 	if err != nil {
@@ -67,8 +67,14 @@ func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
 	addrs := make([]resolver.Address, 0, len(res.Servers))
 	for _, server := range res.Servers {
 		addrs = append(addrs, resolver.Address{
-			Addr:       server.RpcAddr,
-			Attributes: attributes.New("is_leader", server.IsLeader),
+			Addr: server.RpcAddr,
+			Attributes: attributes.New(
+				"is_leader", server.IsLeader,
+				"suffrage", server.Suffrage,
+				"leader_partitions", server.LeaderPartitions,
+				"voter_partitions", server.VoterPartitions,
+				"nonvoter_partitions", server.NonvoterPartitions,
+			),
 		})
 	}
 	r.clientConn.UpdateState(resolver.State{
@@ -1,7 +1,9 @@
 package loadbalance_test
 
 import (
+	"context"
 	"net"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,12 +12,18 @@ import (
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
 
+	"github.com/travisjeffery/proglog/internal/grpc/auth"
 	"github.com/travisjeffery/proglog/internal/grpc/loadbalance"
 	"github.com/travisjeffery/proglog/internal/grpc/server"
 	pb "github.com/travisjeffery/proglog/internal/proto/v1"
 	"github.com/travisjeffery/proglog/internal/tls"
+	"github.com/travisjeffery/proglog/test"
 )
 
+func TestMain(m *testing.M) {
+	os.Exit(test.Run(m))
+}
+
 func TestResolver(t *testing.T) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -28,7 +36,9 @@ func TestResolver(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	srv, err := server.NewGRPCServer(nil, nil, &getServers{}, tlsConfig)
+	authenticator, err := auth.NewAuthenticator(auth.AuthenticatorArgs{Mode: auth.ModeMTLS})
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(nil, authenticator, nil, nil, nil, &getServers{}, nil, nil, nil, nil, tlsConfig, server.Args{})
 	require.NoError(t, err)
 
 	go srv.Serve(l)
@@ -49,8 +59,27 @@ func TestResolver(t *testing.T) {
 
 	wantState := resolver.State{
 		Addresses: []resolver.Address{
-			{Addr: "localhost:9001", Attributes: attributes.New("is_leader", true)},
-			{Addr: "localhost:9002", Attributes: attributes.New("is_leader", false)},
+			{Addr: "localhost:9001", Attributes: attributes.New(
+				"is_leader", true,
+				"suffrage", pb.Suffrage_SUFFRAGE_VOTER,
+				"leader_partitions", []uint32{0},
+				"voter_partitions", []uint32{0, 1},
+				"nonvoter_partitions", []uint32(nil),
+			)},
+			{Addr: "localhost:9002", Attributes: attributes.New(
+				"is_leader", false,
+				"suffrage", pb.Suffrage_SUFFRAGE_VOTER,
+				"leader_partitions", []uint32{1},
+				"voter_partitions", []uint32{0, 1},
+				"nonvoter_partitions", []uint32(nil),
+			)},
+			{Addr: "localhost:9003", Attributes: attributes.New(
+				"is_leader", false,
+				"suffrage", pb.Suffrage_SUFFRAGE_NONVOTER,
+				"leader_partitions", []uint32(nil),
+				"voter_partitions", []uint32(nil),
+				"nonvoter_partitions", []uint32{0},
+			)},
 		},
 	}
 	require.Equal(t, wantState, conn.state)
@@ -62,13 +91,18 @@ func TestResolver(t *testing.T) {
 
 type getServers struct{}
 
-func (s *getServers) GetServers() ([]*pb.Server, error) {
+func (s *getServers) GetServers(context.Context) ([]*pb.Server, error) {
 	return []*pb.Server{
-		{Id: "leader", RpcAddr: "localhost:9001", IsLeader: true},
-		{Id: "follower", RpcAddr: "localhost:9002"},
+		{Id: "leader", RpcAddr: "localhost:9001", IsLeader: true, LeaderPartitions: []uint32{0}, VoterPartitions: []uint32{0, 1}},
+		{Id: "follower", RpcAddr: "localhost:9002", LeaderPartitions: []uint32{1}, VoterPartitions: []uint32{0, 1}},
+		{Id: "nonvoter", RpcAddr: "localhost:9003", Suffrage: pb.Suffrage_SUFFRAGE_NONVOTER, NonvoterPartitions: []uint32{0}},
 	}, nil
 }
 
+func (s *getServers) Partitions() uint32 {
+	return 2
+}
+
 type clientConn struct {
 	resolver.ClientConn
 	state resolver.State
@@ -0,0 +1,52 @@
+package quota
+
+import (
+	"math"
+	"time"
+)
+
+// bucket is a token bucket refilled at rate tokens per second up to a
+// second's worth. A nil bucket is unlimited.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: rate, tokens: rate, last: now}
+}
+
+// wait refills the bucket and returns how long until it holds n tokens, or
+// is full when n is more than it holds, or is out of debt when n is zero.
+func (b *bucket) wait(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	missing := math.Min(n, b.rate) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / b.rate * float64(time.Second)))
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.rate, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take removes n tokens, leaving the bucket in debt when it holds fewer.
+func (b *bucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
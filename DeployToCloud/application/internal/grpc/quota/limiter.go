@@ -0,0 +1,167 @@
+package quota
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/filestamp"
+)
+
+const defaultReloadInterval = time.Second
+
+type ILimiter interface {
+	// Limit takes the requests and bytes from the subject's quota for the
+	// action. When they exceed it, nothing is taken and Limit returns how
+	// long until they don't.
+	Limit(subject, action string, requests, bytes int) time.Duration
+	// Charge takes bytes from the quota even when they exceed it, for
+	// responses whose size is known once they're served.
+	Charge(subject, action string, bytes int)
+}
+
+// Limiter enforces the quotas of a file with a token bucket per subject and
+// action, which holds a second of its rate. A request bigger than the
+// bucket passes when the bucket is full and leaves it in debt. The file is
+// checked for changes at most every ReloadInterval and a valid edit resets
+// the buckets, while an invalid one is logged and ignored.
+type Limiter struct {
+	args Args
+
+	mu        sync.Mutex
+	nextCheck int64
+	stamp     filestamp.Stamp
+	quotas    map[[2]string]Quota
+	buckets   map[[2]string]*buckets
+	logger    *zap.Logger
+}
+
+type Args struct {
+	File string
+	// ReloadInterval is how often the file is checked for changes, 1s when
+	// zero.
+	ReloadInterval time.Duration
+}
+
+var _ ILimiter = (*Limiter)(nil)
+
+type buckets struct {
+	requests *bucket
+	bytes    *bucket
+}
+
+func NewLimiter(args Args) (*Limiter, error) {
+	if args.ReloadInterval <= 0 {
+		args.ReloadInterval = defaultReloadInterval
+	}
+	if err := view.Register(DefaultLimiterViews...); err != nil {
+		return nil, err
+	}
+	l := &Limiter{args: args, logger: zap.L().Named("quota")}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Limiter) Limit(subject, action string, requests, bytes int) time.Duration {
+	l.maybeReload()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucketsFor(subject, action)
+	now := time.Now()
+	wait := b.requests.wait(now, float64(requests))
+	if w := b.bytes.wait(now, float64(bytes)); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		recordThrottled(subject, action)
+		return wait
+	}
+	b.requests.take(float64(requests))
+	b.bytes.take(float64(bytes))
+	recordUsage(subject, action, requests, bytes)
+	return 0
+}
+
+func (l *Limiter) Charge(subject, action string, bytes int) {
+	l.maybeReload()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucketsFor(subject, action)
+	b.bytes.refill(time.Now())
+	b.bytes.take(float64(bytes))
+	recordUsage(subject, action, 0, bytes)
+}
+
+// Reload reads the file and swaps its quotas in when it's valid.
+func (l *Limiter) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reload()
+}
+
+// bucketsFor returns the subject's buckets for the action, nil buckets are
+// unlimited. Callers hold mu.
+func (l *Limiter) bucketsFor(subject, action string) *buckets {
+	key := [2]string{subject, action}
+	if b, ok := l.buckets[key]; ok {
+		return b
+	}
+	q, ok := l.quotas[key]
+	if !ok {
+		q = l.quotas[[2]string{AnySubject, action}]
+	}
+	now := time.Now()
+	b := &buckets{requests: newBucket(q.Requests, now), bytes: newBucket(q.Bytes, now)}
+	l.buckets[key] = b
+	return b
+}
+
+func (l *Limiter) maybeReload() {
+	now := time.Now().UnixNano()
+	if now < atomic.LoadInt64(&l.nextCheck) || !l.mu.TryLock() {
+		return
+	}
+	defer l.mu.Unlock()
+	atomic.StoreInt64(&l.nextCheck, now+int64(l.args.ReloadInterval))
+	s, err := filestamp.Of(l.args.File)
+	if err != nil {
+		l.logger.Error("failed to check quota file", zap.Error(err))
+		return
+	}
+	if s == l.stamp {
+		return
+	}
+	if err := l.reload(); err != nil {
+		l.logger.Error("failed to reload quotas, keeping the previous ones",
+			zap.String("file", l.args.File),
+			zap.Error(err))
+		return
+	}
+	l.logger.Info("reloaded quotas", zap.String("file", l.args.File))
+}
+
+// reload remembers the file it read even when it's invalid, so that a bad
+// edit is only logged once.
+func (l *Limiter) reload() error {
+	s, err := filestamp.Of(l.args.File)
+	if err != nil {
+		return err
+	}
+	l.stamp = s
+	list, err := ReadFile(l.args.File)
+	if err != nil {
+		return err
+	}
+	quotas := make(map[[2]string]Quota, len(list))
+	for _, q := range list {
+		quotas[[2]string{q.Subject, q.Action}] = q
+	}
+	l.quotas = quotas
+	l.buckets = map[[2]string]*buckets{}
+	return nil
+}
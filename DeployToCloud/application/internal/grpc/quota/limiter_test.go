@@ -0,0 +1,90 @@
+package quota_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/grpc/quota"
+)
+
+func TestReadFile(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		quotas string
+		want   []Quota
+	}{
+		"quotas": {
+			quotas: "# subject action requests/s bytes/s\n\n* produce 100 1048576\nalice consume - 0.5\n",
+			want: []Quota{
+				{Subject: "*", Action: ProduceAction, Requests: 100, Bytes: 1048576},
+				{Subject: "alice", Action: ConsumeAction, Bytes: 0.5},
+			},
+		},
+		"missing rate":   {quotas: "alice produce 100\n"},
+		"unknown action": {quotas: "alice admin 1 1\n"},
+		"negative rate":  {quotas: "alice produce -1 1\n"},
+		"invalid rate":   {quotas: "alice produce NaN 1\n"},
+		"second quota":   {quotas: "alice produce 1 1\nalice produce 2 2\n"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "quotas")
+			require.NoError(t, os.WriteFile(file, []byte(tc.quotas), 0o600))
+			quotas, err := ReadFile(file)
+			if tc.want == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, quotas)
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quotas")
+	require.NoError(t, os.WriteFile(file, []byte("* produce 2 100\nalice produce - -\nbob consume - 10\n"), 0o600))
+	limiter, err := NewLimiter(Args{File: file})
+	require.NoError(t, err)
+
+	// the default subject's quota, a bucket each
+	require.Zero(t, limiter.Limit("carol", ProduceAction, 1, 10))
+	require.Zero(t, limiter.Limit("carol", ProduceAction, 1, 10))
+	wait := limiter.Limit("carol", ProduceAction, 1, 10)
+	require.Positive(t, wait)
+	require.LessOrEqual(t, wait, 500*time.Millisecond)
+	require.Zero(t, limiter.Limit("dave", ProduceAction, 1, 10))
+
+	// a subject's own quota and actions without one are unlimited
+	for i := 0; i < 10; i++ {
+		require.Zero(t, limiter.Limit("alice", ProduceAction, 1, 1000))
+		require.Zero(t, limiter.Limit("carol", ConsumeAction, 1, 1000))
+	}
+
+	// a charge bigger than the bucket leaves it in debt
+	require.Zero(t, limiter.Limit("bob", ConsumeAction, 1, 0))
+	limiter.Charge("bob", ConsumeAction, 15)
+	require.Positive(t, limiter.Limit("bob", ConsumeAction, 1, 0))
+}
+
+func TestLimiterReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quotas")
+	require.NoError(t, os.WriteFile(file, []byte("alice produce 1 -\n"), 0o600))
+	limiter, err := NewLimiter(Args{File: file, ReloadInterval: time.Millisecond})
+	require.NoError(t, err)
+	require.Zero(t, limiter.Limit("alice", ProduceAction, 1, 0))
+	require.Positive(t, limiter.Limit("alice", ProduceAction, 1, 0))
+
+	// a valid edit resets the buckets
+	require.NoError(t, os.WriteFile(file, []byte("alice produce 100 -\n"), 0o600))
+	require.Eventually(t, func() bool {
+		return limiter.Limit("alice", ProduceAction, 1, 0) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	// and an invalid one keeps them
+	require.NoError(t, os.WriteFile(file, []byte("alice produce\n"), 0o600))
+	require.Error(t, limiter.Reload())
+	require.Zero(t, limiter.Limit("alice", ProduceAction, 1, 0))
+}
@@ -0,0 +1,72 @@
+package quota
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	KeySubject = tag.MustNewKey("proglog_subject")
+	KeyAction  = tag.MustNewKey("proglog_action")
+
+	QuotaRequests  = stats.Int64("proglog/quota/requests", "Requests taken from quotas", stats.UnitDimensionless)
+	QuotaBytes     = stats.Int64("proglog/quota/bytes", "Bytes taken from quotas", stats.UnitBytes)
+	QuotaThrottled = stats.Int64("proglog/quota/throttled", "Requests over their quota", stats.UnitDimensionless)
+
+	QuotaRequestsView = &view.View{
+		Name:        "proglog/quota/requests",
+		Description: "Sum of requests taken from quotas, by subject and action",
+		Measure:     QuotaRequests,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{KeySubject, KeyAction},
+	}
+
+	QuotaBytesView = &view.View{
+		Name:        "proglog/quota/bytes",
+		Description: "Sum of bytes taken from quotas, by subject and action",
+		Measure:     QuotaBytes,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{KeySubject, KeyAction},
+	}
+
+	QuotaThrottledView = &view.View{
+		Name:        "proglog/quota/throttled",
+		Description: "Count of requests over their quota, by subject and action",
+		Measure:     QuotaThrottled,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeySubject, KeyAction},
+	}
+
+	DefaultLimiterViews = []*view.View{
+		QuotaRequestsView,
+		QuotaBytesView,
+		QuotaThrottledView,
+	}
+)
+
+func recordUsage(subject, action string, requests, bytes int) {
+	ms := make([]stats.Measurement, 0, 2)
+	if requests > 0 {
+		ms = append(ms, QuotaRequests.M(int64(requests)))
+	}
+	if bytes > 0 {
+		ms = append(ms, QuotaBytes.M(int64(bytes)))
+	}
+	if len(ms) == 0 {
+		return
+	}
+	//nolint:errcheck //reason: only fails for invalid tags
+	_ = stats.RecordWithTags(context.Background(), mutators(subject, action), ms...)
+}
+
+func recordThrottled(subject, action string) {
+	//nolint:errcheck //reason: only fails for invalid tags
+	_ = stats.RecordWithTags(context.Background(), mutators(subject, action), QuotaThrottled.M(1))
+}
+
+func mutators(subject, action string) []tag.Mutator {
+	return []tag.Mutator{tag.Upsert(KeySubject, subject), tag.Upsert(KeyAction, action)}
+}
@@ -0,0 +1,84 @@
+// Package quota limits the requests and bytes each subject may produce and
+// consume per second.
+package quota
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnySubject's quotas apply to the subjects without their own.
+	AnySubject = "*"
+
+	ProduceAction = "produce"
+	ConsumeAction = "consume"
+)
+
+// Quota limits what a subject may do per second, zero is unlimited.
+type Quota struct {
+	Subject  string
+	Action   string
+	Requests float64
+	Bytes    float64
+}
+
+// ReadFile reads the quotas of a file with a line per subject and action:
+//
+//	subject action requests/s bytes/s
+//
+// where action is produce or consume, the subject * applies to the subjects
+// without a line of their own, and a rate of 0 or - is unlimited. Blank lines
+// and lines starting with # are skipped.
+func ReadFile(file string) ([]Quota, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var quotas []Quota
+	seen := map[[2]string]bool{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: want a subject, an action, requests/s and bytes/s", file, n)
+		}
+		q := Quota{Subject: fields[0], Action: fields[1]}
+		if q.Action != ProduceAction && q.Action != ConsumeAction {
+			return nil, fmt.Errorf("%s:%d: unknown action %q", file, n, q.Action)
+		}
+		key := [2]string{q.Subject, q.Action}
+		if seen[key] {
+			return nil, fmt.Errorf("%s:%d: second %s quota of %s", file, n, q.Action, q.Subject)
+		}
+		seen[key] = true
+		if q.Requests, err = parseRate(fields[2]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		if q.Bytes, err = parseRate(fields[3]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, scanner.Err()
+}
+
+func parseRate(s string) (float64, error) {
+	if s == "-" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return rate, nil
+}
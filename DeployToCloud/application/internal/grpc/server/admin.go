@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+
+	"github.com/travisjeffery/proglog/internal/grpc/auth"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	"github.com/travisjeffery/proglog/internal/raftapp"
+	"github.com/travisjeffery/proglog/internal/rebalance"
+)
+
+type adminService struct {
+	Admin      raftapp.IAdmin
+	Rebalancer rebalance.IRebalancer
+	Authorizer auth.IAuthorizer
+	pb.UnimplementedAdminServer
+}
+
+func newAdminService(admin raftapp.IAdmin, rebalancer rebalance.IRebalancer, authorizer auth.IAuthorizer) *adminService {
+	return &adminService{
+		Admin:      admin,
+		Rebalancer: rebalancer,
+		Authorizer: authorizer,
+	}
+}
+
+func (s *adminService) authorize(ctx context.Context) error {
+	return s.Authorizer.Authorize(ctx, subject(ctx), objectWildcard, adminAction)
+}
+
+func (s *adminService) RemoveServer(ctx context.Context, req *pb.RemoveServerRequest) (*pb.RemoveServerResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.RemoveServer(req.Partition, req.Id); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.RemoveServerResponse{}, nil
+}
+
+func (s *adminService) AddVoter(ctx context.Context, req *pb.AddServerRequest) (*pb.AddServerResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.AddVoter(req.Partition, req.Id, req.RpcAddr); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.AddServerResponse{}, nil
+}
+
+func (s *adminService) AddNonvoter(ctx context.Context, req *pb.AddServerRequest) (*pb.AddServerResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.AddNonvoter(req.Partition, req.Id, req.RpcAddr); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.AddServerResponse{}, nil
+}
+
+func (s *adminService) TransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.TransferLeadershipResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.TransferLeadership(req.Partition, req.Id, req.RpcAddr); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.TransferLeadershipResponse{}, nil
+}
+
+func (s *adminService) Snapshot(ctx context.Context, req *pb.SnapshotRequest) (*pb.SnapshotResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	snapshot, err := s.Admin.Snapshot(req.Partition)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.SnapshotResponse{Snapshot: snapshot}, nil
+}
+
+func (s *adminService) ListSnapshots(ctx context.Context, req *pb.ListSnapshotsRequest) (*pb.ListSnapshotsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	snapshots, err := s.Admin.ListSnapshots(req.Partition)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.ListSnapshotsResponse{Snapshots: snapshots}, nil
+}
+
+func (s *adminService) RaftStats(ctx context.Context, req *pb.RaftStatsRequest) (*pb.RaftStatsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	stats, err := s.Admin.RaftStats(req.Partition)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.RaftStatsResponse{Stats: stats}, nil
+}
+
+func (s *adminService) MovePartition(ctx context.Context, req *pb.MovePartitionRequest) (*pb.MovePartitionResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.MovePartition(ctx, req.Partition, req.FromId, req.ToId, req.ToRpcAddr); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.MovePartitionResponse{}, nil
+}
+
+func (s *adminService) DescribePartitions(ctx context.Context, _ *pb.DescribePartitionsRequest) (*pb.DescribePartitionsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	partitions, err := s.Admin.DescribePartitions()
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.DescribePartitionsResponse{Partitions: partitions}, nil
+}
+
+func (s *adminService) Rebalance(ctx context.Context, req *pb.RebalanceRequest) (*pb.RebalanceResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	moves, err := s.Rebalancer.Rebalance(ctx, req.DryRun)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.RebalanceResponse{Moves: moves}, nil
+}
+
+func (s *adminService) Grant(ctx context.Context, req *pb.GrantRequest) (*pb.GrantResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.Grant(req.Policy); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.GrantResponse{}, nil
+}
+
+func (s *adminService) Revoke(ctx context.Context, req *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Admin.Revoke(req.Policy); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.RevokeResponse{}, nil
+}
+
+func (s *adminService) ListPolicies(ctx context.Context, _ *pb.ListPoliciesRequest) (*pb.ListPoliciesResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	policies, replicated, err := s.Admin.ListPolicies()
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.ListPoliciesResponse{Policies: policies, Replicated: replicated}, nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/travisjeffery/proglog/internal/audit"
+)
+
+const adminServicePrefix = "/log.v1.Admin/"
+
+// auditAdmin records every admin RPC with its request and status code, the
+// authorizer records whether the caller was allowed to make it.
+func auditAdmin(auditor audit.IAuditor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, adminServicePrefix) {
+			return handler(ctx, req)
+		}
+		res, err := handler(ctx, req)
+		e := audit.Event{
+			Kind:    audit.KindAdmin,
+			Subject: subject(ctx),
+			Action:  path.Base(info.FullMethod),
+			Code:    status.Code(err).String(),
+		}
+		if msg, ok := req.(proto.Message); ok {
+			if b, merr := protojson.Marshal(msg); merr == nil {
+				e.Request = b
+			}
+		}
+		auditor.Record(ctx, e)
+		return res, err
+	}
+}
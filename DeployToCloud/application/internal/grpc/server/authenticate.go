@@ -3,32 +3,23 @@ package server
 import (
 	"context"
 
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/status"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+
+	"github.com/travisjeffery/proglog/internal/grpc/auth"
 )
 
 type subjectContextKey struct{}
 
-func authenticate(ctx context.Context) (context.Context, error) {
-	p, ok := peer.FromContext(ctx)
-	if !ok {
-		return ctx, status.New(codes.Unknown, "couldn't find peer info").Err()
-	}
-
-	if p.AuthInfo == nil {
-		return ctx, status.New(codes.Unauthenticated, "no transport security being used").Err()
+// authenticate puts the subject the authenticator finds for the caller into
+// the context.
+func authenticate(authenticator auth.IAuthenticator) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		subject, err := authenticator.Authenticate(ctx)
+		if err != nil {
+			return ctx, err
+		}
+		return context.WithValue(ctx, subjectContextKey{}, subject), nil
 	}
-
-	t, ok := p.AuthInfo.(credentials.TLSInfo)
-	if !ok {
-		return ctx, status.New(codes.Unauthenticated, "failed to cast AuthInfo").Err()
-	}
-	subject := t.State.VerifiedChains[0][0].Subject.CommonName
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
-
-	return ctx, nil
 }
 
 func subject(ctx context.Context) string {
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// errDraining is retriable: clients should retry against another node.
+var errDraining = status.Error(codes.Unavailable, "server is shutting down")
+
+// Drain switches the server into shutting down: the health service reports
+// NOT_SERVING, produces are refused and open streams end with errDraining.
+type Drain struct {
+	health *health.Server
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewDrain() *Drain {
+	hsrv := health.NewServer()
+	hsrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	return &Drain{health: hsrv, done: make(chan struct{})}
+}
+
+func (d *Drain) Start() {
+	d.once.Do(func() {
+		d.health.Shutdown()
+		close(d.done)
+	})
+}
+
+func (d *Drain) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *Drain) draining() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// context returns a child of ctx that is also canceled once draining starts.
+func (d *Drain) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -1,10 +1,18 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/hashicorp/raft"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"github.com/travisjeffery/proglog/internal/grpc/auth"
+	"github.com/travisjeffery/proglog/internal/log"
+	innerraft "github.com/travisjeffery/proglog/internal/raft"
+	"github.com/travisjeffery/proglog/internal/raftapp"
 )
 
 type OffsetOutOfRangeError struct {
@@ -31,3 +39,26 @@ func (e OffsetOutOfRangeError) GRPCStatus() *status.Status {
 func (e OffsetOutOfRangeError) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+func toStatusError(err error) error {
+	var e log.OffsetOutOfRangeError
+	if errors.As(err, &e) {
+		return OffsetOutOfRangeError{e.Offset}
+	}
+	switch {
+	case errors.Is(err, raft.ErrNotLeader),
+		errors.Is(err, innerraft.ErrNotReplica),
+		errors.Is(err, raft.ErrLeadershipTransferInProgress),
+		errors.Is(err, raft.ErrNothingNewToSnapshot):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, innerraft.ErrUnknownPartition),
+		errors.Is(err, raftapp.ErrInvalidMove),
+		errors.Is(err, auth.ErrInvalidPolicy):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, innerraft.ErrUnknownPolicy):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, innerraft.ErrLastPolicy):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
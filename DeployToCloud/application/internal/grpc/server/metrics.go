@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+)
+
+var (
+	KeyAcks = tag.MustNewKey("proglog_acks")
+
+	ProduceLatency = stats.Float64("proglog/server/produce_latency", "Time until a produced record was acked", stats.UnitMilliseconds)
+
+	ProduceLatencyView = &view.View{
+		Name:        "proglog/server/produce_latency",
+		Description: "Distribution of produce ack latency, by acks level",
+		Measure:     ProduceLatency,
+		Aggregation: ocgrpc.DefaultMillisecondsDistribution,
+		TagKeys:     []tag.Key{KeyAcks},
+	}
+
+	ProduceCountView = &view.View{
+		Name:        "proglog/server/produce_count",
+		Description: "Count of acked produced records, by acks level",
+		Measure:     ProduceLatency,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyAcks},
+	}
+
+	DefaultServerViews = []*view.View{
+		ProduceLatencyView,
+		ProduceCountView,
+	}
+)
+
+func recordProduce(ctx context.Context, acks pb.Acks, start time.Time) {
+	ms := float64(time.Since(start)) / float64(time.Millisecond)
+	//nolint:errcheck //reason: only fails for invalid tags
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(KeyAcks, acks.String())}, ProduceLatency.M(ms))
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/travisjeffery/proglog/internal/grpc/quota"
+)
+
+// quotaActions are the quota actions of the limited methods.
+var quotaActions = map[string]string{
+	"/log.v1.Log/Produce":       quota.ProduceAction,
+	"/log.v1.Log/ProduceStream": quota.ProduceAction,
+	"/log.v1.Log/Consume":       quota.ConsumeAction,
+	"/log.v1.Log/ConsumeRange":  quota.ConsumeAction,
+	"/log.v1.Log/ConsumeStream": quota.ConsumeAction,
+}
+
+// limitUnary fails produce and consume calls over the caller's quota with
+// RESOURCE_EXHAUSTED and when to retry. Consumed bytes are charged once the
+// response is known, so they limit the calls that follow.
+func limitUnary(limiter quota.ILimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		action, ok := quotaActions[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		subject := subject(ctx)
+		bytes := 0
+		if action == quota.ProduceAction {
+			bytes = messageSize(req)
+		}
+		if wait := limiter.Limit(subject, action, 1, bytes); wait > 0 {
+			return nil, quotaExceeded(subject, action, wait)
+		}
+		res, err := handler(ctx, req)
+		if err == nil && action == quota.ConsumeAction {
+			limiter.Charge(subject, action, messageSize(res))
+		}
+		return res, err
+	}
+}
+
+// limitStream fails produce and consume streams over the caller's quota
+// with RESOURCE_EXHAUSTED and when to retry, as limitUnary does calls: a
+// produced record over it ends the stream, and so does a consumed record
+// once the ones sent before it took the quota into debt.
+func limitStream(limiter quota.ILimiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		action, ok := quotaActions[info.FullMethod]
+		if !ok {
+			return handler(srv, ss)
+		}
+		return handler(srv, &limitedStream{
+			ServerStream: ss,
+			limiter:      limiter,
+			subject:      subject(ss.Context()),
+			action:       action,
+		})
+	}
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	limiter quota.ILimiter
+	subject string
+	action  string
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	bytes := 0
+	if s.action == quota.ProduceAction {
+		bytes = messageSize(m)
+	}
+	return s.limit(1, bytes)
+}
+
+func (s *limitedStream) SendMsg(m interface{}) error {
+	if s.action != quota.ConsumeAction {
+		return s.ServerStream.SendMsg(m)
+	}
+	if err := s.limit(0, 0); err != nil {
+		return err
+	}
+	if err := s.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	s.limiter.Charge(s.subject, s.action, messageSize(m))
+	return nil
+}
+
+func (s *limitedStream) limit(requests, bytes int) error {
+	if wait := s.limiter.Limit(s.subject, s.action, requests, bytes); wait > 0 {
+		return quotaExceeded(s.subject, s.action, wait)
+	}
+	return nil
+}
+
+func quotaExceeded(subject, action string, wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("%s is over its %s quota", subject, action))
+	std, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     subject,
+			Description: fmt.Sprintf("%s requests and bytes per second", action),
+		}}},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return std.Err()
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+
+	"github.com/travisjeffery/proglog/internal/grpc/auth"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+)
+
+// raftService authorizes the raft RPCs of peers before handing them to the
+// raft transport.
+type raftService struct {
+	Raft       pb.RaftServer
+	Authorizer auth.IAuthorizer
+	pb.UnimplementedRaftServer
+}
+
+func newRaftService(raft pb.RaftServer, authorizer auth.IAuthorizer) *raftService {
+	return &raftService{
+		Raft:       raft,
+		Authorizer: authorizer,
+	}
+}
+
+func (s *raftService) authorize(ctx context.Context) error {
+	return s.Authorizer.Authorize(ctx, subject(ctx), objectWildcard, raftAction)
+}
+
+func (s *raftService) AppendEntries(ctx context.Context, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	res, err := s.Raft.AppendEntries(ctx, req)
+	return res, toStatusError(err)
+}
+
+func (s *raftService) RequestVote(ctx context.Context, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	res, err := s.Raft.RequestVote(ctx, req)
+	return res, toStatusError(err)
+}
+
+func (s *raftService) TimeoutNow(ctx context.Context, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	res, err := s.Raft.TimeoutNow(ctx, req)
+	return res, toStatusError(err)
+}
+
+func (s *raftService) InstallSnapshot(stream pb.Raft_InstallSnapshotServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return toStatusError(s.Raft.InstallSnapshot(stream))
+}
@@ -15,21 +15,38 @@ import (
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
+	"github.com/travisjeffery/proglog/internal/audit"
 	"github.com/travisjeffery/proglog/internal/grpc/auth"
+	"github.com/travisjeffery/proglog/internal/grpc/quota"
 	pb "github.com/travisjeffery/proglog/internal/proto/v1"
 	"github.com/travisjeffery/proglog/internal/raftapp"
+	"github.com/travisjeffery/proglog/internal/rebalance"
 )
 
 const (
 	objectWildcard = "*"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+	adminAction    = "admin"
+	raftAction     = audit.RaftAction
+
+	defaultLogName         = "proglog"
+	defaultProduceWindow   = 64
+	defaultConsumeMaxBytes = 1 << 20
 )
 
-func NewGRPCServer(resource raftapp.IResource, authorizer auth.IAuthorizer, servers raftapp.IServers, tlsConfig *tls.Config) (*grpc.Server, error) {
+type Args struct {
+	// ProduceWindow is the number of records a single ProduceStream may have
+	// in flight before the server stops reading from the stream.
+	ProduceWindow int
+	// LogName names the log in the objects produce and consume are
+	// authorized against, like proglog/0 for partition 0, proglog when empty.
+	LogName string
+}
+
+func NewGRPCServer(resources raftapp.IResources, authenticator auth.IAuthenticator, authorizer auth.IAuthorizer, limiter quota.ILimiter, auditor audit.IAuditor, servers raftapp.IServers, admin raftapp.IAdmin, rebalancer rebalance.IRebalancer, raft pb.RaftServer, drain *Drain, tlsConfig *tls.Config, args Args) (*grpc.Server, error) {
 	logger := zap.L().Named("server")
 	zapOpts := []grpc_zap.Option{
 		grpc_zap.WithDurationField(
@@ -40,7 +57,7 @@ func NewGRPCServer(resource raftapp.IResource, authorizer auth.IAuthorizer, serv
 	}
 
 	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
-	err := view.Register(ocgrpc.DefaultServerViews...)
+	err := view.Register(append(ocgrpc.DefaultServerViews, DefaultServerViews...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -50,26 +67,48 @@ func NewGRPCServer(resource raftapp.IResource, authorizer auth.IAuthorizer, serv
 		creds := credentials.NewTLS(tlsConfig)
 		grpcOpts = append(grpcOpts, grpc.Creds(creds))
 	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(),
+		grpc_zap.StreamServerInterceptor(logger, zapOpts...),
+		grpc_auth.StreamServerInterceptor(authenticate(authenticator)),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
+		grpc_auth.UnaryServerInterceptor(authenticate(authenticator)),
+	}
+	if limiter != nil {
+		// quotas are per subject, so they're enforced once it's authenticated
+		streamInterceptors = append(streamInterceptors, limitStream(limiter))
+		unaryInterceptors = append(unaryInterceptors, limitUnary(limiter))
+	}
+	if auditor != nil {
+		unaryInterceptors = append(unaryInterceptors, auditAdmin(auditor))
+	}
 	grpcOpts = append(grpcOpts,
-		grpc.StreamInterceptor(
-			grpc_middleware.ChainStreamServer(
-				grpc_ctxtags.StreamServerInterceptor(),
-				grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-				grpc_auth.StreamServerInterceptor(authenticate),
-			)), grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			grpc_auth.UnaryServerInterceptor(authenticate),
-		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
 		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 	)
 	gsrv := grpc.NewServer(grpcOpts...)
 
-	hsrv := health.NewServer()
-	hsrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
-	healthpb.RegisterHealthServer(gsrv, hsrv)
+	if drain == nil {
+		drain = NewDrain()
+	}
+	healthpb.RegisterHealthServer(gsrv, drain.health)
 
-	srv := newService(resource, authorizer, servers)
+	if args.ProduceWindow <= 0 {
+		args.ProduceWindow = defaultProduceWindow
+	}
+	if args.LogName == "" {
+		args.LogName = defaultLogName
+	}
+	srv := newService(resources, authorizer, servers, drain, args)
 	pb.RegisterLogServer(gsrv, srv)
+	pb.RegisterAdminServer(gsrv, newAdminService(admin, rebalancer, authorizer))
+	if raft != nil {
+		// raft runs over the grpc transport
+		pb.RegisterRaftServer(gsrv, newRaftService(raft, authorizer))
+	}
 	return gsrv, nil
 }
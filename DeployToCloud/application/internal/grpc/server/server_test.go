@@ -5,25 +5,37 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	hraft "github.com/hashicorp/raft"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
 	"go.opencensus.io/examples/exporter"
+	"go.opencensus.io/stats/view"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 
+	"github.com/travisjeffery/proglog/internal/audit"
 	"github.com/travisjeffery/proglog/internal/grpc/auth"
+	"github.com/travisjeffery/proglog/internal/grpc/quota"
 	"github.com/travisjeffery/proglog/internal/log"
 	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	innerraft "github.com/travisjeffery/proglog/internal/raft"
+	"github.com/travisjeffery/proglog/internal/raftapp"
 	innertls "github.com/travisjeffery/proglog/internal/tls"
+	"github.com/travisjeffery/proglog/test"
 )
 
 var debug = flag.Bool("debug", false, "Enable observability for debugging.")
@@ -37,7 +49,7 @@ func TestMain(m *testing.M) {
 		}
 		zap.ReplaceGlobals(logger)
 	}
-	os.Exit(m.Run())
+	os.Exit(test.Run(m))
 }
 
 func TestServer(t *testing.T) {
@@ -45,11 +57,19 @@ func TestServer(t *testing.T) {
 		"produce/consume a message to/from the log succeeeds": testProduceConsume,
 		"produce/consume stream succeeds":                     testProduceConsumeStream,
 		"consume past log boundary fails":                     testConsumePastBoundary,
+		"idle consume stream does not spin":                   testConsumeStreamIdle,
+		"pipelined produce stream acks in order":              testProduceStreamPipelined,
+		"partly appended batch acks the appended records":     testProduceStreamPartialBatch,
+		"consume range and batched stream succeed":            testConsumeRange,
+		"produce records metrics per acks level":              testProduceAcks,
 		"unauthorized fails":                                  testUnauthorized,
+		"admin rpcs require the admin action":                 testAdmin,
 		"healthcheck succeeds":                                testHealthCheck,
+		"draining ends streams and rejects produces":          testDrain,
+		"unknown partition is an invalid argument":            testUnknownPartition,
 	} {
 		t.Run(scenario, func(t *testing.T) {
-			cs, teardown := setupTest(t)
+			cs, teardown := setupTest(t, nil)
 			defer teardown()
 			fn(t, cs)
 		})
@@ -57,12 +77,175 @@ func TestServer(t *testing.T) {
 }
 
 type clients struct {
-	Root   pb.LogClient
-	Nobody pb.LogClient
-	Health healthpb.HealthClient
+	Root        pb.LogClient
+	Nobody      pb.LogClient
+	RootAdmin   pb.AdminClient
+	NobodyAdmin pb.AdminClient
+	Health      healthpb.HealthClient
+	Log         *countingLog
+	Admin       *fakeAdmin
+	Drain       *Drain
+	Auditor     *fakeAuditor
 }
 
-func setupTest(t *testing.T) (clients clients, teardown func()) {
+// fakeAuditor keeps the events it records.
+type fakeAuditor struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (a *fakeAuditor) Record(_ context.Context, e audit.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, e)
+}
+
+func (a *fakeAuditor) Close() error {
+	return nil
+}
+
+// find returns the recorded events of the kind and action.
+func (a *fakeAuditor) find(kind, action string) []audit.Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var events []audit.Event
+	for _, e := range a.events {
+		if e.Kind == kind && e.Action == action {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// fakeAdmin records the servers added and removed and the rules granted
+// through the admin service.
+type fakeAdmin struct {
+	mu       sync.Mutex
+	servers  map[string]string
+	policies []*pb.Policy
+}
+
+func (a *fakeAdmin) RemoveServer(_ uint32, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.servers, id)
+	return nil
+}
+
+func (a *fakeAdmin) AddVoter(_ uint32, id, addr string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.servers[id] = addr
+	return nil
+}
+
+func (a *fakeAdmin) AddNonvoter(partition uint32, id, addr string) error {
+	return a.AddVoter(partition, id, addr)
+}
+
+func (a *fakeAdmin) TransferLeadership(_ uint32, _, _ string) error {
+	return hraft.ErrNotLeader
+}
+
+func (a *fakeAdmin) Snapshot(_ uint32) (*pb.SnapshotMeta, error) {
+	return &pb.SnapshotMeta{Id: "1-2-3", Index: 2, Term: 1}, nil
+}
+
+func (a *fakeAdmin) ListSnapshots(_ uint32) ([]*pb.SnapshotMeta, error) {
+	return []*pb.SnapshotMeta{{Id: "1-2-3", Index: 2, Term: 1}}, nil
+}
+
+func (a *fakeAdmin) RaftStats(_ uint32) (map[string]string, error) {
+	return map[string]string{"state": "Leader"}, nil
+}
+
+func (a *fakeAdmin) MovePartition(_ context.Context, _ uint32, _, _, _ string) error {
+	return raftapp.ErrInvalidMove
+}
+
+func (a *fakeAdmin) DescribePartitions() ([]*pb.PartitionReplicas, error) {
+	return nil, nil
+}
+
+func (a *fakeAdmin) Grant(policy *pb.Policy) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policies = append(a.policies, policy)
+	return nil
+}
+
+func (a *fakeAdmin) Revoke(_ *pb.Policy) error {
+	return innerraft.ErrUnknownPolicy
+}
+
+func (a *fakeAdmin) ListPolicies() ([]*pb.Policy, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.policies, true, nil
+}
+
+// countingLog adapts *log.Log to partition 0's raftapp.IResource and counts
+// reads so tests can observe how often the log is polled.
+type countingLog struct {
+	*log.Log
+	reads uint64
+	// batch appends fail once failAfter records were appended, when set
+	failAfter uint64
+	appended  uint64
+}
+
+var errAppendFailed = errors.New("append failed")
+
+func (l *countingLog) Resource(partition uint32) (raftapp.IResource, error) {
+	if partition != 0 {
+		return nil, fmt.Errorf("%w: %d", innerraft.ErrUnknownPartition, partition)
+	}
+	return l, nil
+}
+
+func (l *countingLog) AppendAsync(record *pb.Record, _ pb.Acks) raftapp.AppendFuture {
+	off, err := l.Append(record)
+	return appendResult{offset: off, err: err}
+}
+
+func (l *countingLog) AppendBatchAsync(records []*pb.Record, _ pb.Acks) raftapp.AppendFuture {
+	var failed error
+	if l.failAfter > 0 && l.appended+uint64(len(records)) > l.failAfter {
+		records, failed = records[:l.failAfter-l.appended], errAppendFailed
+	}
+	offsets, err := l.AppendBatch(records)
+	l.appended += uint64(len(offsets))
+	if err == nil {
+		err = failed
+	}
+	switch {
+	case err == nil:
+		return appendResult{offset: offsets[0]}
+	case len(offsets) > 0:
+		return appendResult{err: &innerraft.PartialAppendError{Offset: offsets[0], Appended: len(offsets), Err: err}}
+	}
+	return appendResult{err: err}
+}
+
+type appendResult struct {
+	offset uint64
+	err    error
+}
+
+func (r appendResult) Offset() (uint64, error) {
+	return r.offset, r.err
+}
+
+func (l *countingLog) Read(off uint64) (*pb.Record, error) {
+	atomic.AddUint64(&l.reads, 1)
+	return l.Log.Read(off)
+}
+
+func (l *countingLog) Reads() uint64 {
+	return atomic.LoadUint64(&l.reads)
+}
+
+func setupTest(t *testing.T, limiter quota.ILimiter) (clients clients, teardown func()) {
 	t.Helper()
 
 	ports := dynaport.Get(3)
@@ -87,7 +270,15 @@ func setupTest(t *testing.T) (clients clients, teardown func()) {
 	clog, err := log.NewLog(log.Config{DataDir: dataDir})
 	require.NoError(t, err)
 
-	authorizer := auth.NewAuthorizer(auth.Args{ModelFile: innertls.ACLModelFile, PolicyFile: innertls.ACLPolicyFile})
+	authenticator, err := auth.NewAuthenticator(auth.AuthenticatorArgs{Mode: auth.ModeMTLS})
+	require.NoError(t, err)
+	clients.Auditor = &fakeAuditor{}
+	authorizer, err := auth.NewAuthorizer(auth.Args{
+		ModelFile:  innertls.ACLModelFile,
+		PolicyFile: innertls.ACLPolicyFile,
+		Auditor:    clients.Auditor,
+	})
+	require.NoError(t, err)
 
 	var telemetryExporter *exporter.LogExporter
 	if *debug {
@@ -109,7 +300,10 @@ func setupTest(t *testing.T) (clients clients, teardown func()) {
 		require.NoError(t, err)
 	}
 
-	server, err := NewGRPCServer(clog, authorizer, nil, tlsConfig)
+	clients.Log = &countingLog{Log: clog}
+	clients.Admin = &fakeAdmin{servers: map[string]string{}}
+	clients.Drain = NewDrain()
+	server, err := NewGRPCServer(clients.Log, authenticator, authorizer, limiter, clients.Auditor, nil, clients.Admin, nil, nil, clients.Drain, tlsConfig, Args{ProduceWindow: 4})
 	require.NoError(t, err)
 
 	go func() {
@@ -141,6 +335,8 @@ func setupTest(t *testing.T) (clients clients, teardown func()) {
 	nobodyConn, clients.Nobody = newLogClient(innertls.NobodyClientCertFile, innertls.NobodyClientKeyFile)
 
 	clients.Health = healthpb.NewHealthClient(nobodyConn)
+	clients.RootAdmin = pb.NewAdminClient(rootConn)
+	clients.NobodyAdmin = pb.NewAdminClient(nobodyConn)
 
 	return clients, func() {
 		server.Stop()
@@ -185,6 +381,17 @@ func testConsumePastBoundary(t *testing.T, clients clients) {
 	require.Equal(t, want, got)
 }
 
+func testUnknownPartition(t *testing.T, clients clients) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := clients.Root.Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("hello world")}, Partition: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = clients.Root.ConsumeRange(ctx, &pb.ConsumeRequest{Partition: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func testProduceConsumeStream(t *testing.T, clients clients) {
 	t.Helper()
 	ctx := context.Background()
@@ -220,6 +427,140 @@ func testProduceConsumeStream(t *testing.T, clients clients) {
 	})
 }
 
+func testProduceStreamPipelined(t *testing.T, clients clients) {
+	t.Helper()
+	ctx := context.Background()
+
+	stream, err := clients.Root.ProduceStream(ctx)
+	require.NoError(t, err)
+
+	// send more records than the window before reading any acks
+	const n = 16
+	for i := 0; i < n; i++ {
+		err := stream.Send(&pb.ProduceRequest{
+			Record:        &pb.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+			CorrelationId: uint64(100 + i),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	for i := 0; i < n; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(100+i), res.CorrelationId)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func testProduceStreamPartialBatch(t *testing.T, clients clients) {
+	t.Helper()
+	clients.Log.failAfter = 3
+	stream, err := clients.Root.ProduceStream(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		err := stream.Send(&pb.ProduceRequest{
+			Record:        &pb.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+			CorrelationId: uint64(100 + i),
+		})
+		require.NoError(t, err)
+	}
+
+	// the records written are acked, so that only the last is retried
+	for i := 0; i < 3; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(100+i), res.CorrelationId)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	_, err = stream.Recv()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
+
+func testConsumeRange(t *testing.T, clients clients) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		_, err := clients.Root.Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte(fmt.Sprintf("record %d", i))}})
+		require.NoError(t, err)
+	}
+
+	res, err := clients.Root.ConsumeRange(ctx, &pb.ConsumeRequest{Offset: 1, MaxRecords: 3})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), res.HighWatermark)
+	require.Len(t, res.Records, 3)
+	require.Equal(t, []byte("record 1"), res.Records[0].Value)
+	require.Equal(t, uint64(3), res.Records[2].Offset)
+
+	_, err = clients.Root.ConsumeRange(ctx, &pb.ConsumeRequest{Offset: 5, MaxRecords: 3})
+	require.Equal(t, status.Code((&OffsetOutOfRangeError{}).GRPCStatus().Err()), status.Code(err))
+
+	stream, err := clients.Root.ConsumeStream(ctx, &pb.ConsumeRequest{Offset: 0, MaxRecords: 2})
+	require.NoError(t, err)
+	var got []*pb.Record
+	for len(got) < 5 {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(res.Records), 2)
+		got = append(got, res.Records...)
+	}
+	for i, record := range got {
+		require.Equal(t, uint64(i), record.Offset)
+	}
+}
+
+func testProduceAcks(t *testing.T, clients clients) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, acks := range []pb.Acks{pb.Acks_ACKS_QUORUM, pb.Acks_ACKS_LEADER, pb.Acks_ACKS_NONE} {
+		_, err := clients.Root.Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("hello world")}, Acks: acks})
+		require.NoError(t, err)
+	}
+
+	rows, err := view.RetrieveData(ProduceCountView.Name)
+	require.NoError(t, err)
+	levels := make(map[string]bool)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == KeyAcks {
+				levels[tag.Value] = true
+			}
+		}
+	}
+	require.True(t, levels[pb.Acks_ACKS_QUORUM.String()])
+	require.True(t, levels[pb.Acks_ACKS_LEADER.String()])
+	require.True(t, levels[pb.Acks_ACKS_NONE.String()])
+}
+
+func testConsumeStreamIdle(t *testing.T, clients clients) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := clients.Root.ConsumeStream(ctx, &pb.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	// give a spinning stream plenty of time to show up in the read count
+	time.Sleep(200 * time.Millisecond)
+	require.LessOrEqual(t, clients.Log.Reads(), uint64(1))
+
+	want := &pb.Record{Value: []byte("hello world")}
+	_, err = clients.Root.Produce(ctx, &pb.ProduceRequest{Record: want})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, want.Value, res.Record.Value)
+	// the miss before waiting, the delivered record and the next miss
+	require.LessOrEqual(t, clients.Log.Reads(), uint64(3))
+}
+
 func testUnauthorized(t *testing.T, clients clients) {
 	t.Helper()
 	ctx := context.Background()
@@ -249,6 +590,83 @@ func testHealthCheck(t *testing.T, clients clients) {
 	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
 }
 
+func testDrain(t *testing.T, clients clients) {
+	t.Helper()
+	ctx := context.Background()
+
+	stream, err := clients.Root.ConsumeStream(ctx, &pb.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	// make sure the stream is waiting for records before draining
+	time.Sleep(100 * time.Millisecond)
+
+	clients.Drain.Start()
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = clients.Root.Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("hello world")}})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	res, err := clients.Health.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+}
+
+func TestQuota(t *testing.T) {
+	quotaFile := filepath.Join(t.TempDir(), "quotas")
+	require.NoError(t, os.WriteFile(quotaFile, []byte("root produce 2 -\nroot consume - 64\n"), 0o600))
+	limiter, err := quota.NewLimiter(quota.Args{File: quotaFile})
+	require.NoError(t, err)
+	clients, teardown := setupTest(t, limiter)
+	defer teardown()
+	ctx := context.Background()
+
+	// two produces a second
+	record := &pb.Record{Value: make([]byte, 100)}
+	var offsets []uint64
+	for i := 0; i < 2; i++ {
+		res, err := clients.Root.Produce(ctx, &pb.ProduceRequest{Record: record})
+		require.NoError(t, err)
+		offsets = append(offsets, res.Offset)
+	}
+	_, err = clients.Root.Produce(ctx, &pb.ProduceRequest{Record: record})
+	requireRetryAfter(t, err)
+
+	// the first consume is bigger than the quota and the next has to wait
+	_, err = clients.Root.Consume(ctx, &pb.ConsumeRequest{Offset: offsets[0]})
+	require.NoError(t, err)
+	_, err = clients.Root.Consume(ctx, &pb.ConsumeRequest{Offset: offsets[1]})
+	requireRetryAfter(t, err)
+
+	// streams over the quota fail the same way rather than wait
+	produceStream, err := clients.Root.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, produceStream.Send(&pb.ProduceRequest{Record: record}))
+	_, err = produceStream.Recv()
+	requireRetryAfter(t, err)
+	consumeStream, err := clients.Root.ConsumeStream(ctx, &pb.ConsumeRequest{Offset: offsets[0]})
+	require.NoError(t, err)
+	_, err = consumeStream.Recv()
+	requireRetryAfter(t, err)
+
+	rows, err := view.RetrieveData(quota.QuotaThrottledView.Name)
+	require.NoError(t, err)
+	require.NotEmpty(t, rows)
+}
+
+func requireRetryAfter(t *testing.T, err error) {
+	t.Helper()
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			require.Positive(t, info.RetryDelay.AsDuration())
+			return
+		}
+	}
+	require.Fail(t, "no retry info", "%v", st.Details())
+}
+
 func TestErrOffsetOutOfRange(t *testing.T) {
 	err := func() error {
 		return log.OffsetOutOfRangeError{Offset: 2}
@@ -257,3 +675,72 @@ func TestErrOffsetOutOfRange(t *testing.T) {
 
 	fmt.Println(OffsetOutOfRangeError{err.(log.OffsetOutOfRangeError).Offset})
 }
+
+func testAdmin(t *testing.T, clients clients) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := clients.NobodyAdmin.AddVoter(ctx, &pb.AddServerRequest{Id: "node", RpcAddr: "127.0.0.1:0"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = clients.NobodyAdmin.RaftStats(ctx, &pb.RaftStatsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = clients.RootAdmin.AddVoter(ctx, &pb.AddServerRequest{Id: "voter", RpcAddr: "127.0.0.1:1"})
+	require.NoError(t, err)
+	_, err = clients.RootAdmin.AddNonvoter(ctx, &pb.AddServerRequest{Id: "nonvoter", RpcAddr: "127.0.0.1:2"})
+	require.NoError(t, err)
+	_, err = clients.RootAdmin.RemoveServer(ctx, &pb.RemoveServerRequest{Id: "voter"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"nonvoter": "127.0.0.1:2"}, clients.Admin.servers)
+
+	_, err = clients.RootAdmin.TransferLeadership(ctx, &pb.TransferLeadershipRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	snapshot, err := clients.RootAdmin.Snapshot(ctx, &pb.SnapshotRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "1-2-3", snapshot.Snapshot.Id)
+	snapshots, err := clients.RootAdmin.ListSnapshots(ctx, &pb.ListSnapshotsRequest{})
+	require.NoError(t, err)
+	require.Len(t, snapshots.Snapshots, 1)
+
+	stats, err := clients.RootAdmin.RaftStats(ctx, &pb.RaftStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Leader", stats.Stats["state"])
+
+	_, err = clients.RootAdmin.MovePartition(ctx, &pb.MovePartitionRequest{FromId: "voter", ToId: "nonvoter"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = clients.NobodyAdmin.Rebalance(ctx, &pb.RebalanceRequest{DryRun: true})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	policy := &pb.Policy{Ptype: "p", Fields: []string{"nobody", "*", "admin"}}
+	_, err = clients.NobodyAdmin.Grant(ctx, &pb.GrantRequest{Policy: policy})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = clients.RootAdmin.Grant(ctx, &pb.GrantRequest{Policy: policy})
+	require.NoError(t, err)
+	policies, err := clients.RootAdmin.ListPolicies(ctx, &pb.ListPoliciesRequest{})
+	require.NoError(t, err)
+	require.True(t, policies.Replicated)
+	require.Len(t, policies.Policies, 1)
+	require.Equal(t, policy.Fields, policies.Policies[0].Fields)
+	_, err = clients.RootAdmin.Revoke(ctx, &pb.RevokeRequest{Policy: policy})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// nobody's denied grant is audited as a decision and as an attempt
+	decisions := clients.Auditor.find(audit.KindAuthorization, "admin")
+	require.NotEmpty(t, decisions)
+	var denied bool
+	for _, e := range decisions {
+		denied = denied || (e.Subject == "nobody" && e.Decision == audit.Deny)
+	}
+	require.True(t, denied)
+	grants := clients.Auditor.find(audit.KindAdmin, "Grant")
+	require.Len(t, grants, 2)
+	require.Equal(t, "nobody", grants[0].Subject)
+	require.Equal(t, codes.PermissionDenied.String(), grants[0].Code)
+	require.Equal(t, "root", grants[1].Subject)
+	require.Equal(t, codes.OK.String(), grants[1].Code)
+	require.JSONEq(t, `{"policy":{"ptype":"p","fields":["nobody","*","admin"]}}`, string(grants[1].Request))
+	revokes := clients.Auditor.find(audit.KindAdmin, "Revoke")
+	require.Len(t, revokes, 1)
+	require.Equal(t, codes.NotFound.String(), revokes[0].Code)
+}
@@ -3,98 +3,335 @@ package server
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/travisjeffery/proglog/internal/grpc/auth"
-	"github.com/travisjeffery/proglog/internal/log"
 	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	innerraft "github.com/travisjeffery/proglog/internal/raft"
 	"github.com/travisjeffery/proglog/internal/raftapp"
 )
 
 type service struct {
-	CommitLog   raftapp.IResource
-	Authorizer  auth.IAuthorizer
-	GetServerer raftapp.IServers
+	Resources     raftapp.IResources
+	Authorizer    auth.IAuthorizer
+	GetServerer   raftapp.IServers
+	Drain         *Drain
+	ProduceWindow int
+	LogName       string
 	pb.UnimplementedLogServer
 }
 
-func newService(commitLog raftapp.IResource, authorizable auth.IAuthorizer, getServerer raftapp.IServers) *service {
+func newService(resources raftapp.IResources, authorizable auth.IAuthorizer, getServerer raftapp.IServers, drain *Drain, args Args) *service {
 	srv := &service{
-		CommitLog:   commitLog,
-		Authorizer:  authorizable,
-		GetServerer: getServerer,
+		Resources:     resources,
+		Authorizer:    authorizable,
+		GetServerer:   getServerer,
+		Drain:         drain,
+		ProduceWindow: args.ProduceWindow,
+		LogName:       args.LogName,
 	}
 	return srv
 }
 
 func (s *service) Produce(ctx context.Context, req *pb.ProduceRequest) (*pb.ProduceResponse, error) {
-	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, produceAction); err != nil {
+	if err := s.Authorizer.Authorize(ctx, subject(ctx), auth.Object(s.LogName, req.Partition), produceAction); err != nil {
 		return nil, err
 	}
-	offset, err := s.CommitLog.Append(req.Record)
+	if s.Drain.draining() {
+		return nil, errDraining
+	}
+	resource, err := s.resource(req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	offset, err := resource.AppendAsync(req.Record, req.Acks).Offset()
 	if err != nil {
 		return nil, err
 	}
-	return &pb.ProduceResponse{Offset: offset}, nil
+	recordProduce(ctx, req.Acks, start)
+	return &pb.ProduceResponse{Offset: offset, CorrelationId: req.CorrelationId}, nil
 }
 
 func (s *service) Consume(ctx context.Context, req *pb.ConsumeRequest) (*pb.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
+	if err := s.Authorizer.Authorize(ctx, subject(ctx), auth.Object(s.LogName, req.Partition), consumeAction); err != nil {
 		return nil, err
 	}
-	record, err := s.CommitLog.Read(req.Offset)
+	resource, err := s.resource(req.Partition)
 	if err != nil {
-		if errors.As(err, &log.OffsetOutOfRangeError{}) {
-			//nolint:errorlint,forcetypeassert //reason: false positive
-			return nil, OffsetOutOfRangeError{err.(log.OffsetOutOfRangeError).Offset}
-		}
 		return nil, err
 	}
+	record, err := resource.Read(req.Offset)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
 	return &pb.ConsumeResponse{Record: record}, nil
 }
 
+// produceAck is a batch of records handed to raft together.
+type produceAck struct {
+	correlationIDs []uint64
+	acks           pb.Acks
+	start          time.Time
+	future         raftapp.AppendFuture
+}
+
+// offset returns the offset of the ith record of the batch given the first's.
+// Only quorum acks know the offsets.
+func (a *produceAck) offset(first uint64, i int) uint64 {
+	if a.acks == pb.Acks_ACKS_LEADER || a.acks == pb.Acks_ACKS_NONE {
+		return 0
+	}
+	return first + uint64(i)
+}
+
+// ProduceStream pipelines the stream: records are handed to raft as soon as
+// they are received, the ones received together as a single command, and
+// acked in order once committed, with at most ProduceWindow records in
+// flight. Once draining, records already handed to raft are acked and the
+// stream ends with errDraining.
 func (s *service) ProduceStream(stream pb.Log_ProduceStreamServer) error {
+	reqs := make(chan *pb.ProduceRequest, s.ProduceWindow)
+	acks := make(chan *produceAck, s.ProduceWindow)
+	inflight := make(chan struct{}, s.ProduceWindow)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(reqs)
+		recvErr <- receiveProduces(stream, reqs, inflight)
+	}()
+	batchErr := make(chan error, 1)
+	go func() {
+		defer close(acks)
+		batchErr <- s.batchProduces(stream.Context(), reqs, acks)
+	}()
 	for {
-		req, err := stream.Recv()
+		var ack *produceAck
+		select {
+		case ack = <-acks:
+		case <-s.Drain.Done():
+			// ack what was already handed to raft, then end the stream
+			select {
+			case ack = <-acks:
+			default:
+				return errDraining
+			}
+		}
+		if ack == nil {
+			break
+		}
+		offset, err := ack.future.Offset()
+		// ack the records of a partly appended batch that were written, so
+		// that the client only retries the rest
+		ids := ack.correlationIDs
+		var partial *innerraft.PartialAppendError
+		if errors.As(err, &partial) {
+			offset, ids = partial.Offset, ids[:partial.Appended]
+		} else if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			recordProduce(stream.Context(), ack.acks, ack.start)
+			if err := stream.Send(&pb.ProduceResponse{Offset: ack.offset(offset, i), CorrelationId: id}); err != nil {
+				return err
+			}
+			<-inflight
+		}
 		if err != nil {
 			return err
 		}
-		res, err := s.Produce(stream.Context(), req)
+	}
+	if err := <-batchErr; err != nil {
+		return err
+	}
+	if err := <-recvErr; !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// receiveProduces reads the stream while fewer than ProduceWindow records
+// are in flight.
+func receiveProduces(stream pb.Log_ProduceStreamServer, reqs chan<- *pb.ProduceRequest, inflight chan struct{}) error {
+	ctx := stream.Context()
+	for {
+		select {
+		case inflight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		req, err := stream.Recv()
 		if err != nil {
 			return err
 		}
-		if err := stream.Send(res); err != nil {
+		reqs <- req
+	}
+}
+
+// batchProduces hands the received records to raft, batching the ones
+// already received for the same partition and acks level.
+func (s *service) batchProduces(ctx context.Context, reqs <-chan *pb.ProduceRequest, acks chan<- *produceAck) error {
+	var next *pb.ProduceRequest
+	for {
+		if next == nil {
+			var ok bool
+			if next, ok = <-reqs; !ok {
+				return nil
+			}
+		}
+		batch := []*pb.ProduceRequest{next}
+		next = nil
+	collect:
+		for {
+			select {
+			case req, ok := <-reqs:
+				if !ok {
+					break collect
+				}
+				if req.Partition != batch[0].Partition || req.Acks != batch[0].Acks {
+					next = req
+					break collect
+				}
+				batch = append(batch, req)
+			default:
+				break collect
+			}
+		}
+		ack, err := s.appendProduces(ctx, batch)
+		if err != nil {
 			return err
 		}
+		acks <- ack
+	}
+}
+
+func (s *service) appendProduces(ctx context.Context, batch []*pb.ProduceRequest) (*produceAck, error) {
+	partition, acks := batch[0].Partition, batch[0].Acks
+	if err := s.Authorizer.Authorize(ctx, subject(ctx), auth.Object(s.LogName, partition), produceAction); err != nil {
+		return nil, err
 	}
+	if s.Drain.draining() {
+		return nil, errDraining
+	}
+	resource, err := s.resource(partition)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*pb.Record, len(batch))
+	ack := &produceAck{correlationIDs: make([]uint64, len(batch)), acks: acks, start: time.Now()}
+	for i, req := range batch {
+		records[i] = req.Record
+		ack.correlationIDs[i] = req.CorrelationId
+	}
+	ack.future = resource.AppendBatchAsync(records, acks)
+	return ack, nil
+}
+
+func (s *service) ConsumeRange(ctx context.Context, req *pb.ConsumeRequest) (*pb.ConsumeResponse, error) {
+	if err := s.Authorizer.Authorize(ctx, subject(ctx), auth.Object(s.LogName, req.Partition), consumeAction); err != nil {
+		return nil, err
+	}
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultConsumeMaxBytes
+	}
+	resource, err := s.resource(req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	records, highWatermark, err := resource.ReadRange(req.Offset, int(req.MaxRecords), maxBytes)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return &pb.ConsumeResponse{Records: records, HighWatermark: highWatermark}, nil
 }
 
 func (s *service) ConsumeStream(req *pb.ConsumeRequest, stream pb.Log_ConsumeStreamServer) error {
+	if req.MaxRecords > 0 || req.MaxBytes > 0 {
+		return s.consumeStreamBatches(req, stream)
+	}
+	ctx, cancel := s.Drain.context(stream.Context())
+	defer cancel()
 	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			//nolint:errorlint //reason: false positive
-			switch err.(type) {
-			case nil:
-			case OffsetOutOfRangeError:
-				continue
-			default:
+		res, err := s.Consume(ctx, req)
+		//nolint:errorlint //reason: false positive
+		switch err.(type) {
+		case nil:
+		case OffsetOutOfRangeError:
+			if ok, err := s.waitForRecord(ctx, req.Partition, req.Offset); !ok {
 				return err
 			}
-			if err := stream.Send(res); err != nil {
+			continue
+		default:
+			return err
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+		if s.Drain.draining() {
+			return errDraining
+		}
+		req.Offset++
+	}
+}
+
+func (s *service) consumeStreamBatches(req *pb.ConsumeRequest, stream pb.Log_ConsumeStreamServer) error {
+	ctx, cancel := s.Drain.context(stream.Context())
+	defer cancel()
+	for {
+		res, err := s.ConsumeRange(ctx, req)
+		//nolint:errorlint //reason: false positive
+		switch err.(type) {
+		case nil:
+		case OffsetOutOfRangeError:
+			if ok, err := s.waitForRecord(ctx, req.Partition, req.Offset); !ok {
 				return err
 			}
-			req.Offset++
+			continue
+		default:
+			return err
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+		if s.Drain.draining() {
+			return errDraining
 		}
+		req.Offset += uint64(len(res.Records))
 	}
 }
 
+// waitForRecord blocks until the record at offset is appended instead of
+// polling the log. It reports false once the stream should end.
+func (s *service) waitForRecord(ctx context.Context, partition uint32, offset uint64) (bool, error) {
+	resource, err := s.resource(partition)
+	if err != nil {
+		return false, err
+	}
+	if err := resource.Wait(ctx, offset); err != nil {
+		if s.Drain.draining() {
+			return false, errDraining
+		}
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		return false, toStatusError(err)
+	}
+	return true, nil
+}
+
 func (s *service) GetServers(ctx context.Context, req *pb.GetServersRequest) (*pb.GetServersResponse, error) {
-	servers, err := s.GetServerer.GetServers()
+	servers, err := s.GetServerer.GetServers(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &pb.GetServersResponse{Servers: servers}, nil
+	return &pb.GetServersResponse{Servers: servers, Partitions: s.GetServerer.Partitions()}, nil
+}
+
+func (s *service) resource(partition uint32) (raftapp.IResource, error) {
+	resource, err := s.Resources.Resource(partition)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return resource, nil
 }
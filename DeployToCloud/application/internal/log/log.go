@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +11,8 @@ import (
 	"strings"
 	"sync"
 
+	"google.golang.org/protobuf/proto"
+
 	pb "github.com/travisjeffery/proglog/internal/proto/v1"
 )
 
@@ -20,6 +23,10 @@ type Log struct {
 
 	activeSegment *segment
 	segments      []*segment
+
+	// appended is closed and replaced whenever the log advances so every
+	// tailing reader can wait on the same channel.
+	appended chan struct{}
 }
 
 type OffsetOutOfRangeError struct {
@@ -37,7 +44,7 @@ func NewLog(cfg Config) (*Log, error) {
 	if cfg.MaxIndexBytes == 0 {
 		cfg.MaxIndexBytes = 1024
 	}
-	l := &Log{Config: cfg}
+	l := &Log{Config: cfg, appended: make(chan struct{})}
 
 	if err := l.setup(cfg); err != nil {
 		return nil, err
@@ -86,9 +93,64 @@ func (l *Log) Append(record *pb.Record) (uint64, error) {
 	if l.activeSegment.IsMaxed() {
 		err = l.newSegment(off+1, l.Config)
 	}
+	l.notifyAppended()
 	return off, err
 }
 
+// AppendBatch appends the records in order under a single lock and wakes
+// waiting readers once. On error it returns the offsets appended so far.
+func (l *Log) AppendBatch(records []*pb.Record) ([]uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offsets := make([]uint64, 0, len(records))
+	defer func() {
+		if len(offsets) > 0 {
+			l.notifyAppended()
+		}
+	}()
+	for _, record := range records {
+		off, err := l.activeSegment.Append(record)
+		if err != nil {
+			return offsets, err
+		}
+		offsets = append(offsets, off)
+		if l.activeSegment.IsMaxed() {
+			if err := l.newSegment(off+1, l.Config); err != nil {
+				return offsets, err
+			}
+		}
+	}
+	return offsets, nil
+}
+
+func (l *Log) notifyAppended() {
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// Wait blocks until the record at off has been appended or ctx is done.
+// It returns OffsetOutOfRangeError when off has already been truncated.
+func (l *Log) Wait(ctx context.Context, off uint64) error {
+	for {
+		l.mu.RLock()
+		lowest := l.segments[0].baseOffset
+		next := l.activeSegment.nextOffset
+		appended := l.appended
+		l.mu.RUnlock()
+		if off < lowest {
+			return OffsetOutOfRangeError{Offset: off}
+		}
+		if off < next {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
 func (l *Log) Read(off uint64) (*pb.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -105,6 +167,50 @@ func (l *Log) Read(off uint64) (*pb.Record, error) {
 	return s.Read(off)
 }
 
+// ReadRange returns the records from off onwards, reading across segments
+// until maxRecords records or maxBytes bytes have been read, along with the
+// log's high watermark, the offset the next appended record will get. Zero
+// disables either limit, and at least one record is always returned.
+func (l *Log) ReadRange(off uint64, maxRecords int, maxBytes uint64) ([]*pb.Record, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var records []*pb.Record
+	var n uint64
+	var err error
+	full := false
+	next := off
+	for _, s := range l.segments {
+		if full || next < s.baseOffset || s.nextOffset <= next {
+			continue
+		}
+		scanErr := s.Scan(next, func(p []byte) bool {
+			if len(records) > 0 && maxBytes > 0 && n+uint64(len(p)) > maxBytes {
+				full = true
+				return false
+			}
+			record := &pb.Record{}
+			if err = proto.Unmarshal(p, record); err != nil {
+				return false
+			}
+			records = append(records, record)
+			n += uint64(len(p))
+			next++
+			full = maxRecords > 0 && len(records) >= maxRecords
+			return !full
+		})
+		if scanErr != nil {
+			return nil, 0, scanErr
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(records) == 0 {
+		return nil, 0, OffsetOutOfRangeError{Offset: off}
+	}
+	return records, l.activeSegment.nextOffset, nil
+}
+
 func (l *Log) newSegment(off uint64, cfg Config) error {
 	s, err := newSegment(off, cfg)
 	if err != nil {
@@ -118,6 +224,11 @@ func (l *Log) newSegment(off uint64, cfg Config) error {
 func (l *Log) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	return l.close()
+}
+
+// close closes the segments. Callers hold mu.
+func (l *Log) close() error {
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
@@ -127,16 +238,29 @@ func (l *Log) Close() error {
 }
 
 func (l *Log) Remove() error {
-	if err := l.Close(); err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.remove()
+}
+
+// remove closes the segments and removes their files. Callers hold mu.
+func (l *Log) remove() error {
+	if err := l.close(); err != nil {
 		return err
 	}
 	return os.RemoveAll(l.Config.DataDir)
 }
 
 func (l *Log) Reset() error {
-	if err := l.Remove(); err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.remove(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.Config.DataDir, 0o755); err != nil {
 		return err
 	}
+	l.segments = nil
 	return l.setup(l.Config)
 }
 
@@ -172,6 +296,66 @@ func (l *Log) Truncate(lowest uint64) error {
 		segments = append(segments, s)
 	}
 	l.segments = segments
+	if len(segments) == 0 {
+		// keep an empty active segment so the log continues after lowest
+		return l.newSegment(lowest+1, l.Config)
+	}
+	return nil
+}
+
+// SegmentSnapshot describes a segment as of a snapshot. Segments only grow,
+// so the first StoreSize bytes of the store and IndexSize bytes of the index
+// stay valid while the active segment is appended to.
+type SegmentSnapshot struct {
+	BaseOffset uint64 `json:"base_offset"`
+	StoreSize  uint64 `json:"store_size"`
+	IndexSize  uint64 `json:"index_size"`
+}
+
+// LinkSegments hard-links the store and index of every segment into dir, so
+// the files outlive truncation, and returns their sizes as of now.
+func (l *Log) LinkSegments(dir string) ([]SegmentSnapshot, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	segments := make([]SegmentSnapshot, 0, len(l.segments))
+	for _, s := range l.segments {
+		if err := s.store.Flush(); err != nil {
+			return nil, err
+		}
+		if err := os.Link(s.store.Name(), path.Join(dir, StoreFile(s.baseOffset))); err != nil {
+			return nil, err
+		}
+		if err := os.Link(s.index.Name(), path.Join(dir, IndexFile(s.baseOffset))); err != nil {
+			return nil, err
+		}
+		segments = append(segments, SegmentSnapshot{
+			BaseOffset: s.baseOffset,
+			StoreSize:  s.store.size,
+			IndexSize:  s.index.size,
+		})
+	}
+	return segments, nil
+}
+
+// Restore replaces every segment with the segment files install writes into
+// the emptied data directory.
+func (l *Log) Restore(install func(dir string) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.remove(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.Config.DataDir, 0o755); err != nil {
+		return err
+	}
+	l.segments = nil
+	if err := install(l.Config.DataDir); err != nil {
+		return err
+	}
+	if err := l.setup(l.Config); err != nil {
+		return err
+	}
+	l.notifyAppended()
 	return nil
 }
 
@@ -185,13 +369,16 @@ func (l *Log) Reader() io.Reader {
 	return io.MultiReader(readers...)
 }
 
+// originReader reads a store from its start. It doesn't embed the store so
+// the file's WriterTo isn't promoted: io.Copy would copy from the file's
+// current position instead of calling Read.
 type originReader struct {
-	*store
-	off int64
+	store *store
+	off   int64
 }
 
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
+	n, err := o.store.ReadAt(p, o.off)
 	o.off += int64(n)
 	return n, err
 }
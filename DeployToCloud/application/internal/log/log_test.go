@@ -1,9 +1,14 @@
 package log
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
@@ -17,6 +22,14 @@ func TestLog(t *testing.T) {
 		"offset out of range error":         testOutOfRangeErr,
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
+		"reader copies with io.Copy":        testReaderCopy,
+		"wait for append":                   testWait,
+		"read range across segments":        testReadRange,
+		"append batch across segments":      testAppendBatch,
+		"truncate every segment":            testTruncateAll,
+		"reset":                             testReset,
+		"link segments and restore":         testLinkSegmentsRestore,
+		"restore while appending":           testRestoreWhileAppending,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "store-test")
@@ -83,7 +96,188 @@ func testReader(t *testing.T, log *Log) {
 	require.NoError(t, err)
 
 	read := &pb.Record{}
-	err = proto.Unmarshal(b, read)
+	err = proto.Unmarshal(b[LenWidth:], read)
 	require.NoError(t, err)
 	require.Equal(t, a.Value, read.Value)
 }
+
+func testWait(t *testing.T, log *Log) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, log.Wait(ctx, 0), context.DeadlineExceeded)
+
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(context.Background(), 0)
+	}()
+	_, err := log.Append(&pb.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	select {
+	case err := <-waited:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait was not woken by append")
+	}
+	require.NoError(t, log.Wait(context.Background(), 0))
+}
+
+func testReadRange(t *testing.T, log *Log) {
+	t.Helper()
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&pb.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	// the test config rolls a new segment every couple of records
+	require.Greater(t, len(log.segments), 1)
+
+	records, highWatermark, err := log.ReadRange(1, 3, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), highWatermark)
+	require.Len(t, records, 3)
+	for i, record := range records {
+		require.Equal(t, uint64(i+1), record.Offset)
+		require.Equal(t, []byte(fmt.Sprintf("record %d", i+1)), record.Value)
+	}
+
+	records, _, err = log.ReadRange(0, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+
+	// a byte limit smaller than a record still returns one record
+	records, _, err = log.ReadRange(2, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(2), records[0].Offset)
+
+	_, _, err = log.ReadRange(5, 0, 0)
+	require.ErrorAs(t, err, &OffsetOutOfRangeError{})
+}
+
+func testAppendBatch(t *testing.T, log *Log) {
+	t.Helper()
+	records := make([]*pb.Record, 5)
+	for i := range records {
+		records[i] = &pb.Record{Value: []byte(fmt.Sprintf("batch %d", i))}
+	}
+	offsets, err := log.AppendBatch(records)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2, 3, 4}, offsets)
+	require.Greater(t, len(log.segments), 1)
+
+	for i, off := range offsets {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, records[i].Value, read.Value)
+	}
+	require.NoError(t, log.Wait(context.Background(), 4))
+}
+
+func testTruncateAll(t *testing.T, log *Log) {
+	t.Helper()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&pb.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Truncate(2))
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	off, err := log.Append(&pb.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testReset(t *testing.T, log *Log) {
+	t.Helper()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&pb.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Reset())
+
+	_, err := log.Read(0)
+	require.Error(t, err)
+	off, err := log.Append(&pb.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+}
+
+func testLinkSegmentsRestore(t *testing.T, log *Log) {
+	t.Helper()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&pb.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	dir := t.TempDir()
+	segments, err := log.LinkSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	_, err = log.Append(&pb.Record{Value: []byte("after")})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Restore(func(dataDir string) error {
+		for _, s := range segments {
+			for name, size := range map[string]uint64{
+				StoreFile(s.BaseOffset): s.StoreSize,
+				IndexFile(s.BaseOffset): s.IndexSize,
+			} {
+				b, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(filepath.Join(dataDir, name), b[:size], 0o644); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+	off, err := log.Append(&pb.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testRestoreWhileAppending(t *testing.T, log *Log) {
+	t.Helper()
+	done := make(chan struct{})
+	appendErr := make(chan error, 1)
+	go func() {
+		defer close(appendErr)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := log.Append(&pb.Record{Value: []byte("hello world")}); err != nil {
+				appendErr <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, log.Restore(func(string) error { return nil }))
+	}
+	close(done)
+	require.NoError(t, <-appendErr)
+}
+
+func testReaderCopy(t *testing.T, log *Log) {
+	t.Helper()
+	_, err := log.Append(&pb.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, log.Reader())
+	require.NoError(t, err)
+	require.NotZero(t, n)
+	read := &pb.Record{}
+	require.NoError(t, proto.Unmarshal(buf.Bytes()[LenWidth:], read))
+	require.Equal(t, []byte("hello world"), read.Value)
+}
@@ -1,7 +1,9 @@
 package log
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path"
 
@@ -31,7 +33,7 @@ func newSegment(baseOffset uint64, cfg Config) (*segment, error) {
 		config:     cfg,
 	}
 	var err error
-	p := path.Join(cfg.DataDir, fmt.Sprintf("%d%s", baseOffset, ".store"))
+	p := path.Join(cfg.DataDir, StoreFile(baseOffset))
 	storeFile, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
 	if err != nil {
 		return nil, err
@@ -39,7 +41,7 @@ func newSegment(baseOffset uint64, cfg Config) (*segment, error) {
 	if s.store, err = newStore(storeFile); err != nil {
 		return nil, err
 	}
-	p = path.Join(cfg.DataDir, fmt.Sprintf("%d%s", baseOffset, ".index"))
+	p = path.Join(cfg.DataDir, IndexFile(baseOffset))
 	indexFile, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0o644)
 	if err != nil {
 		return nil, err
@@ -55,6 +57,18 @@ func newSegment(baseOffset uint64, cfg Config) (*segment, error) {
 	return s, nil
 }
 
+// StoreFile returns the name of the store file of the segment starting at
+// baseOffset.
+func StoreFile(baseOffset uint64) string {
+	return fmt.Sprintf("%d%s", baseOffset, ".store")
+}
+
+// IndexFile returns the name of the index file of the segment starting at
+// baseOffset.
+func IndexFile(baseOffset uint64) string {
+	return fmt.Sprintf("%d%s", baseOffset, ".index")
+}
+
 func (s *segment) Append(record *pb.Record) (offset uint64, err error) {
 	cur := s.nextOffset
 	record.Offset = cur
@@ -88,6 +102,34 @@ func (s *segment) Read(off uint64) (*pb.Record, error) {
 	return record, err
 }
 
+// Scan calls fn with each encoded record from off onwards, reading the store
+// sequentially so only the first record needs an index lookup. It stops at
+// the end of the segment or once fn returns false.
+func (s *segment) Scan(off uint64, fn func(p []byte) bool) error {
+	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	if err != nil {
+		return err
+	}
+	if err := s.store.Flush(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(s.store.File, int64(pos), int64(s.store.size-pos)))
+	size := make([]byte, LenWidth)
+	for ; off < s.nextOffset; off++ {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return err
+		}
+		p := make([]byte, Enc.Uint64(size))
+		if _, err := io.ReadFull(r, p); err != nil {
+			return err
+		}
+		if !fn(p) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.MaxStoreBytes ||
 		s.index.size >= s.config.MaxIndexBytes
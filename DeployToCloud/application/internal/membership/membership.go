@@ -3,6 +3,8 @@ package membership
 import (
 	"errors"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/hashicorp/raft"
 	"github.com/hashicorp/serf/serf"
@@ -11,11 +13,32 @@ import (
 	"github.com/travisjeffery/proglog/internal/raftapp"
 )
 
+const (
+	// RPCAddrTag is the serf tag carrying the member's raft/gRPC address.
+	RPCAddrTag = "rpc_addr"
+	// RoleTag is the serf tag carrying RoleVoter or RoleNonvoter. Members
+	// without it join as voters.
+	RoleTag      = "role"
+	RoleVoter    = "voter"
+	RoleNonvoter = "nonvoter"
+	// ExpectTag carries the member's BootstrapExpect and StateTag is set once
+	// the member has raft state, so nobody bootstraps an existing cluster.
+	ExpectTag = "expect"
+	StateTag  = "raft_state"
+
+	reconcileInterval = time.Second
+)
+
 type Membership struct {
 	handler raftapp.IMembershipHandler
 	serf    *serf.Serf
 	events  chan serf.Event
 	logger  *zap.Logger
+
+	tags            map[string]string
+	bootstrapExpect int
+	bootstrapped    bool
+	left            chan struct{}
 }
 
 type Args struct {
@@ -24,13 +47,33 @@ type Args struct {
 	BindAddr       string
 	RPCAddr        string
 	StartJoinAddrs []string
+	// BootstrapExpect is the number of voters to wait for before
+	// bootstrapping raft with all of them. Zero never bootstraps.
+	BootstrapExpect int
 }
 
 func NewMembership(handler raftapp.IMembershipHandler, args Args) (*Membership, error) {
 	c := &Membership{
-		handler: handler,
-		logger:  zap.L().Named("membership"),
+		handler:         handler,
+		logger:          zap.L().Named("membership"),
+		tags:            map[string]string{},
+		bootstrapExpect: args.BootstrapExpect,
+		left:            make(chan struct{}),
+	}
+	for k, v := range args.Tags {
+		c.tags[k] = v
+	}
+	hasState, err := handler.HasState()
+	if err != nil {
+		return nil, err
+	}
+	if c.bootstrapExpect > 0 {
+		c.tags[ExpectTag] = strconv.Itoa(c.bootstrapExpect)
+	}
+	if hasState {
+		c.tags[StateTag] = "true"
 	}
+	args.Tags = c.tags
 
 	addr, err := net.ResolveTCPAddr("tcp", args.BindAddr)
 	if err != nil {
@@ -42,6 +85,7 @@ func NewMembership(handler raftapp.IMembershipHandler, args Args) (*Membership,
 		return nil, err
 	}
 	go c.eventHandler()
+	go c.reconcile()
 	if args.StartJoinAddrs == nil {
 		return c, nil
 	}
@@ -68,11 +112,14 @@ func (m *Membership) eventHandler() {
 			//nolint:forcetypeassert //reason: explicit
 			for _, member := range e.(serf.MemberEvent).Members {
 				if !m.isLocal(member) {
-					if err := m.handler.Join(member.Name, member.Tags["rpc_addr"]); err != nil {
+					if err := m.handler.Join(member.Name, member.Tags[RPCAddrTag], member.Tags[RoleTag] != RoleNonvoter); err != nil {
 						m.logError(err, "failed to join", member)
 					}
 				}
 			}
+			m.maybeBootstrap()
+		case serf.EventMemberUpdate:
+			m.maybeBootstrap()
 		case serf.EventMemberLeave, serf.EventMemberFailed:
 			//nolint:forcetypeassert //reason: for explicit
 			for _, member := range e.(serf.MemberEvent).Members {
@@ -86,6 +133,94 @@ func (m *Membership) eventHandler() {
 	}
 }
 
+// maybeBootstrap bootstraps raft with every alive voter once BootstrapExpect
+// of them are present and none has raft state.
+func (m *Membership) maybeBootstrap() {
+	if m.bootstrapExpect == 0 || m.bootstrapped {
+		return
+	}
+	voters := map[string]string{}
+	for _, member := range m.serf.Members() {
+		if member.Status != serf.StatusAlive {
+			continue
+		}
+		if member.Tags[StateTag] != "" {
+			// the cluster already exists, we'll be added by its leader
+			m.bootstrapped = true
+			return
+		}
+		if member.Tags[RoleTag] == RoleNonvoter || member.Tags[ExpectTag] == "" {
+			// it waits to be added once the cluster exists
+			continue
+		}
+		if expect := member.Tags[ExpectTag]; expect != m.tags[ExpectTag] {
+			m.logger.Error("member has a different bootstrap expect",
+				zap.String("name", member.Name), zap.String("expect", expect))
+			return
+		}
+		voters[member.Name] = member.Tags[RPCAddrTag]
+	}
+	if _, ok := voters[m.serf.LocalMember().Name]; !ok || len(voters) < m.bootstrapExpect {
+		return
+	}
+	m.bootstrapped = true
+	m.logger.Info("bootstrapping raft", zap.Int("voters", len(voters)))
+	go func() {
+		if err := m.handler.Bootstrap(voters); err != nil {
+			m.logger.Error("failed to bootstrap", zap.Error(err))
+		}
+	}()
+}
+
+// reconcile advertises StateTag once this member has raft state and, while
+// this member leads, joins alive members whose join events were missed, e.g.
+// because no leader existed yet.
+func (m *Membership) reconcile() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.left:
+			return
+		case <-ticker.C:
+			m.advertiseState()
+			if !m.handler.IsLeader() {
+				continue
+			}
+			for _, member := range m.serf.Members() {
+				if member.Status != serf.StatusAlive || m.isLocal(member) {
+					continue
+				}
+				if err := m.handler.Join(member.Name, member.Tags[RPCAddrTag], member.Tags[RoleTag] != RoleNonvoter); err != nil {
+					m.logError(err, "failed to reconcile", member)
+				}
+			}
+		}
+	}
+}
+
+func (m *Membership) advertiseState() {
+	if m.serf.LocalMember().Tags[StateTag] != "" {
+		return
+	}
+	hasState, err := m.handler.HasState()
+	if err != nil {
+		m.logger.Error("failed to check raft state", zap.Error(err))
+		return
+	}
+	if !hasState {
+		return
+	}
+	tags := make(map[string]string, len(m.tags)+1)
+	for k, v := range m.tags {
+		tags[k] = v
+	}
+	tags[StateTag] = "true"
+	if err := m.serf.SetTags(tags); err != nil {
+		m.logger.Error("failed to set tags", zap.Error(err))
+	}
+}
+
 func (m *Membership) isLocal(member serf.Member) bool {
 	return m.serf.LocalMember().Name == member.Name
 }
@@ -94,8 +229,13 @@ func (m *Membership) Members() []serf.Member {
 	return m.serf.Members()
 }
 
+// Leave gracefully leaves the cluster and shuts serf down.
 func (m *Membership) Leave() error {
-	return m.serf.Leave()
+	close(m.left)
+	if err := m.serf.Leave(); err != nil {
+		return err
+	}
+	return m.serf.Shutdown()
 }
 
 func (m *Membership) logError(err error, msg string, member serf.Member) {
@@ -103,5 +243,5 @@ func (m *Membership) logError(err error, msg string, member serf.Member) {
 	if errors.Is(err, raft.ErrNotLeader) {
 		log = m.logger.Debug
 	}
-	log(msg, zap.Error(err), zap.String("name", member.Name), zap.String("rpc_addr", member.Tags["rpc_addr"]))
+	log(msg, zap.Error(err), zap.String("name", member.Name), zap.String("rpc_addr", member.Tags[RPCAddrTag]), zap.String("role", member.Tags[RoleTag]))
 }
@@ -2,6 +2,7 @@ package membership_test
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -34,13 +35,23 @@ func TestMembership(t *testing.T) {
 	}, 3*time.Second, 250*time.Millisecond)
 
 	require.Equal(t, fmt.Sprintf("%d", 2), <-handler.leaves)
+
+	voters := map[string]string{}
+	for i := 0; i < 2; i++ {
+		join := <-handler.joins
+		voters[join["id"]] = join["voter"]
+	}
+	require.Equal(t, map[string]string{"1": "true", "2": "false"}, voters)
 }
 
 func setupMembership(t *testing.T, port int, members []*Membership, joinPort int) ([]*Membership, *handler) {
 	t.Helper()
 	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
 	id := len(members)
-	tags := map[string]string{"rpc_addr": addr}
+	tags := map[string]string{RPCAddrTag: addr}
+	if id == 2 {
+		tags[RoleTag] = RoleNonvoter
+	}
 	args := Args{
 		NodeName: fmt.Sprintf("%d", id),
 		BindAddr: addr,
@@ -65,13 +76,18 @@ func setupMembership(t *testing.T, port int, members []*Membership, joinPort int
 type handler struct {
 	joins  chan map[string]string
 	leaves chan string
+
+	mu           sync.Mutex
+	hasState     bool
+	bootstrapped map[string]string
 }
 
-func (h *handler) Join(id, addr string) error {
+func (h *handler) Join(id, addr string, voter bool) error {
 	if h.joins != nil {
 		h.joins <- map[string]string{
-			"id":   id,
-			"addr": addr,
+			"id":    id,
+			"addr":  addr,
+			"voter": fmt.Sprintf("%t", voter),
 		}
 	}
 	return nil
@@ -83,3 +99,130 @@ func (h *handler) Leave(id string) error {
 	}
 	return nil
 }
+
+func (h *handler) Bootstrap(voters map[string]string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bootstrapped = voters
+	h.hasState = true
+	return nil
+}
+
+func (h *handler) HasState() (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hasState, nil
+}
+
+func (h *handler) IsLeader() bool {
+	return false
+}
+
+func (h *handler) bootstrappedWith() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.bootstrapped
+}
+
+func TestBootstrapExpect(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, ports []int){
+		"voters bootstrap once expected voters join": testBootstrapExpect,
+		"existing state is never bootstrapped again": testBootstrapExistingState,
+		"members without expect are left out":        testBootstrapWithoutExpect,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, dynaport.Get(4))
+		})
+	}
+}
+
+// setupBootstrap starts a member expecting 3 voters that joins ports[0].
+func setupBootstrap(t *testing.T, ports []int, id int, role string, h *handler) {
+	t.Helper()
+	setupBootstrapExpect(t, ports, id, role, 3, h)
+}
+
+// setupBootstrapExpect is setupBootstrap with the member expecting expect
+// voters.
+func setupBootstrapExpect(t *testing.T, ports []int, id int, role string, expect int, h *handler) {
+	t.Helper()
+	addr := fmt.Sprintf("127.0.0.1:%d", ports[id])
+	args := Args{
+		NodeName:        fmt.Sprintf("%d", id),
+		BindAddr:        addr,
+		RPCAddr:         addr,
+		Tags:            map[string]string{RPCAddrTag: addr, RoleTag: role},
+		BootstrapExpect: expect,
+	}
+	if id != 0 {
+		args.StartJoinAddrs = []string{fmt.Sprintf("127.0.0.1:%d", ports[0])}
+	}
+	_, err := NewMembership(h, args)
+	require.NoError(t, err)
+}
+
+func testBootstrapExpect(t *testing.T, ports []int) {
+	t.Helper()
+	handlers := make([]*handler, 4)
+	for i := range handlers {
+		handlers[i] = &handler{}
+	}
+	setupBootstrap(t, ports, 0, RoleVoter, handlers[0])
+	setupBootstrap(t, ports, 1, RoleNonvoter, handlers[1])
+	setupBootstrap(t, ports, 2, RoleVoter, handlers[2])
+	time.Sleep(500 * time.Millisecond)
+	// only two voters so far
+	require.Nil(t, handlers[0].bootstrappedWith())
+
+	setupBootstrap(t, ports, 3, RoleVoter, handlers[3])
+	want := map[string]string{
+		"0": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		"2": fmt.Sprintf("127.0.0.1:%d", ports[2]),
+		"3": fmt.Sprintf("127.0.0.1:%d", ports[3]),
+	}
+	for _, i := range []int{0, 2, 3} {
+		h := handlers[i]
+		require.Eventually(t, func() bool {
+			return len(h.bootstrappedWith()) == 3
+		}, 3*time.Second, 100*time.Millisecond)
+		require.Equal(t, want, h.bootstrappedWith())
+	}
+	require.Nil(t, handlers[1].bootstrappedWith())
+}
+
+func testBootstrapExistingState(t *testing.T, ports []int) {
+	t.Helper()
+	handlers := []*handler{{hasState: true}, {}, {}}
+	for i, h := range handlers {
+		setupBootstrap(t, ports, i, RoleVoter, h)
+	}
+	time.Sleep(time.Second)
+	for _, h := range handlers {
+		require.Nil(t, h.bootstrappedWith())
+	}
+}
+
+func testBootstrapWithoutExpect(t *testing.T, ports []int) {
+	t.Helper()
+	handlers := make([]*handler, 4)
+	for i := range handlers {
+		handlers[i] = &handler{}
+	}
+	setupBootstrap(t, ports, 0, RoleVoter, handlers[0])
+	setupBootstrapExpect(t, ports, 1, RoleVoter, 0, handlers[1])
+	setupBootstrap(t, ports, 2, RoleVoter, handlers[2])
+	setupBootstrap(t, ports, 3, RoleVoter, handlers[3])
+	want := map[string]string{
+		"0": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		"2": fmt.Sprintf("127.0.0.1:%d", ports[2]),
+		"3": fmt.Sprintf("127.0.0.1:%d", ports[3]),
+	}
+	for _, i := range []int{0, 2, 3} {
+		h := handlers[i]
+		require.Eventually(t, func() bool {
+			return len(h.bootstrappedWith()) == 3
+		}, 3*time.Second, 100*time.Millisecond)
+		require.Equal(t, want, h.bootstrappedWith())
+	}
+	require.Nil(t, handlers[1].bootstrappedWith())
+}
@@ -0,0 +1,253 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.2
+// source: v1/raft.proto
+
+package logv1
+
+import (
+	context "context"
+
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// RaftClient is the client API for Raft service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RaftClient interface {
+	AppendEntries(ctx context.Context, in *RaftRequest, opts ...grpc.CallOption) (*RaftResponse, error)
+	RequestVote(ctx context.Context, in *RaftRequest, opts ...grpc.CallOption) (*RaftResponse, error)
+	TimeoutNow(ctx context.Context, in *RaftRequest, opts ...grpc.CallOption) (*RaftResponse, error)
+	// InstallSnapshot streams the request in the first chunk followed by the
+	// snapshot's data.
+	InstallSnapshot(ctx context.Context, opts ...grpc.CallOption) (Raft_InstallSnapshotClient, error)
+}
+
+type raftClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRaftClient(cc grpc.ClientConnInterface) RaftClient {
+	return &raftClient{cc}
+}
+
+func (c *raftClient) AppendEntries(ctx context.Context, in *RaftRequest, opts ...grpc.CallOption) (*RaftResponse, error) {
+	out := new(RaftResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Raft/AppendEntries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) RequestVote(ctx context.Context, in *RaftRequest, opts ...grpc.CallOption) (*RaftResponse, error) {
+	out := new(RaftResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Raft/RequestVote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) TimeoutNow(ctx context.Context, in *RaftRequest, opts ...grpc.CallOption) (*RaftResponse, error) {
+	out := new(RaftResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Raft/TimeoutNow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) InstallSnapshot(ctx context.Context, opts ...grpc.CallOption) (Raft_InstallSnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Raft_ServiceDesc.Streams[0], "/log.v1.Raft/InstallSnapshot", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &raftInstallSnapshotClient{stream}
+	return x, nil
+}
+
+type Raft_InstallSnapshotClient interface {
+	Send(*InstallSnapshotChunk) error
+	CloseAndRecv() (*RaftResponse, error)
+	grpc.ClientStream
+}
+
+type raftInstallSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *raftInstallSnapshotClient) Send(m *InstallSnapshotChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *raftInstallSnapshotClient) CloseAndRecv() (*RaftResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(RaftResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RaftServer is the server API for Raft service.
+// All implementations must embed UnimplementedRaftServer
+// for forward compatibility
+type RaftServer interface {
+	AppendEntries(context.Context, *RaftRequest) (*RaftResponse, error)
+	RequestVote(context.Context, *RaftRequest) (*RaftResponse, error)
+	TimeoutNow(context.Context, *RaftRequest) (*RaftResponse, error)
+	// InstallSnapshot streams the request in the first chunk followed by the
+	// snapshot's data.
+	InstallSnapshot(Raft_InstallSnapshotServer) error
+	mustEmbedUnimplementedRaftServer()
+}
+
+// UnimplementedRaftServer must be embedded to have forward compatible implementations.
+type UnimplementedRaftServer struct {
+}
+
+func (UnimplementedRaftServer) AppendEntries(context.Context, *RaftRequest) (*RaftResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendEntries not implemented")
+}
+func (UnimplementedRaftServer) RequestVote(context.Context, *RaftRequest) (*RaftResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+func (UnimplementedRaftServer) TimeoutNow(context.Context, *RaftRequest) (*RaftResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TimeoutNow not implemented")
+}
+func (UnimplementedRaftServer) InstallSnapshot(Raft_InstallSnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method InstallSnapshot not implemented")
+}
+func (UnimplementedRaftServer) mustEmbedUnimplementedRaftServer() {}
+
+// UnsafeRaftServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RaftServer will
+// result in compilation errors.
+type UnsafeRaftServer interface {
+	mustEmbedUnimplementedRaftServer()
+}
+
+func RegisterRaftServer(s grpc.ServiceRegistrar, srv RaftServer) {
+	s.RegisterService(&Raft_ServiceDesc, srv)
+}
+
+func _Raft_AppendEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RaftRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).AppendEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Raft/AppendEntries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).AppendEntries(ctx, req.(*RaftRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RaftRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Raft/RequestVote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).RequestVote(ctx, req.(*RaftRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_TimeoutNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RaftRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).TimeoutNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Raft/TimeoutNow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).TimeoutNow(ctx, req.(*RaftRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_InstallSnapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RaftServer).InstallSnapshot(&raftInstallSnapshotServer{stream})
+}
+
+type Raft_InstallSnapshotServer interface {
+	SendAndClose(*RaftResponse) error
+	Recv() (*InstallSnapshotChunk, error)
+	grpc.ServerStream
+}
+
+type raftInstallSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *raftInstallSnapshotServer) SendAndClose(m *RaftResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *raftInstallSnapshotServer) Recv() (*InstallSnapshotChunk, error) {
+	m := new(InstallSnapshotChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Raft_ServiceDesc is the grpc.ServiceDesc for Raft service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Raft_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "log.v1.Raft",
+	HandlerType: (*RaftServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AppendEntries",
+			Handler:    _Raft_AppendEntries_Handler,
+		},
+		{
+			MethodName: "RequestVote",
+			Handler:    _Raft_RequestVote_Handler,
+		},
+		{
+			MethodName: "TimeoutNow",
+			Handler:    _Raft_TimeoutNow_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "InstallSnapshot",
+			Handler:       _Raft_InstallSnapshot_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "v1/raft.proto",
+}
@@ -1,9 +1,11 @@
 package raft
 
 import (
-	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 
 	"github.com/hashicorp/raft"
 	"google.golang.org/protobuf/proto"
@@ -14,31 +16,124 @@ import (
 
 const (
 	AppendRequestType RequestType = 0
+	// PolicyRequestType changes the acl policy, only PolicyPartition
+	// applies it.
+	PolicyRequestType RequestType = 1
+	// AppendBatchRequestType appends the records of a pb.ProduceBatch at
+	// consecutive offsets and responds with the first, or with a
+	// PartialAppendError when only some of them were appended.
+	AppendBatchRequestType RequestType = 2
 )
 
+var (
+	errNoPolicies = errors.New("partition doesn't replicate the acl policy")
+	errEmptyBatch = errors.New("produce batch has no records")
+)
+
+// PartialAppendError is the response to a pb.ProduceBatch the log failed to
+// append whole: its first Appended records were written at consecutive
+// offsets from Offset, the rest weren't.
+type PartialAppendError struct {
+	Offset   uint64
+	Appended int
+	Err      error
+}
+
+func (e *PartialAppendError) Error() string {
+	return fmt.Sprintf("appended %d records of the batch: %v", e.Appended, e.Err)
+}
+
+func (e *PartialAppendError) Unwrap() error {
+	return e.Err
+}
+
 type FSM struct {
-	Log *log.Log
+	Log       *log.Log
+	snapshots *SnapshotStore
+	// policies is nil on partitions other than PolicyPartition.
+	policies *PolicyStore
 }
 
 type RequestType uint8
 
-var _ raft.FSM = (*FSM)(nil)
+var _ raft.BatchingFSM = (*FSM)(nil)
 
-func NewFSM(l *log.Log) *FSM {
-	return &FSM{Log: l}
+func NewFSM(l *log.Log, snapshots *SnapshotStore, policies *PolicyStore) *FSM {
+	return &FSM{Log: l, snapshots: snapshots, policies: policies}
 }
 
 func (f *FSM) Apply(record *raft.Log) interface{} {
 	buf := record.Data
 	reqType := RequestType(buf[0])
-	//nolint:gocritic //reason: for productivity
 	switch reqType {
 	case AppendRequestType:
 		return f.applyAppend(buf[1:])
+	case AppendBatchRequestType:
+		return f.applyAppendBatch(buf[1:])
+	case PolicyRequestType:
+		return f.applyPolicy(buf[1:])
 	}
 	return nil
 }
 
+// ApplyBatch appends every record in the batch to the log under a single
+// lock instead of taking it once per raft log. When that append fails, the
+// commands it didn't reach are appended one by one. Policy changes are
+// applied one by one.
+func (f *FSM) ApplyBatch(logs []*raft.Log) []interface{} {
+	res := make([]interface{}, len(logs))
+	records := make([]*pb.Record, 0, len(logs))
+	// the records of logs[log] are records[first:end]
+	type span struct{ log, first, end int }
+	spans := make([]span, 0, len(logs))
+	for i, l := range logs {
+		if l.Type != raft.LogCommand {
+			continue
+		}
+		var appended []*pb.Record
+		switch RequestType(l.Data[0]) {
+		case PolicyRequestType:
+			res[i] = f.applyPolicy(l.Data[1:])
+			continue
+		case AppendRequestType:
+			var req pb.ProduceRequest
+			if err := proto.Unmarshal(l.Data[1:], &req); err != nil {
+				res[i] = err
+				continue
+			}
+			appended = []*pb.Record{req.Record}
+		case AppendBatchRequestType:
+			var batch pb.ProduceBatch
+			if err := proto.Unmarshal(l.Data[1:], &batch); err != nil {
+				res[i] = err
+				continue
+			}
+			appended = batch.Records
+		default:
+			continue
+		}
+		spans = append(spans, span{log: i, first: len(records), end: len(records) + len(appended)})
+		records = append(records, appended...)
+	}
+	offsets, err := f.Log.AppendBatch(records)
+	for _, s := range spans {
+		switch {
+		case s.first == s.end:
+			res[s.log] = errEmptyBatch
+		case s.end <= len(offsets):
+			res[s.log] = &pb.ProduceResponse{Offset: offsets[s.first]}
+		case s.first < len(offsets):
+			res[s.log] = &PartialAppendError{Offset: offsets[s.first], Appended: len(offsets) - s.first, Err: err}
+		default:
+			// the append stopped before the command, which raft counts as
+			// applied all the same, so it's attempted on its own like Apply
+			// would
+			res[s.log] = f.appendRecords(records[s.first:s.end])
+		}
+	}
+	return res
+}
+
 func (f *FSM) applyAppend(b []byte) interface{} {
 	var req pb.ProduceRequest
 	err := proto.Unmarshal(b, &req)
@@ -52,59 +147,136 @@ func (f *FSM) applyAppend(b []byte) interface{} {
 	return &pb.ProduceResponse{Offset: offset}
 }
 
+func (f *FSM) applyAppendBatch(b []byte) interface{} {
+	var batch pb.ProduceBatch
+	if err := proto.Unmarshal(b, &batch); err != nil {
+		return err
+	}
+	return f.appendRecords(batch.Records)
+}
+
+func (f *FSM) appendRecords(records []*pb.Record) interface{} {
+	offsets, err := f.Log.AppendBatch(records)
+	switch {
+	case len(offsets) == len(records) && len(records) > 0:
+		// a failed segment roll after the last record doesn't undo them
+		return &pb.ProduceResponse{Offset: offsets[0]}
+	case len(offsets) > 0:
+		return &PartialAppendError{Offset: offsets[0], Appended: len(offsets), Err: err}
+	case err != nil:
+		return err
+	}
+	return errEmptyBatch
+}
+
+// applyPolicy returns the error of a rejected change, nil otherwise.
+func (f *FSM) applyPolicy(b []byte) interface{} {
+	if f.policies == nil {
+		return errNoPolicies
+	}
+	var cmd pb.PolicyCommand
+	if err := proto.Unmarshal(b, &cmd); err != nil {
+		return err
+	}
+	if err := f.policies.apply(&cmd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Snapshot links the segment files into the snapshot store's staging area,
+// which is cheap enough to do on the FSM goroutine and keeps the files from
+// being truncated before they're persisted. The acl policy is small enough
+// to be copied.
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	r := f.Log.Reader()
-	return &snapshot{reader: r}, nil
+	var policies []byte
+	if f.policies != nil {
+		var err error
+		if policies, err = f.policies.marshal(); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := f.snapshots.stage()
+	if err != nil {
+		return nil, err
+	}
+	segments, err := f.Log.LinkSegments(dir)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return &snapshot{dir: dir, segments: segments, policies: policies}, nil
 }
 
+// Restore installs the snapshot's segment files as the log and its acl
+// policy. Files of a snapshot in the local store are hard-linked, others are
+// copied from the archive.
 func (f *FSM) Restore(r io.ReadCloser) error {
-	if err := f.Log.Reset(); err != nil {
+	header, err := readHeader(r)
+	if err != nil {
 		return err
 	}
-	b := make([]byte, log.LenWidth)
-	var buf bytes.Buffer
-	for i := 0; ; i++ {
-		_, err := io.ReadFull(r, b)
-		if errors.Is(err, io.EOF) {
-			break
-		} else if err != nil {
-			return err
-		}
-		size := int64(log.Enc.Uint64(b))
-		if _, err = io.CopyN(&buf, r, size); err != nil {
-			return err
-		}
-		record := &pb.Record{}
-		if err := proto.Unmarshal(buf.Bytes(), record); err != nil {
-			return err
-		}
-		if i == 0 {
-			f.Log.Config.InitialOffset = record.Offset
-			if err := f.Log.Reset(); err != nil {
+	dir, local := f.snapshots.local(header.ID)
+	var policies []byte
+	err = f.Log.Restore(func(logDir string) error {
+		for _, file := range header.Files {
+			if file.Name == policiesFile {
+				policies, err = readSnapshotFile(r, dir, local, file)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			dst := filepath.Join(logDir, file.Name)
+			if local {
+				err = installFile(filepath.Join(dir, file.Name), dst, file)
+			} else {
+				err = writeFile(r, dst, file)
+			}
+			if err != nil {
 				return err
 			}
 		}
-		if _, err = f.Log.Append(record); err != nil {
-			return err
-		}
-		buf.Reset()
+		return nil
+	})
+	if err != nil || f.policies == nil {
+		return err
 	}
-	return nil
+	return f.policies.restore(policies)
 }
 
 var _ raft.FSMSnapshot = (*snapshot)(nil)
 
 type snapshot struct {
-	reader io.Reader
+	dir      string
+	segments []log.SegmentSnapshot
+	// policies is the marshaled acl policy, nil on partitions that don't
+	// replicate it.
+	policies []byte
 }
 
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
-	if _, err := io.Copy(sink, s.reader); err != nil {
+	ss, ok := sink.(*snapshotSink)
+	if !ok {
+		//nolint:errcheck //reason: error already exists
+		_ = sink.Cancel()
+		return fmt.Errorf("unsupported snapshot sink %T", sink)
+	}
+	if err := ss.link(s.dir, s.segments); err != nil {
 		//nolint:errcheck //reason: error already exists
 		_ = sink.Cancel()
 		return err
 	}
+	if s.policies != nil {
+		if err := ss.add(policiesFile, s.policies); err != nil {
+			//nolint:errcheck //reason: error already exists
+			_ = sink.Cancel()
+			return err
+		}
+	}
 	return sink.Close()
 }
 
-func (s *snapshot) Release() {}
+func (s *snapshot) Release() {
+	_ = os.RemoveAll(s.dir)
+}
@@ -0,0 +1,101 @@
+package raft_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/travisjeffery/proglog/internal/log"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	. "github.com/travisjeffery/proglog/internal/raft"
+)
+
+func TestApplyProduceBatches(t *testing.T) {
+	n := setupSnapshotNode(t)
+	res := n.fsm.ApplyBatch([]*raft.Log{
+		command(t, AppendRequestType, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("a")}}),
+		command(t, AppendBatchRequestType, &pb.ProduceBatch{Records: []*pb.Record{
+			{Value: []byte("b")},
+			{Value: []byte("c")},
+		}}),
+		command(t, AppendBatchRequestType, &pb.ProduceBatch{}),
+		command(t, AppendRequestType, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("d")}}),
+	})
+	require.Len(t, res, 4)
+	require.Equal(t, uint64(0), res[0].(*pb.ProduceResponse).Offset)
+	require.Equal(t, uint64(1), res[1].(*pb.ProduceResponse).Offset)
+	require.Error(t, res[2].(error))
+	require.Equal(t, uint64(3), res[3].(*pb.ProduceResponse).Offset)
+
+	// a batch applied alone is answered with its first offset too
+	res[0] = n.fsm.Apply(command(t, AppendBatchRequestType, &pb.ProduceBatch{Records: []*pb.Record{
+		{Value: []byte("e")},
+		{Value: []byte("f")},
+	}}))
+	require.Equal(t, uint64(4), res[0].(*pb.ProduceResponse).Offset)
+
+	for offset, want := range []string{"a", "b", "c", "d", "e", "f"} {
+		record, err := n.log.Read(uint64(offset))
+		require.NoError(t, err)
+		require.Equal(t, want, string(record.Value))
+	}
+}
+
+func TestApplyBatchAfterFailedAppend(t *testing.T) {
+	n := setupSnapshotNode(t)
+	// the third record fills the first segment and rolling to the next one
+	// fails, which stops the batch's append
+	require.NoError(t, os.Mkdir(filepath.Join(n.dir, "log", log.StoreFile(3)), 0o755))
+	logs := make([]*raft.Log, 0, 5)
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		logs = append(logs, command(t, AppendRequestType, &pb.ProduceRequest{Record: &pb.Record{Value: []byte(value)}}))
+	}
+	res := n.fsm.ApplyBatch(logs)
+
+	// the commands the append didn't reach are still appended
+	for i := range logs {
+		require.Equal(t, uint64(i), res[i].(*pb.ProduceResponse).Offset)
+	}
+	for offset, want := range []string{"a", "b", "c", "d", "e"} {
+		record, err := n.log.Read(uint64(offset))
+		require.NoError(t, err)
+		require.Equal(t, want, string(record.Value))
+	}
+}
+
+func TestApplyPartlyAppendedBatch(t *testing.T) {
+	n := setupSnapshotNode(t)
+	// the third record fills the first segment and rolling to the next one
+	// fails, so the batch's last two records aren't appended
+	require.NoError(t, os.Mkdir(filepath.Join(n.dir, "log", log.StoreFile(3)), 0o755))
+	batch := &pb.ProduceBatch{}
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		batch.Records = append(batch.Records, &pb.Record{Value: []byte(value)})
+	}
+	res := n.fsm.ApplyBatch([]*raft.Log{
+		command(t, AppendBatchRequestType, batch),
+		command(t, AppendRequestType, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("f")}}),
+	})
+
+	var partial *PartialAppendError
+	require.ErrorAs(t, res[0].(error), &partial)
+	require.Equal(t, uint64(0), partial.Offset)
+	require.Equal(t, 3, partial.Appended)
+	require.Equal(t, uint64(3), res[1].(*pb.ProduceResponse).Offset)
+	for offset, want := range []string{"a", "b", "c", "f"} {
+		record, err := n.log.Read(uint64(offset))
+		require.NoError(t, err)
+		require.Equal(t, want, string(record.Value))
+	}
+}
+
+func command(t *testing.T, reqType RequestType, msg proto.Message) *raft.Log {
+	t.Helper()
+	b, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return &raft.Log{Type: raft.LogCommand, Data: append([]byte{byte(reqType)}, b...)}
+}
@@ -0,0 +1,376 @@
+package raft
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	innertls "github.com/travisjeffery/proglog/internal/tls"
+)
+
+const (
+	snapshotChunkSize = 64 << 10
+	keepaliveTime     = 10 * time.Second
+)
+
+// GRPCTransports carry raft's RPCs over the gRPC server as the Raft service,
+// so raft traffic shares the client traffic's listener, auth, interceptors
+// and keepalives. Connections to each peer are shared by every partition.
+type GRPCTransports struct {
+	localAddr raft.ServerAddress
+	dialOpts  []grpc.DialOption
+
+	mu         sync.Mutex
+	conns      map[raft.ServerAddress]*grpc.ClientConn
+	transports map[uint32]*grpcTransport
+	pb.UnimplementedRaftServer
+}
+
+var _ pb.RaftServer = (*GRPCTransports)(nil)
+
+// NewGRPCTransports dials peers with peerTLSConfig, or without TLS when it's
+// nil.
+func NewGRPCTransports(localAddr string, peerTLSConfig *tls.Config) *GRPCTransports {
+	creds := grpc.WithInsecure()
+	if peerTLSConfig != nil {
+		creds = grpc.WithTransportCredentials(innertls.NewClientCredentials(peerTLSConfig))
+	}
+	return &GRPCTransports{
+		localAddr: raft.ServerAddress(localAddr),
+		dialOpts: []grpc.DialOption{
+			creds,
+			grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: keepaliveTime}),
+		},
+		conns:      make(map[raft.ServerAddress]*grpc.ClientConn),
+		transports: make(map[uint32]*grpcTransport),
+	}
+}
+
+func (t *GRPCTransports) Transport(partition uint32, args Args) raft.Transport {
+	_, timeout := transportSettings(args)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	transport := &grpcTransport{
+		partition: partition,
+		parent:    t,
+		timeout:   timeout,
+		consumer:  make(chan raft.RPC),
+		shutdown:  make(chan struct{}),
+	}
+	t.transports[partition] = transport
+	return transport
+}
+
+func (t *GRPCTransports) client(target raft.ServerAddress) (pb.RaftClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conn, ok := t.conns[target]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(string(target), t.dialOpts...)
+		if err != nil {
+			return nil, err
+		}
+		t.conns[target] = conn
+	}
+	return pb.NewRaftClient(conn), nil
+}
+
+func (t *GRPCTransports) transport(partition uint32) (*grpcTransport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	transport, ok := t.transports[partition]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPartition, partition)
+	}
+	return transport, nil
+}
+
+// Close closes the connections to the peers.
+func (t *GRPCTransports) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	for addr, conn := range t.conns {
+		if cerr := conn.Close(); err == nil {
+			err = cerr
+		}
+		delete(t.conns, addr)
+	}
+	return err
+}
+
+func (t *GRPCTransports) AppendEntries(ctx context.Context, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+	var command raft.AppendEntriesRequest
+	return t.handle(ctx, req, &command)
+}
+
+func (t *GRPCTransports) RequestVote(ctx context.Context, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+	var command raft.RequestVoteRequest
+	return t.handle(ctx, req, &command)
+}
+
+func (t *GRPCTransports) TimeoutNow(ctx context.Context, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+	var command raft.TimeoutNowRequest
+	return t.handle(ctx, req, &command)
+}
+
+func (t *GRPCTransports) handle(ctx context.Context, req *pb.RaftRequest, command interface{}) (*pb.RaftResponse, error) {
+	transport, err := t.transport(req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(req.Payload, command); err != nil {
+		return nil, err
+	}
+	res, err := transport.dispatch(ctx, command, nil)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encode(res)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RaftResponse{Payload: payload}, nil
+}
+
+func (t *GRPCTransports) InstallSnapshot(stream pb.Raft_InstallSnapshotServer) error {
+	chunk, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	transport, err := t.transport(chunk.Partition)
+	if err != nil {
+		return err
+	}
+	var command raft.InstallSnapshotRequest
+	if err := decode(chunk.Request, &command); err != nil {
+		return err
+	}
+	res, err := transport.dispatch(stream.Context(), &command, &chunkReader{stream: stream, buf: chunk.Data})
+	if err != nil {
+		return err
+	}
+	payload, err := encode(res)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&pb.RaftResponse{Payload: payload})
+}
+
+// chunkReader reads the snapshot data streamed after the request.
+type chunkReader struct {
+	stream pb.Raft_InstallSnapshotServer
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// grpcTransport is the raft.Transport of a partition.
+type grpcTransport struct {
+	partition uint32
+	parent    *GRPCTransports
+	timeout   time.Duration
+	consumer  chan raft.RPC
+
+	heartbeatFnLock sync.Mutex
+	heartbeatFn     func(raft.RPC)
+
+	shutdownOnce sync.Once
+	shutdown     chan struct{}
+}
+
+var (
+	_ raft.Transport = (*grpcTransport)(nil)
+	_ raft.WithClose = (*grpcTransport)(nil)
+)
+
+func (t *grpcTransport) Consumer() <-chan raft.RPC {
+	return t.consumer
+}
+
+func (t *grpcTransport) LocalAddr() raft.ServerAddress {
+	return t.parent.localAddr
+}
+
+// AppendEntriesPipeline isn't supported, raft falls back to AppendEntries.
+func (t *grpcTransport) AppendEntriesPipeline(_ raft.ServerID, _ raft.ServerAddress) (raft.AppendPipeline, error) {
+	return nil, raft.ErrPipelineReplicationNotSupported
+}
+
+func (t *grpcTransport) AppendEntries(_ raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	return t.call(target, args, resp, func(ctx context.Context, client pb.RaftClient, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+		return client.AppendEntries(ctx, req)
+	})
+}
+
+func (t *grpcTransport) RequestVote(_ raft.ServerID, target raft.ServerAddress, args *raft.RequestVoteRequest, resp *raft.RequestVoteResponse) error {
+	return t.call(target, args, resp, func(ctx context.Context, client pb.RaftClient, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+		return client.RequestVote(ctx, req)
+	})
+}
+
+func (t *grpcTransport) TimeoutNow(_ raft.ServerID, target raft.ServerAddress, args *raft.TimeoutNowRequest, resp *raft.TimeoutNowResponse) error {
+	return t.call(target, args, resp, func(ctx context.Context, client pb.RaftClient, req *pb.RaftRequest) (*pb.RaftResponse, error) {
+		return client.TimeoutNow(ctx, req)
+	})
+}
+
+type raftCall func(ctx context.Context, client pb.RaftClient, req *pb.RaftRequest) (*pb.RaftResponse, error)
+
+func (t *grpcTransport) call(target raft.ServerAddress, args, resp interface{}, fn raftCall) error {
+	client, err := t.parent.client(target)
+	if err != nil {
+		return err
+	}
+	payload, err := encode(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	res, err := fn(ctx, client, &pb.RaftRequest{Partition: t.partition, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return decode(res.Payload, resp)
+}
+
+func (t *grpcTransport) InstallSnapshot(_ raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	client, err := t.parent.client(target)
+	if err != nil {
+		return err
+	}
+	request, err := encode(args)
+	if err != nil {
+		return err
+	}
+	// like raft's network transport, allow larger snapshots more time
+	timeout := t.timeout * time.Duration(args.Size/int64(raft.DefaultTimeoutScale))
+	if timeout < t.timeout {
+		timeout = t.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stream, err := client.InstallSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	chunk := &pb.InstallSnapshotChunk{Partition: t.partition, Request: request}
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := data.Read(buf)
+		if n > 0 || chunk.Request != nil {
+			chunk.Data = buf[:n]
+			if serr := stream.Send(chunk); serr != nil {
+				return serr
+			}
+			chunk = &pb.InstallSnapshotChunk{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	return decode(res.Payload, resp)
+}
+
+func (t *grpcTransport) EncodePeer(_ raft.ServerID, addr raft.ServerAddress) []byte {
+	return []byte(addr)
+}
+
+func (t *grpcTransport) DecodePeer(buf []byte) raft.ServerAddress {
+	return raft.ServerAddress(buf)
+}
+
+func (t *grpcTransport) SetHeartbeatHandler(cb func(rpc raft.RPC)) {
+	t.heartbeatFnLock.Lock()
+	defer t.heartbeatFnLock.Unlock()
+	t.heartbeatFn = cb
+}
+
+func (t *grpcTransport) Close() error {
+	t.shutdownOnce.Do(func() {
+		close(t.shutdown)
+	})
+	return nil
+}
+
+// dispatch hands the command to raft and waits for its response, taking
+// raft's heartbeat fast path the way its network transport does.
+func (t *grpcTransport) dispatch(ctx context.Context, command interface{}, reader io.Reader) (interface{}, error) {
+	respCh := make(chan raft.RPCResponse, 1)
+	rpc := raft.RPC{Command: command, Reader: reader, RespChan: respCh}
+
+	var fn func(raft.RPC)
+	if req, ok := command.(*raft.AppendEntriesRequest); ok && isHeartbeat(req) {
+		t.heartbeatFnLock.Lock()
+		fn = t.heartbeatFn
+		t.heartbeatFnLock.Unlock()
+	}
+	if fn != nil {
+		fn(rpc)
+	} else {
+		select {
+		case t.consumer <- rpc:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.shutdown:
+			return nil, raft.ErrTransportShutdown
+		}
+	}
+	select {
+	case resp := <-respCh:
+		return resp.Response, resp.Error
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.shutdown:
+		return nil, raft.ErrTransportShutdown
+	}
+}
+
+func isHeartbeat(req *raft.AppendEntriesRequest) bool {
+	leaderAddr := req.RPCHeader.Addr
+	if len(leaderAddr) == 0 {
+		leaderAddr = req.Leader //nolint:staticcheck //reason: older peers only set Leader
+	}
+	return req.Term != 0 && leaderAddr != nil &&
+		req.PrevLogEntry == 0 && req.PrevLogTerm == 0 &&
+		len(req.Entries) == 0 && req.LeaderCommitIndex == 0
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf []byte
+	err := codec.NewEncoderBytes(&buf, &codec.MsgpackHandle{}).Encode(v)
+	return buf, err
+}
+
+func decode(buf []byte, v interface{}) error {
+	return codec.NewDecoderBytes(buf, &codec.MsgpackHandle{}).Decode(v)
+}
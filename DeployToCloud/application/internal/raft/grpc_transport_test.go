@@ -0,0 +1,96 @@
+package raft_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	. "github.com/travisjeffery/proglog/internal/raft"
+)
+
+func TestGRPCTransport(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, local, remote raft.Transport, target raft.ServerAddress){
+		"append entries reaches the partition": testGRPCAppendEntries,
+		"install snapshot streams the data":    testGRPCInstallSnapshot,
+		"unknown partition fails the rpc":      testGRPCUnknownPartition,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			remotes := NewGRPCTransports(ln.Addr().String(), nil)
+			server := grpc.NewServer()
+			pb.RegisterRaftServer(server, remotes)
+			go func() {
+				_ = server.Serve(ln)
+			}()
+			locals := NewGRPCTransports("127.0.0.1:0", nil)
+			defer func() {
+				_ = locals.Close()
+				server.Stop()
+			}()
+
+			local := locals.Transport(1, Args{})
+			remote := remotes.Transport(1, Args{})
+			defer func() {
+				_ = remote.(raft.WithClose).Close()
+			}()
+			fn(t, local, remote, raft.ServerAddress(ln.Addr().String()))
+		})
+	}
+}
+
+func testGRPCAppendEntries(t *testing.T, local, remote raft.Transport, target raft.ServerAddress) {
+	go func() {
+		rpc := <-remote.Consumer()
+		req := rpc.Command.(*raft.AppendEntriesRequest)
+		rpc.Respond(&raft.AppendEntriesResponse{Term: req.Term, LastLog: req.Entries[0].Index, Success: true}, nil)
+	}()
+
+	var resp raft.AppendEntriesResponse
+	err := local.AppendEntries("remote", target, &raft.AppendEntriesRequest{
+		RPCHeader:    raft.RPCHeader{Addr: []byte("local")},
+		Term:         2,
+		PrevLogEntry: 4,
+		Entries:      []*raft.Log{{Index: 5, Term: 2, Data: []byte("foo")}},
+	}, &resp)
+	require.NoError(t, err)
+	require.Equal(t, raft.AppendEntriesResponse{Term: 2, LastLog: 5, Success: true}, resp)
+}
+
+func testGRPCInstallSnapshot(t *testing.T, local, remote raft.Transport, target raft.ServerAddress) {
+	// spans several chunks
+	data := bytes.Repeat([]byte("snapshot"), 32<<10)
+	received := make(chan []byte, 1)
+	go func() {
+		rpc := <-remote.Consumer()
+		buf, err := io.ReadAll(rpc.Reader)
+		received <- buf
+		rpc.Respond(&raft.InstallSnapshotResponse{Term: 2, Success: true}, err)
+	}()
+
+	var resp raft.InstallSnapshotResponse
+	err := local.InstallSnapshot("remote", target, &raft.InstallSnapshotRequest{
+		Term:         2,
+		LastLogIndex: 5,
+		Size:         int64(len(data)),
+	}, &resp, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, resp.Success)
+	require.Equal(t, data, <-received)
+}
+
+func testGRPCUnknownPartition(t *testing.T, local, remote raft.Transport, target raft.ServerAddress) {
+	locals := NewGRPCTransports("127.0.0.1:0", nil)
+	defer func() {
+		_ = locals.Close()
+	}()
+	var resp raft.RequestVoteResponse
+	err := locals.Transport(2, Args{}).RequestVote("remote", target, &raft.RequestVoteRequest{Term: 1}, &resp)
+	require.Error(t, err)
+}
@@ -1,6 +1,8 @@
 package raft
 
 import (
+	"sync"
+
 	"github.com/hashicorp/raft"
 
 	"github.com/travisjeffery/proglog/internal/log"
@@ -9,6 +11,9 @@ import (
 
 type LogStore struct {
 	*log.Log
+
+	mu      sync.Mutex
+	waiters map[string]chan struct{}
 }
 
 var _ raft.LogStore = (*LogStore)(nil)
@@ -18,16 +23,25 @@ func NewLogStore(cfg log.Config) (*LogStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &LogStore{l}, nil
+	return &LogStore{Log: l, waiters: make(map[string]chan struct{})}, nil
 }
 
 func (l *LogStore) FirstIndex() (uint64, error) {
 	return l.LowestOffset()
 }
 
+// LastIndex returns 0 when the log is empty, e.g. after raft deleted every
+// log up to a snapshot, as raft expects.
 func (l *LogStore) LastIndex() (uint64, error) {
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		return 0, err
+	}
 	off, err := l.HighestOffset()
-	return off, err
+	if err != nil || off < lowest {
+		return 0, err
+	}
+	return off, nil
 }
 
 func (l *LogStore) GetLog(index uint64, out *raft.Log) error {
@@ -55,10 +69,36 @@ func (l *LogStore) StoreLogs(records []*raft.Log) error {
 		}); err != nil {
 			return err
 		}
+		if len(record.Extensions) > 0 {
+			l.notifyStored(record.Extensions)
+		}
 	}
 	return nil
 }
 
+// NotifyStored returns a channel that is closed once a log carrying ext in
+// its extensions has been stored, and a func to stop waiting for it.
+func (l *LogStore) NotifyStored(ext []byte) (stored <-chan struct{}, cancel func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan struct{})
+	l.waiters[string(ext)] = ch
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.waiters, string(ext))
+	}
+}
+
+func (l *LogStore) notifyStored(ext []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ch, ok := l.waiters[string(ext)]; ok {
+		close(ch)
+		delete(l.waiters, string(ext))
+	}
+}
+
 func (l *LogStore) DeleteRange(min, max uint64) error {
 	return l.Truncate(max)
 }
@@ -0,0 +1,254 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/hashicorp/raft"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/log"
+)
+
+const partitionsDir = "partitions"
+
+var (
+	ErrUnknownPartition = errors.New("unknown partition")
+	// ErrNotReplica is returned for partitions whose raft group the node
+	// isn't a member of, and whose log it never receives.
+	ErrNotReplica = errors.New("not a replica of the partition")
+)
+
+// Partitions are the raft groups of the log's partitions. Each partition
+// has its own data log, raft log, stable store and snapshots under
+// DataDir/partitions/<id>, and they all share the Transports.
+type Partitions struct {
+	rafts      []*Raft
+	policies   *PolicyStore
+	transports Transports
+	args       Args
+	logger     *zap.Logger
+}
+
+func NewPartitions(logConfig log.Config, transports Transports, args Args) (*Partitions, error) {
+	if err := migrateDataDir(args.DataDir); err != nil {
+		return nil, err
+	}
+	p := &Partitions{
+		policies:   NewPolicyStore(),
+		transports: transports,
+		args:       args,
+		logger:     zap.L().Named("partitions"),
+	}
+	for id := 0; id < partitionCount(args); id++ {
+		l, logStore, partitionArgs, err := openPartition(logConfig, args, uint32(id))
+		if err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		transport := transports.Transport(uint32(id), partitionArgs)
+		r, err := NewRaft(l, logStore, transport, p.policyStore(uint32(id)), partitionArgs)
+		if err != nil {
+			if closer, ok := transport.(raft.WithClose); ok {
+				_ = closer.Close()
+			}
+			_ = logStore.Close()
+			_ = l.Close()
+			_ = p.Close()
+			return nil, err
+		}
+		p.rafts = append(p.rafts, r)
+	}
+	if s, ok := transports.(*StreamLayer); ok {
+		s.watch(p)
+	}
+	return p, nil
+}
+
+// policyStore returns the acl policy the partition replicates, if any.
+func (p *Partitions) policyStore(id uint32) *PolicyStore {
+	if id != PolicyPartition {
+		return nil
+	}
+	return p.policies
+}
+
+// Policies returns the acl policy replicated through PolicyPartition.
+func (p *Partitions) Policies() *PolicyStore {
+	return p.policies
+}
+
+func partitionCount(args Args) int {
+	if args.Partitions == 0 {
+		return 1
+	}
+	return args.Partitions
+}
+
+// openPartition opens the data log and raft log of the partition and returns
+// args pointing raft at the partition's directory.
+func openPartition(logConfig log.Config, args Args, id uint32) (*log.Log, *LogStore, Args, error) {
+	dir := filepath.Join(args.DataDir, partitionsDir, strconv.FormatUint(uint64(id), 10))
+	logConfig.DataDir = filepath.Join(dir, "log")
+	if err := os.MkdirAll(logConfig.DataDir, 0o755); err != nil {
+		return nil, nil, Args{}, err
+	}
+	l, err := log.NewLog(logConfig)
+	if err != nil {
+		return nil, nil, Args{}, err
+	}
+	raftLogConfig := logConfig
+	raftLogConfig.InitialOffset = 1
+	raftLogConfig.DataDir = filepath.Join(dir, "raft", "log")
+	if err := os.MkdirAll(raftLogConfig.DataDir, 0o755); err != nil {
+		_ = l.Close()
+		return nil, nil, Args{}, err
+	}
+	logStore, err := NewLogStore(raftLogConfig)
+	if err != nil {
+		_ = l.Close()
+		return nil, nil, Args{}, err
+	}
+	args.DataDir = dir
+	return l, logStore, args, nil
+}
+
+// migrateDataDir moves the data of a node from before partitioning into
+// partition 0.
+func migrateDataDir(dataDir string) error {
+	if _, err := os.Stat(filepath.Join(dataDir, partitionsDir)); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "raft")); err != nil {
+		return nil
+	}
+	dir := filepath.Join(dataDir, partitionsDir, "0")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, name := range []string{"log", "raft"} {
+		if err := os.Rename(filepath.Join(dataDir, name), filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Partitions) Count() int {
+	return len(p.rafts)
+}
+
+func (p *Partitions) Get(id uint32) (*Raft, error) {
+	if int(id) >= len(p.rafts) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPartition, id)
+	}
+	return p.rafts[id], nil
+}
+
+// All returns every partition's raft group, indexed by partition id.
+func (p *Partitions) All() []*Raft {
+	return p.rafts
+}
+
+// HasExistingState reports whether any partition has been part of a raft
+// cluster.
+func (p *Partitions) HasExistingState() (bool, error) {
+	for _, r := range p.rafts {
+		hasState, err := r.HasExistingState()
+		if err != nil || hasState {
+			return hasState, err
+		}
+	}
+	return false, nil
+}
+
+// ReplicationFactor is the number of voters replicating each partition, zero
+// when every voter replicates every partition.
+func (p *Partitions) ReplicationFactor() int {
+	return p.args.ReplicationFactor
+}
+
+// Bootstrap bootstraps the partitions this node replicates and waits for
+// their leaders. Every voter may bootstrap with the same servers. Partition i
+// is replicated by ReplicationFactor voters starting at servers[i % n] and
+// its leader hands leadership to servers[i % n], spreading replicas and
+// leaders over the voters. The other servers are nonvoters of
+// PolicyPartition, so that every node applies the acl policy.
+func (p *Partitions) Bootstrap(servers []raft.Server) error {
+	var bootstrapped []int
+	for id, r := range p.rafts {
+		replicas := p.placement(id, servers)
+		if !containsServer(replicas, raft.ServerID(p.args.NodeName)) {
+			continue
+		}
+		if err := r.BootstrapCluster(raft.Configuration{Servers: replicas}).Error(); err != nil {
+			return err
+		}
+		bootstrapped = append(bootstrapped, id)
+	}
+	for _, id := range bootstrapped {
+		r := p.rafts[id]
+		if err := r.waitForLeader(r.args.BootstrapTimeout); err != nil {
+			return err
+		}
+	}
+	for _, id := range bootstrapped {
+		r := p.rafts[id]
+		target := servers[id%len(servers)]
+		if r.State() != raft.Leader || target.ID == raft.ServerID(r.args.NodeName) {
+			continue
+		}
+		if err := r.LeadershipTransferToServer(target.ID, target.Address).Error(); err != nil {
+			p.logger.Warn("failed to spread partition leadership",
+				zap.Int("partition", id), zap.String("to", string(target.ID)), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// placement returns the servers partition id is bootstrapped with.
+func (p *Partitions) placement(id int, servers []raft.Server) []raft.Server {
+	rf := p.args.ReplicationFactor
+	if rf == 0 || rf >= len(servers) {
+		return servers
+	}
+	replicas := make([]raft.Server, 0, len(servers))
+	for i := 0; i < rf; i++ {
+		replicas = append(replicas, servers[(id+i)%len(servers)])
+	}
+	if uint32(id) != PolicyPartition {
+		return replicas
+	}
+	for i := rf; i < len(servers); i++ {
+		nonvoter := servers[(id+i)%len(servers)]
+		nonvoter.Suffrage = raft.Nonvoter
+		replicas = append(replicas, nonvoter)
+	}
+	return replicas
+}
+
+func containsServer(servers []raft.Server, id raft.ServerID) bool {
+	for _, server := range servers {
+		if server.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes every partition and then the transports they share.
+func (p *Partitions) Close() error {
+	var err error
+	for _, r := range p.rafts {
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := p.transports.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
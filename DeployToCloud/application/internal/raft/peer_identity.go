@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"path"
+
+	"github.com/hashicorp/raft"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+const (
+	RejectHandshake     = "handshake"
+	RejectNoCertificate = "no_certificate"
+	RejectIdentity      = "identity"
+)
+
+var (
+	KeyReason = tag.MustNewKey("proglog_reason")
+
+	RejectedConnections = stats.Int64("proglog/raft/rejected_connections", "Raft connections rejected for their peer", stats.UnitDimensionless)
+
+	RejectedConnectionsView = &view.View{
+		Name:        "proglog/raft/rejected_connections",
+		Description: "Count of rejected raft connections, by reason",
+		Measure:     RejectedConnections,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyReason},
+	}
+
+	DefaultStreamLayerViews = []*view.View{
+		RejectedConnectionsView,
+	}
+)
+
+// peerIdentity decides whether a peer certificate belongs to a node: its
+// common name or one of its SANs must match the pattern, or the id or host
+// of a server in the partition's raft configuration.
+type peerIdentity struct {
+	pattern string
+}
+
+func newPeerIdentity(pattern string) (*peerIdentity, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid raft peer identity %q: %w", pattern, err)
+	}
+	return &peerIdentity{pattern: pattern}, nil
+}
+
+func (p *peerIdentity) verify(cert *x509.Certificate, servers []raft.Server) bool {
+	known := map[string]bool{}
+	for _, server := range servers {
+		known[string(server.ID)] = true
+		if host, _, err := net.SplitHostPort(string(server.Address)); err == nil {
+			known[host] = true
+		}
+	}
+	for _, identity := range identities(cert) {
+		if known[identity] {
+			return true
+		}
+		if p.pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(p.pattern, identity); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// identities returns the common name and SANs of the certificate.
+func identities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		ids = append(ids, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, uri.String())
+	}
+	return append(ids, cert.EmailAddresses...)
+}
+
+// peerCertificate completes the handshake of the connection and returns the
+// certificate the peer presented, or the reason to reject it.
+func peerCertificate(conn *tls.Conn) (*x509.Certificate, string, error) {
+	if err := conn.Handshake(); err != nil {
+		return nil, RejectHandshake, err
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, RejectNoCertificate, nil
+	}
+	return certs[0], "", nil
+}
+
+func recordRejected(reason string) {
+	//nolint:errcheck //reason: only fails for invalid tags
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeyReason, reason)}, RejectedConnections.M(1))
+}
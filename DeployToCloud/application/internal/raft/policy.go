@@ -0,0 +1,123 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+)
+
+// PolicyPartition is the partition whose raft log replicates the acl policy.
+const PolicyPartition uint32 = 0
+
+const policiesFile = "policies.pb"
+
+var (
+	ErrUnknownPolicy = errors.New("unknown policy rule")
+	ErrLastPolicy    = errors.New("can't revoke the last allow rule")
+)
+
+// PolicyStore is the acl policy replicated through PolicyPartition. It isn't
+// seeded until the first change, which starts from the seed in its command.
+// Every node is a voter or a nonvoter of PolicyPartition, so that they all
+// apply it.
+type PolicyStore struct {
+	mu       sync.RWMutex
+	seeded   bool
+	policies []*pb.Policy
+	version  uint64
+}
+
+func NewPolicyStore() *PolicyStore {
+	return &PolicyStore{}
+}
+
+// Version changes whenever the policies do.
+func (s *PolicyStore) Version() uint64 {
+	return atomic.LoadUint64(&s.version)
+}
+
+// Policies returns the replicated rules and their version. seeded is false
+// until the first change was applied.
+func (s *PolicyStore) Policies() (policies []*pb.Policy, version uint64, seeded bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policies, s.Version(), s.seeded
+}
+
+// apply grants or revokes the command's rule. The rules are never changed in
+// place, so the slices Policies returned stay valid.
+func (s *PolicyStore) apply(cmd *pb.PolicyCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policies := s.policies
+	if !s.seeded {
+		policies = cmd.Seed
+	}
+	i := indexPolicy(policies, cmd.Policy)
+	switch {
+	case !cmd.Revoke && i < 0:
+		policies = append(policies[:len(policies):len(policies)], cmd.Policy)
+	case cmd.Revoke && i < 0:
+		return fmt.Errorf("%w: %s", ErrUnknownPolicy, formatPolicy(cmd.Policy))
+	case cmd.Revoke:
+		if cmd.Policy.Ptype == "p" && countPolicies(policies, "p") == 1 {
+			return ErrLastPolicy
+		}
+		policies = append(policies[:i:i], policies[i+1:]...)
+	}
+	s.set(true, policies)
+	return nil
+}
+
+func (s *PolicyStore) set(seeded bool, policies []*pb.Policy) {
+	s.seeded, s.policies = seeded, policies
+	atomic.AddUint64(&s.version, 1)
+}
+
+func (s *PolicyStore) marshal() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return proto.Marshal(&pb.PolicySet{Seeded: s.seeded, Policies: s.policies})
+}
+
+// restore replaces the policies with those of a snapshot, b is nil when the
+// snapshot has none.
+func (s *PolicyStore) restore(b []byte) error {
+	var set pb.PolicySet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(set.Seeded, set.Policies)
+	return nil
+}
+
+func indexPolicy(policies []*pb.Policy, policy *pb.Policy) int {
+	for i, p := range policies {
+		if proto.Equal(p, policy) {
+			return i
+		}
+	}
+	return -1
+}
+
+func countPolicies(policies []*pb.Policy, ptype string) int {
+	n := 0
+	for _, p := range policies {
+		if p.Ptype == ptype {
+			n++
+		}
+	}
+	return n
+}
+
+func formatPolicy(policy *pb.Policy) string {
+	return strings.Join(append([]string{policy.GetPtype()}, policy.GetFields()...), ", ")
+}
@@ -0,0 +1,70 @@
+package raft_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	. "github.com/travisjeffery/proglog/internal/raft"
+)
+
+func TestPolicyCommands(t *testing.T) {
+	root := &pb.Policy{Ptype: "p", Fields: []string{"root", "*", "produce"}}
+	alice := &pb.Policy{Ptype: "p", Fields: []string{"alice", "orders", "consume"}}
+	bob := &pb.Policy{Ptype: "g", Fields: []string{"bob", "writers"}}
+	seed := []*pb.Policy{root}
+
+	for scenario, tc := range map[string]struct {
+		cmds []*pb.PolicyCommand
+		err  error
+		want []*pb.Policy
+	}{
+		"first grant seeds the policy": {
+			cmds: []*pb.PolicyCommand{{Policy: alice, Seed: seed}},
+			want: []*pb.Policy{root, alice},
+		},
+		"seed of later changes is ignored": {
+			cmds: []*pb.PolicyCommand{{Policy: alice, Seed: seed}, {Policy: bob, Seed: []*pb.Policy{alice}}},
+			want: []*pb.Policy{root, alice, bob},
+		},
+		"granting a rule twice keeps one": {
+			cmds: []*pb.PolicyCommand{{Policy: root, Seed: seed}},
+			want: []*pb.Policy{root},
+		},
+		"revoke removes the rule": {
+			cmds: []*pb.PolicyCommand{{Policy: alice, Seed: seed}, {Policy: root, Revoke: true}},
+			want: []*pb.Policy{alice},
+		},
+		"revoking an unknown rule fails": {
+			cmds: []*pb.PolicyCommand{{Policy: alice, Revoke: true, Seed: seed}},
+			err:  ErrUnknownPolicy,
+		},
+		"revoking the last allow rule fails": {
+			cmds: []*pb.PolicyCommand{{Policy: root, Revoke: true, Seed: seed}},
+			err:  ErrLastPolicy,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			policies := NewPolicyStore()
+			fsm := NewFSM(nil, nil, policies)
+			var res interface{}
+			for _, cmd := range tc.cmds {
+				res = applyPolicy(t, fsm, cmd)
+			}
+			got, _, seeded := policies.Policies()
+			if tc.err != nil {
+				require.ErrorIs(t, res.(error), tc.err)
+				require.False(t, seeded)
+				return
+			}
+			require.Nil(t, res)
+			require.True(t, seeded)
+			require.Len(t, got, len(tc.want))
+			for i := range tc.want {
+				require.Equal(t, tc.want[i].Ptype, got[i].Ptype)
+				require.Equal(t, tc.want[i].Fields, got[i].Fields)
+			}
+		})
+	}
+}
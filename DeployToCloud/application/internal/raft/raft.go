@@ -2,56 +2,149 @@ package raft
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 
 	"github.com/travisjeffery/proglog/internal/log"
 )
 
+const (
+	stableStoreTimeout      = time.Second
+	defaultSnapshotRetain   = 1
+	defaultTransportMaxPool = 5
+	defaultTransportTimeout = 10 * time.Second
+)
+
 type Raft struct {
 	*raft.Raft
-	log *log.Log
+	log         *log.Log
+	logStore    *LogStore
+	stableStore *raftboltdb.BoltStore
+	snapshots   *SnapshotStore
+	args        Args
 }
 
 type Args struct {
-	DataDir            string
+	DataDir string
+	// Partitions is the number of partitions, each its own raft group, 1
+	// when zero.
+	Partitions int
+	// ReplicationFactor is the number of voters replicating each partition.
+	// When zero every voter replicates every partition.
+	ReplicationFactor  int
 	NodeName           string
-	IsBootstrap        bool
 	BindAddr           string
 	BootstrapTimeout   time.Duration
 	HeartbeatTimeout   time.Duration
 	ElectionTimeout    time.Duration
 	LeaderLeaseTimeout time.Duration
 	CommitTimeout      time.Duration
+	BatchApplyCh       bool
+	MaxAppendEntries   int
+
+	// zero keeps raft's default for each of these
+	SnapshotInterval  time.Duration
+	SnapshotThreshold uint64
+	TrailingLogs      uint64
+
+	// SnapshotRetain is the number of snapshots kept on disk, 1 when zero.
+	SnapshotRetain int
+	// TransportMaxPool is the number of idle connections kept per peer and
+	// TransportTimeout bounds each raft RPC, 5 and 10s when zero.
+	TransportMaxPool int
+	TransportTimeout time.Duration
+}
+
+// ValidateArgs checks args the way raft checks its config, so a bad setting
+// fails at startup instead of when raft is created.
+func ValidateArgs(args Args) error {
+	if args.Partitions < 0 {
+		return fmt.Errorf("invalid partitions: %d", args.Partitions)
+	}
+	if args.ReplicationFactor < 0 {
+		return fmt.Errorf("invalid replication factor: %d", args.ReplicationFactor)
+	}
+	if args.SnapshotRetain < 0 {
+		return fmt.Errorf("invalid snapshot retain: %d", args.SnapshotRetain)
+	}
+	if args.TransportMaxPool < 0 {
+		return fmt.Errorf("invalid transport max pool: %d", args.TransportMaxPool)
+	}
+	if args.TransportTimeout < 0 {
+		return fmt.Errorf("invalid transport timeout: %s", args.TransportTimeout)
+	}
+	if args.MaxAppendEntries < 0 {
+		return fmt.Errorf("invalid max append entries: %d", args.MaxAppendEntries)
+	}
+	return raft.ValidateConfig(setupConfig(args))
 }
 
-func NewRaft(l *log.Log, logStore *LogStore, sl *StreamLayer, args Args) (*Raft, error) {
-	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(args.DataDir, "raft", "stable"))
+const (
+	TransportTCP  = "tcp"
+	TransportGRPC = "grpc"
+)
+
+// Transports create the raft transport of each partition.
+type Transports interface {
+	Transport(partition uint32, args Args) raft.Transport
+	Close() error
+}
+
+// NewRaft creates the raft group of a partition, policies is nil unless it's
+// PolicyPartition.
+func NewRaft(l *log.Log, logStore *LogStore, transport raft.Transport, policies *PolicyStore, args Args) (*Raft, error) {
+	stableStore, snapshotStore, err := openStores(args)
 	if err != nil {
 		return nil, err
 	}
-	snapshotStore, err := raft.NewFileSnapshotStore(filepath.Join(args.DataDir, "raft"), 1, os.Stderr)
+	r, err := raft.NewRaft(setupConfig(args), NewFSM(l, snapshotStore, policies), logStore, stableStore, snapshotStore, transport)
 	if err != nil {
 		return nil, err
 	}
+	return &Raft{
+		Raft:        r,
+		log:         l,
+		logStore:    logStore,
+		stableStore: stableStore,
+		snapshots:   snapshotStore,
+		args:        args,
+	}, nil
+}
+
+func transportSettings(args Args) (maxPool int, timeout time.Duration) {
+	maxPool, timeout = args.TransportMaxPool, args.TransportTimeout
+	if maxPool == 0 {
+		maxPool = defaultTransportMaxPool
+	}
+	if timeout == 0 {
+		timeout = defaultTransportTimeout
+	}
+	return maxPool, timeout
+}
 
-	transport := raft.NewNetworkTransport(sl, 5, 10*time.Second, os.Stderr)
-	r, err := raft.NewRaft(setupConfig(args), &FSM{Log: l}, logStore, stableStore, snapshotStore, transport)
+func openStores(args Args) (*raftboltdb.BoltStore, *SnapshotStore, error) {
+	stableStore, err := raftboltdb.New(raftboltdb.Options{
+		Path: filepath.Join(args.DataDir, "raft", "stable"),
+		// fail instead of blocking when another process holds the store
+		BoltOptions: &bolt.Options{Timeout: stableStoreTimeout},
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("open stable store, is the node still running? %w", err)
 	}
-	rf := &Raft{Raft: r, log: l}
-	if !args.IsBootstrap {
-		return rf, nil
+	retain := args.SnapshotRetain
+	if retain == 0 {
+		retain = defaultSnapshotRetain
 	}
-	if err := rf.bootstrap(args); err != nil {
-		return nil, err
+	snapshotStore, err := NewSnapshotStore(filepath.Join(args.DataDir, "raft", "segment-snapshots"), retain)
+	if err != nil {
+		_ = stableStore.Close()
+		return nil, nil, err
 	}
-	return rf, nil
+	return stableStore, snapshotStore, nil
 }
 
 func setupConfig(args Args) *raft.Config {
@@ -69,20 +162,26 @@ func setupConfig(args Args) *raft.Config {
 	if args.CommitTimeout != 0 {
 		c.CommitTimeout = args.CommitTimeout
 	}
+	if args.MaxAppendEntries != 0 {
+		c.MaxAppendEntries = args.MaxAppendEntries
+	}
+	if args.SnapshotInterval != 0 {
+		c.SnapshotInterval = args.SnapshotInterval
+	}
+	if args.SnapshotThreshold != 0 {
+		c.SnapshotThreshold = args.SnapshotThreshold
+	}
+	if args.TrailingLogs != 0 {
+		c.TrailingLogs = args.TrailingLogs
+	}
+	c.BatchApplyCh = args.BatchApplyCh
 	return c
 }
 
-func (r *Raft) bootstrap(args Args) error {
-	c := raft.Configuration{
-		Servers: []raft.Server{{
-			ID:      raft.ServerID(args.NodeName),
-			Address: raft.ServerAddress(args.BindAddr),
-		}},
-	}
-	if err := r.BootstrapCluster(c).Error(); err != nil {
-		return err
-	}
-	return r.waitForLeader(args.BootstrapTimeout)
+// HasExistingState reports whether this node has ever been part of a raft
+// cluster, in which case it must never be bootstrapped again.
+func (r *Raft) HasExistingState() (bool, error) {
+	return raft.HasExistingState(r.logStore, r.stableStore, r.snapshots)
 }
 
 func (r *Raft) waitForLeader(timeout time.Duration) error {
@@ -101,10 +200,59 @@ func (r *Raft) waitForLeader(timeout time.Duration) error {
 	}
 }
 
+// IsReplica reports whether this node is a voter or nonvoter of the raft
+// group, as of the latest configuration it knows of.
+func (r *Raft) IsReplica() bool {
+	future := r.GetConfiguration()
+	if future.Error() != nil {
+		return false
+	}
+	return containsServer(future.Configuration().Servers, raft.ServerID(r.args.NodeName))
+}
+
+// Log returns the data log the raft group applies records to.
+func (r *Raft) Log() *log.Log {
+	return r.log
+}
+
+// NotifyStored reports when this node has stored a log carrying ext in its
+// extensions to its own raft log, before the log is committed.
+func (r *Raft) NotifyStored(ext []byte) (stored <-chan struct{}, cancel func()) {
+	return r.logStore.NotifyStored(ext)
+}
+
+// ListSnapshots returns the metadata of the snapshots kept on disk, newest
+// first.
+func (r *Raft) ListSnapshots() ([]*raft.SnapshotMeta, error) {
+	return r.snapshots.List()
+}
+
+// TakeSnapshot snapshots the FSM and returns the metadata of the snapshot.
+func (r *Raft) TakeSnapshot() (*raft.SnapshotMeta, error) {
+	future := r.Snapshot()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	meta, rc, err := future.Open()
+	if err != nil {
+		return nil, err
+	}
+	if err := rc.Close(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
 func (r *Raft) Close() error {
 	f := r.Shutdown()
 	if err := f.Error(); err != nil {
 		return err
 	}
+	if err := r.stableStore.Close(); err != nil {
+		return err
+	}
+	if err := r.logStore.Close(); err != nil {
+		return err
+	}
 	return r.log.Close()
 }
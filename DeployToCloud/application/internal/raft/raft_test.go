@@ -1,5 +1,14 @@
 package raft_test
 
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/travisjeffery/proglog/internal/raft"
+)
+
 // func TestMultipleNodes(t *testing.T) {
 // 	var logs []*raft.DistributedLog
 // 	nodeCount := 3
@@ -100,3 +109,30 @@ package raft_test
 // 	require.Equal(t, []byte("third"), record.Value)
 // 	require.Equal(t, off, record.Offset)
 // }
+
+func TestValidateArgs(t *testing.T) {
+	for scenario, test := range map[string]struct {
+		args  Args
+		valid bool
+	}{
+		"raft defaults":               {args: Args{NodeName: "node"}, valid: true},
+		"tuned snapshots":             {args: Args{NodeName: "node", SnapshotInterval: time.Second, SnapshotThreshold: 1024, TrailingLogs: 64, SnapshotRetain: 3}, valid: true},
+		"negative snapshot retain":    {args: Args{NodeName: "node", SnapshotRetain: -1}},
+		"negative transport pool":     {args: Args{NodeName: "node", TransportMaxPool: -1}},
+		"negative transport timeout":  {args: Args{NodeName: "node", TransportTimeout: -time.Second}},
+		"negative partitions":         {args: Args{NodeName: "node", Partitions: -1}},
+		"negative replication factor": {args: Args{NodeName: "node", ReplicationFactor: -1}},
+		"snapshot interval too short": {args: Args{NodeName: "node", SnapshotInterval: time.Millisecond}},
+		"too many append entries":     {args: Args{NodeName: "node", MaxAppendEntries: 4096}},
+		"election shorter than beat":  {args: Args{NodeName: "node", HeartbeatTimeout: time.Second, ElectionTimeout: 100 * time.Millisecond}},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			err := ValidateArgs(test.args)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package raft
+
+import (
+	"io"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	"github.com/travisjeffery/proglog/internal/log"
+)
+
+// Recovery rewrites the raft configuration of every partition of a stopped
+// node, like hashicorp raft's peers.json recovery, so it can elect leaders
+// after losing quorum.
+type Recovery struct {
+	partitions []*partitionRecovery
+}
+
+type partitionRecovery struct {
+	log         *log.Log
+	logStore    *LogStore
+	stableStore *raftboltdb.BoltStore
+	snapshots   *SnapshotStore
+	policies    *PolicyStore
+	args        Args
+}
+
+func NewRecovery(logConfig log.Config, args Args) (*Recovery, error) {
+	if err := migrateDataDir(args.DataDir); err != nil {
+		return nil, err
+	}
+	r := &Recovery{}
+	for id := 0; id < partitionCount(args); id++ {
+		l, logStore, partitionArgs, err := openPartition(logConfig, args, uint32(id))
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		stableStore, snapshotStore, err := openStores(partitionArgs)
+		if err != nil {
+			_ = logStore.Close()
+			_ = l.Close()
+			_ = r.Close()
+			return nil, err
+		}
+		pr := &partitionRecovery{
+			log:         l,
+			logStore:    logStore,
+			stableStore: stableStore,
+			snapshots:   snapshotStore,
+			args:        partitionArgs,
+		}
+		// the recovered snapshot keeps the acl policy
+		if uint32(id) == PolicyPartition {
+			pr.policies = NewPolicyStore()
+		}
+		r.partitions = append(r.partitions, pr)
+	}
+	return r, nil
+}
+
+// Recover replays each partition's raft log into its data log, snapshots it
+// with servers as the configuration and compacts the raft log. When servers
+// is empty the node recovers as a single-node cluster.
+func (r *Recovery) Recover(servers []raft.Server) error {
+	for _, p := range r.partitions {
+		if err := p.recover(servers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *partitionRecovery) recover(servers []raft.Server) error {
+	if len(servers) == 0 {
+		servers = []raft.Server{{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(p.args.NodeName),
+			Address:  raft.ServerAddress(p.args.BindAddr),
+		}}
+	}
+	_, transport := raft.NewInmemTransport(raft.ServerAddress(p.args.BindAddr))
+	return raft.RecoverCluster(
+		setupConfig(p.args),
+		&recoveryFSM{FSM: NewFSM(p.log, p.snapshots, p.policies)},
+		p.logStore,
+		p.stableStore,
+		p.snapshots,
+		transport,
+		raft.Configuration{Servers: servers},
+	)
+}
+
+// recoveryFSM rebuilds the data log from the newest snapshot and the raft
+// log. RecoverCluster only restores or applies once it has validated the
+// recovery, so a refused one leaves the data log as it was. Without a
+// snapshot to restore, the data log is reset before it's first applied to or
+// snapshotted.
+type recoveryFSM struct {
+	*FSM
+	rebuilt bool
+}
+
+func (f *recoveryFSM) Restore(r io.ReadCloser) error {
+	if err := f.FSM.Restore(r); err != nil {
+		return err
+	}
+	f.rebuilt = true
+	return nil
+}
+
+func (f *recoveryFSM) Apply(record *raft.Log) interface{} {
+	if err := f.rebuild(); err != nil {
+		return err
+	}
+	return f.FSM.Apply(record)
+}
+
+func (f *recoveryFSM) Snapshot() (raft.FSMSnapshot, error) {
+	if err := f.rebuild(); err != nil {
+		return nil, err
+	}
+	return f.FSM.Snapshot()
+}
+
+func (f *recoveryFSM) rebuild() error {
+	if f.rebuilt {
+		return nil
+	}
+	if err := f.Log.Reset(); err != nil {
+		return err
+	}
+	f.rebuilt = true
+	return nil
+}
+
+func (r *Recovery) Close() error {
+	var err error
+	for _, p := range r.partitions {
+		if cerr := p.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (p *partitionRecovery) close() error {
+	if err := p.stableStore.Close(); err != nil {
+		return err
+	}
+	if err := p.logStore.Close(); err != nil {
+		return err
+	}
+	return p.log.Close()
+}
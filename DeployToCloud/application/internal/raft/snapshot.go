@@ -0,0 +1,567 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/log"
+)
+
+const (
+	snapshotMetaFile = "meta.json"
+	snapshotStaging  = "staging"
+	tmpSuffix        = ".tmp"
+	maxHeaderBytes   = 64 << 20
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	ErrSnapshotChecksum = errors.New("snapshot file checksum mismatch")
+)
+
+// SnapshotStore keeps snapshots as hard links to the data log's segment
+// files rather than copies of the log, so a snapshot costs a link per
+// segment plus checksums of the files that changed since the previous one.
+// Open streams a snapshot as an archive of its files, which is also what a
+// follower receives when the leader installs a snapshot on it.
+type SnapshotStore struct {
+	dir    string
+	retain int
+	logger *zap.Logger
+}
+
+var _ raft.SnapshotStore = (*SnapshotStore)(nil)
+
+// snapshotFile is a file of a snapshot. Only its first Size bytes belong to
+// the snapshot: the active segment keeps growing through the link.
+type snapshotFile struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	CRC  uint32 `json:"crc"`
+}
+
+type snapshotMeta struct {
+	raft.SnapshotMeta
+	Files []snapshotFile
+}
+
+// snapshotHeader starts an archive and lists the files that follow it.
+type snapshotHeader struct {
+	ID    string         `json:"id"`
+	Files []snapshotFile `json:"files"`
+}
+
+func NewSnapshotStore(dir string, retain int) (*SnapshotStore, error) {
+	if retain < 1 {
+		return nil, fmt.Errorf("snapshot retain count must be positive, got %d", retain)
+	}
+	// drop what a crash left behind while staging or writing a snapshot
+	if err := os.RemoveAll(filepath.Join(dir, snapshotStaging)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, snapshotStaging), 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), tmpSuffix) {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &SnapshotStore{
+		dir:    dir,
+		retain: retain,
+		logger: zap.L().Named("snapshots"),
+	}, nil
+}
+
+func (s *SnapshotStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration, configurationIndex uint64, _ raft.Transport) (raft.SnapshotSink, error) {
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	id := fmt.Sprintf("%d-%d-%d", term, index, time.Now().UnixMilli())
+	dir := filepath.Join(s.dir, id+tmpSuffix)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &snapshotSink{
+		store: s,
+		dir:   dir,
+		meta: snapshotMeta{SnapshotMeta: raft.SnapshotMeta{
+			Version:            version,
+			ID:                 id,
+			Index:              index,
+			Term:               term,
+			Configuration:      configuration,
+			ConfigurationIndex: configurationIndex,
+		}},
+	}, nil
+}
+
+func (s *SnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	snapshots, err := s.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*raft.SnapshotMeta, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		metas = append(metas, &snapshot.SnapshotMeta)
+	}
+	return metas, nil
+}
+
+func (s *SnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := encodeHeader(id, meta.Files)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive := &archiveReader{}
+	readers := []io.Reader{bytes.NewReader(header)}
+	for _, file := range meta.Files {
+		readers = append(readers, &fileReader{
+			archive:   archive,
+			path:      filepath.Join(s.dir, id, file.Name),
+			remaining: file.Size,
+		})
+	}
+	archive.Reader = io.MultiReader(readers...)
+	return &meta.SnapshotMeta, archive, nil
+}
+
+// snapshots returns the complete snapshots, newest first.
+func (s *SnapshotStore) snapshots() ([]*snapshotMeta, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*snapshotMeta
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == snapshotStaging || strings.HasSuffix(entry.Name(), tmpSuffix) {
+			continue
+		}
+		meta, err := s.readMeta(entry.Name())
+		if err != nil {
+			s.logger.Warn("skipping unreadable snapshot", zap.String("id", entry.Name()), zap.Error(err))
+			continue
+		}
+		snapshots = append(snapshots, meta)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if a.Term != b.Term {
+			return a.Term > b.Term
+		}
+		if a.Index != b.Index {
+			return a.Index > b.Index
+		}
+		return a.ID > b.ID
+	})
+	return snapshots, nil
+}
+
+func (s *SnapshotStore) readMeta(id string) (*snapshotMeta, error) {
+	b, err := os.ReadFile(filepath.Join(s.dir, id, snapshotMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	meta := &snapshotMeta{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// stage creates a directory for FSM.Snapshot to link segment files into
+// until they're persisted.
+func (s *SnapshotStore) stage() (string, error) {
+	return os.MkdirTemp(filepath.Join(s.dir, snapshotStaging), "")
+}
+
+// local returns the directory of the snapshot when this store holds it.
+func (s *SnapshotStore) local(id string) (string, bool) {
+	dir := filepath.Join(s.dir, id)
+	if _, err := os.Stat(filepath.Join(dir, snapshotMetaFile)); err != nil {
+		return "", false
+	}
+	return dir, true
+}
+
+// reap removes all but the newest retain snapshots.
+func (s *SnapshotStore) reap() error {
+	snapshots, err := s.snapshots()
+	if err != nil {
+		return err
+	}
+	for i := s.retain; i < len(snapshots); i++ {
+		if err := os.RemoveAll(filepath.Join(s.dir, snapshots[i].ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type snapshotSink struct {
+	store  *SnapshotStore
+	dir    string
+	meta   snapshotMeta
+	closed bool
+
+	// set while receiving an archive streamed by the leader
+	pw       *io.PipeWriter
+	received chan error
+}
+
+var _ raft.SnapshotSink = (*snapshotSink)(nil)
+
+func (s *snapshotSink) ID() string {
+	return s.meta.ID
+}
+
+// Write receives the archive of a snapshot the leader is installing,
+// verifying each file against its checksum as it's written.
+func (s *snapshotSink) Write(p []byte) (int, error) {
+	if s.pw == nil {
+		pr, pw := io.Pipe()
+		s.pw = pw
+		s.received = make(chan error, 1)
+		go func() {
+			files, err := receiveArchive(pr, s.dir)
+			s.meta.Files = files
+			// fail writes of anything past the archive
+			pr.CloseWithError(err)
+			s.received <- err
+		}()
+	}
+	return s.pw.Write(p)
+}
+
+// link moves the segment files FSM.Snapshot staged into the snapshot,
+// reusing the checksums of files the newest snapshot shares at the same
+// size.
+func (s *snapshotSink) link(staged string, segments []log.SegmentSnapshot) error {
+	snapshots, err := s.store.snapshots()
+	if err != nil {
+		return err
+	}
+	prev := map[string]snapshotFile{}
+	var prevDir string
+	if len(snapshots) > 0 {
+		prevDir = filepath.Join(s.store.dir, snapshots[0].ID)
+		for _, file := range snapshots[0].Files {
+			prev[file.Name] = file
+		}
+	}
+	for _, segment := range segments {
+		for _, file := range []snapshotFile{
+			{Name: log.StoreFile(segment.BaseOffset), Size: segment.StoreSize},
+			{Name: log.IndexFile(segment.BaseOffset), Size: segment.IndexSize},
+		} {
+			path := filepath.Join(s.dir, file.Name)
+			if err := os.Rename(filepath.Join(staged, file.Name), path); err != nil {
+				return err
+			}
+			if p, ok := prev[file.Name]; ok && p.Size == file.Size && sameFile(path, filepath.Join(prevDir, file.Name)) {
+				file.CRC = p.CRC
+			} else if file.CRC, err = checksum(path, file.Size); err != nil {
+				return err
+			}
+			s.meta.Files = append(s.meta.Files, file)
+		}
+	}
+	return nil
+}
+
+// add writes a file holding b into the snapshot.
+func (s *snapshotSink) add(name string, b []byte) error {
+	if err := writeSynced(filepath.Join(s.dir, name), b); err != nil {
+		return err
+	}
+	s.meta.Files = append(s.meta.Files, snapshotFile{
+		Name: name,
+		Size: uint64(len(b)),
+		CRC:  crc32.Checksum(b, castagnoli),
+	})
+	return nil
+}
+
+func (s *snapshotSink) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.pw != nil {
+		_ = s.pw.Close()
+		if err := <-s.received; err != nil {
+			_ = os.RemoveAll(s.dir)
+			return err
+		}
+	}
+	header, err := encodeHeader(s.meta.ID, s.meta.Files)
+	if err != nil {
+		return err
+	}
+	// the size of the archive Open streams
+	s.meta.Size = int64(len(header))
+	for _, file := range s.meta.Files {
+		s.meta.Size += int64(file.Size)
+	}
+	b, err := json.Marshal(&s.meta)
+	if err != nil {
+		return err
+	}
+	if err := writeSynced(filepath.Join(s.dir, snapshotMetaFile), b); err != nil {
+		return err
+	}
+	if err := os.Rename(s.dir, filepath.Join(s.store.dir, s.meta.ID)); err != nil {
+		return err
+	}
+	return s.store.reap()
+}
+
+func (s *snapshotSink) Cancel() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.pw != nil {
+		s.pw.CloseWithError(errors.New("snapshot canceled"))
+		<-s.received
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// archiveReader streams a snapshot's header and files, opening each file
+// only once it's reached.
+type archiveReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (a *archiveReader) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+type fileReader struct {
+	archive   *archiveReader
+	path      string
+	remaining uint64
+	opened    bool
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	if !f.opened {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.archive.file = file
+		f.opened = true
+	}
+	if f.remaining == 0 {
+		err := f.archive.file.Close()
+		f.archive.file = nil
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.archive.file.Read(p)
+	f.remaining -= uint64(n)
+	if errors.Is(err, io.EOF) {
+		if f.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func encodeHeader(id string, files []snapshotFile) ([]byte, error) {
+	b, err := json.Marshal(&snapshotHeader{ID: id, Files: files})
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, log.LenWidth, log.LenWidth+len(b))
+	log.Enc.PutUint64(header, uint64(len(b)))
+	return append(header, b...), nil
+}
+
+func readHeader(r io.Reader) (*snapshotHeader, error) {
+	size := make([]byte, log.LenWidth)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return nil, err
+	}
+	n := log.Enc.Uint64(size)
+	if n > maxHeaderBytes {
+		return nil, fmt.Errorf("snapshot header of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	header := &snapshotHeader{}
+	if err := json.Unmarshal(b, header); err != nil {
+		return nil, err
+	}
+	for _, file := range header.Files {
+		if file.Name != filepath.Base(file.Name) || strings.HasPrefix(file.Name, ".") {
+			return nil, fmt.Errorf("invalid snapshot file name %q", file.Name)
+		}
+	}
+	return header, nil
+}
+
+// receiveArchive writes the files of an archive into dir.
+func receiveArchive(r io.Reader, dir string) ([]snapshotFile, error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range header.Files {
+		if err := writeFile(r, filepath.Join(dir, file.Name), file); err != nil {
+			return nil, err
+		}
+	}
+	return header.Files, nil
+}
+
+// installFile installs the snapshot file at src as dst. src is hard-linked
+// when it holds just the snapshot's bytes and copied up to the snapshot's
+// size otherwise; either way it's verified against its checksum.
+func installFile(src, dst string, file snapshotFile) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if uint64(fi.Size()) == file.Size {
+		crc, err := checksum(src, file.Size)
+		if err != nil {
+			return err
+		}
+		if crc != file.CRC {
+			return fmt.Errorf("%s: %w", file.Name, ErrSnapshotChecksum)
+		}
+		return os.Link(src, dst)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(f, dst, file)
+}
+
+// writeFile copies the file's bytes from r to path and verifies them
+// against the file's checksum.
+func writeFile(r io.Reader, path string, file snapshotFile) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := crc32.New(castagnoli)
+	if _, err := io.CopyN(io.MultiWriter(f, h), r, int64(file.Size)); err != nil {
+		return err
+	}
+	if h.Sum32() != file.CRC {
+		return fmt.Errorf("%s: %w", file.Name, ErrSnapshotChecksum)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readSnapshotFile returns the bytes of the file, read from dir when the
+// snapshot is local and from the archive r otherwise, after verifying them
+// against the file's checksum.
+func readSnapshotFile(r io.Reader, dir string, local bool, file snapshotFile) ([]byte, error) {
+	if file.Size > maxHeaderBytes {
+		return nil, fmt.Errorf("snapshot file %s of %d bytes is too large", file.Name, file.Size)
+	}
+	if local {
+		f, err := os.Open(filepath.Join(dir, file.Name))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	b := make([]byte, file.Size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(b, castagnoli) != file.CRC {
+		return nil, fmt.Errorf("%s: %w", file.Name, ErrSnapshotChecksum)
+	}
+	return b, nil
+}
+
+func writeSynced(path string, b []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// checksum returns the checksum of the first size bytes of the file.
+func checksum(path string, size uint64) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.New(castagnoli)
+	if _, err := io.CopyN(h, f, int64(size)); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
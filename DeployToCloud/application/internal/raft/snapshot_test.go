@@ -0,0 +1,206 @@
+package raft_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/travisjeffery/proglog/internal/log"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	. "github.com/travisjeffery/proglog/internal/raft"
+)
+
+type snapshotNode struct {
+	log       *log.Log
+	fsm       *FSM
+	snapshots *SnapshotStore
+	policies  *PolicyStore
+	dir       string
+}
+
+func TestSnapshot(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, a, b *snapshotNode){
+		"snapshot links segments and restores locally": testSnapshotRestoreLocal,
+		"snapshot installs on another node":            testSnapshotInstall,
+		"corrupt archive fails checksum":               testSnapshotCorrupt,
+		"snapshot carries the acl policy":              testSnapshotPolicies,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, setupSnapshotNode(t), setupSnapshotNode(t))
+		})
+	}
+}
+
+func setupSnapshotNode(t *testing.T) *snapshotNode {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "log"), 0o755))
+	l, err := log.NewLog(log.Config{
+		DataDir:       filepath.Join(dir, "log"),
+		MaxStoreBytes: 32,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	snapshots, err := NewSnapshotStore(filepath.Join(dir, "snapshots"), 1)
+	require.NoError(t, err)
+	policies := NewPolicyStore()
+	return &snapshotNode{
+		log:       l,
+		fsm:       NewFSM(l, snapshots, policies),
+		snapshots: snapshots,
+		policies:  policies,
+		dir:       dir,
+	}
+}
+
+func (n *snapshotNode) append(t *testing.T, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		_, err := n.log.Append(&pb.Record{Value: []byte(value)})
+		require.NoError(t, err)
+	}
+}
+
+func (n *snapshotNode) snapshot(t *testing.T, index uint64) *raft.SnapshotMeta {
+	t.Helper()
+	snapshot, err := n.fsm.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Release()
+	sink, err := n.snapshots.Create(1, index, 1, raft.Configuration{}, 0, nil)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Persist(sink))
+	require.NoError(t, sink.Close())
+	metas, err := n.snapshots.List()
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	return metas[0]
+}
+
+func (n *snapshotNode) restore(t *testing.T, id string) {
+	t.Helper()
+	_, rc, err := n.snapshots.Open(id)
+	require.NoError(t, err)
+	defer rc.Close()
+	require.NoError(t, n.fsm.Restore(rc))
+}
+
+func requireValues(t *testing.T, l *log.Log, want ...string) {
+	t.Helper()
+	records, next, err := l.ReadRange(0, 0, 0)
+	require.NoError(t, err)
+	var got []string
+	for _, record := range records {
+		got = append(got, string(record.Value))
+	}
+	require.Equal(t, want, got)
+	require.Equal(t, uint64(len(want)), next)
+}
+
+func testSnapshotRestoreLocal(t *testing.T, a, _ *snapshotNode) {
+	t.Helper()
+	a.append(t, "first", "second", "third", "fourth", "fifth")
+	meta := a.snapshot(t, 1)
+
+	// segments are linked, not copied
+	linked, err := os.Stat(filepath.Join(a.dir, "snapshots", meta.ID, log.StoreFile(0)))
+	require.NoError(t, err)
+	live, err := os.Stat(filepath.Join(a.dir, "log", log.StoreFile(0)))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(linked, live))
+
+	// records appended after the snapshot don't survive restoring it
+	a.append(t, "sixth")
+	a.restore(t, meta.ID)
+	requireValues(t, a.log, "first", "second", "third", "fourth", "fifth")
+
+	// the next snapshot replaces the previous one
+	a.append(t, "sixth")
+	next := a.snapshot(t, 2)
+	require.NotEqual(t, meta.ID, next.ID)
+}
+
+func testSnapshotInstall(t *testing.T, a, b *snapshotNode) {
+	t.Helper()
+	a.append(t, "first", "second", "third")
+	meta := a.snapshot(t, 1)
+
+	// what the leader streams to a follower on InstallSnapshot
+	_, rc, err := a.snapshots.Open(meta.ID)
+	require.NoError(t, err)
+	defer rc.Close()
+	sink, err := b.snapshots.Create(1, meta.Index, meta.Term, meta.Configuration, meta.ConfigurationIndex, nil)
+	require.NoError(t, err)
+	n, err := io.Copy(sink, rc)
+	require.NoError(t, err)
+	require.Equal(t, meta.Size, n)
+	require.NoError(t, sink.Close())
+
+	b.restore(t, sink.ID())
+	requireValues(t, b.log, "first", "second", "third")
+
+	off, err := b.log.Append(&pb.Record{Value: []byte("fourth")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testSnapshotCorrupt(t *testing.T, a, b *snapshotNode) {
+	t.Helper()
+	a.append(t, "first", "second", "third")
+	meta := a.snapshot(t, 1)
+
+	_, rc, err := a.snapshots.Open(meta.ID)
+	require.NoError(t, err)
+	defer rc.Close()
+	archive, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	archive[len(archive)-1] ^= 0xff
+
+	sink, err := b.snapshots.Create(1, meta.Index, meta.Term, meta.Configuration, meta.ConfigurationIndex, nil)
+	require.NoError(t, err)
+	_, err = io.Copy(sink, bytes.NewReader(archive))
+	require.NoError(t, err)
+	require.ErrorIs(t, sink.Close(), ErrSnapshotChecksum)
+
+	metas, err := b.snapshots.List()
+	require.NoError(t, err)
+	require.Empty(t, metas)
+}
+
+func testSnapshotPolicies(t *testing.T, a, b *snapshotNode) {
+	t.Helper()
+	a.append(t, "first")
+	root := &pb.Policy{Ptype: "p", Fields: []string{"root", "*", "produce"}}
+	alice := &pb.Policy{Ptype: "p", Fields: []string{"alice", "orders", "consume"}}
+	require.Nil(t, applyPolicy(t, a.fsm, &pb.PolicyCommand{Policy: alice, Seed: []*pb.Policy{root}}))
+	meta := a.snapshot(t, 1)
+
+	_, rc, err := a.snapshots.Open(meta.ID)
+	require.NoError(t, err)
+	defer rc.Close()
+	sink, err := b.snapshots.Create(1, meta.Index, meta.Term, meta.Configuration, meta.ConfigurationIndex, nil)
+	require.NoError(t, err)
+	_, err = io.Copy(sink, rc)
+	require.NoError(t, err)
+	require.NoError(t, sink.Close())
+
+	b.restore(t, sink.ID())
+	requireValues(t, b.log, "first")
+	policies, _, seeded := b.policies.Policies()
+	require.True(t, seeded)
+	require.Len(t, policies, 2)
+	require.Equal(t, root.Fields, policies[0].Fields)
+	require.Equal(t, alice.Fields, policies[1].Fields)
+}
+
+func applyPolicy(t *testing.T, fsm *FSM, cmd *pb.PolicyCommand) interface{} {
+	t.Helper()
+	b, err := proto.Marshal(cmd)
+	require.NoError(t, err)
+	return fsm.Apply(&raft.Log{Type: raft.LogCommand, Data: append([]byte{byte(PolicyRequestType)}, b...)})
+}
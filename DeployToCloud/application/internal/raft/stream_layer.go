@@ -3,28 +3,61 @@ package raft
 import (
 	"bytes"
 	"crypto/tls"
-	"fmt"
+	"errors"
 	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
 	"github.com/soheilhy/cmux"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
 
+	"github.com/travisjeffery/proglog/internal/log"
 	innertls "github.com/travisjeffery/proglog/internal/tls"
 )
 
-const RPC = 1
+const (
+	RPC = 1
 
+	// partitionWidth is the width of the partition id that follows the RPC
+	// byte on every raft connection.
+	partitionWidth = 4
+	headerTimeout  = 10 * time.Second
+)
+
+var errStreamLayerClosed = errors.New("stream layer closed")
+
+// StreamLayer accepts the raft connections of every partition on the mux
+// and hands each to its partition's raft group. With TLS, only peers whose
+// certificate identifies them as a node are accepted.
 type StreamLayer struct {
 	ln              net.Listener
 	serverTLSConfig *tls.Config
 	peerTLSConfig   *tls.Config
-}
+	identity        *peerIdentity
 
-var _ raft.StreamLayer = (*StreamLayer)(nil)
+	mu         sync.Mutex
+	partitions map[uint32]*partitionLayer
+	rafts      *Partitions
+	accept     sync.Once
+	closed     chan struct{}
+	logger     *zap.Logger
+}
 
-func NewStreamLayer(mux cmux.CMux, cfg innertls.Config) *StreamLayer {
+// NewStreamLayer accepts peers whose certificate's common name or a SAN
+// matches the peerIdentity glob or a server of the partition's raft
+// configuration.
+func NewStreamLayer(mux cmux.CMux, cfg innertls.Config, peerIdentity string) (*StreamLayer, error) {
+	identity, err := newPeerIdentity(peerIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if err := view.Register(DefaultStreamLayerViews...); err != nil {
+		return nil, err
+	}
 	raftLn := mux.Match(func(reader io.Reader) bool {
 		b := make([]byte, 1)
 		if _, err := reader.Read(b); err != nil {
@@ -36,47 +69,217 @@ func NewStreamLayer(mux cmux.CMux, cfg innertls.Config) *StreamLayer {
 		ln:              raftLn,
 		serverTLSConfig: cfg.ServerTLSConfig,
 		peerTLSConfig:   cfg.PeerTLSConfig,
+		identity:        identity,
+		partitions:      make(map[uint32]*partitionLayer),
+		closed:          make(chan struct{}),
+		logger:          zap.L().Named("stream_layer"),
+	}, nil
+}
+
+// Partition returns the stream layer of a partition's raft group.
+func (s *StreamLayer) Partition(id uint32) raft.StreamLayer {
+	s.accept.Do(func() {
+		go s.acceptLoop()
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.partitions[id]
+	if !ok {
+		p = &partitionLayer{
+			id:     id,
+			parent: s,
+			conns:  make(chan net.Conn),
+			closed: make(chan struct{}),
+		}
+		s.partitions[id] = p
+	}
+	return p
+}
+
+// Transport returns hashicorp raft's network transport over the partition's
+// stream layer.
+func (s *StreamLayer) Transport(partition uint32, args Args) raft.Transport {
+	maxPool, timeout := transportSettings(args)
+	return raft.NewNetworkTransport(s.Partition(partition), maxPool, timeout, os.Stderr)
+}
+
+func (s *StreamLayer) acceptLoop() {
+	defer close(s.closed)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.route(conn)
+	}
+}
+
+// route reads the connection's header and hands it to its partition.
+func (s *StreamLayer) route(conn net.Conn) {
+	if err := conn.SetDeadline(time.Now().Add(headerTimeout)); err != nil {
+		_ = conn.Close()
+		return
+	}
+	b := make([]byte, 1+partitionWidth)
+	if _, err := io.ReadFull(conn, b); err != nil || b[0] != byte(RPC) {
+		s.logger.Debug("dropping connection without a raft header", zap.Error(err))
+		_ = conn.Close()
+		return
+	}
+	id := log.Enc.Uint32(b[1:])
+	s.mu.Lock()
+	p, ok := s.partitions[id]
+	s.mu.Unlock()
+	if !ok {
+		s.logger.Warn("dropping connection for unknown partition", zap.Uint32("partition", id))
+		_ = conn.Close()
+		return
+	}
+	if s.serverTLSConfig != nil {
+		tlsConn := tls.Server(conn, s.serverTLSConfig)
+		if !s.verify(tlsConn, id) {
+			_ = conn.Close()
+			return
+		}
+		conn = tlsConn
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return
+	}
+	select {
+	case p.conns <- conn:
+	case <-p.closed:
+		_ = conn.Close()
+	}
+}
+
+// verify completes the handshake and checks the peer is a node of the
+// cluster, logging and counting the reason when it isn't.
+func (s *StreamLayer) verify(conn *tls.Conn, partition uint32) bool {
+	cert, reason, err := peerCertificate(conn)
+	if reason == "" && !s.identity.verify(cert, s.servers(partition)) {
+		reason = RejectIdentity
+	}
+	if reason == "" {
+		return true
+	}
+	fields := []zap.Field{
+		zap.String("reason", reason),
+		zap.Stringer("remote", conn.RemoteAddr()),
+		zap.Uint32("partition", partition),
+		zap.Error(err),
+	}
+	if cert != nil {
+		fields = append(fields, zap.Strings("identities", identities(cert)))
+	}
+	s.logger.Warn("rejecting raft connection", fields...)
+	recordRejected(reason)
+	return false
+}
+
+// watch lets the stream layer accept the servers of the partitions' raft
+// configurations.
+func (s *StreamLayer) watch(p *Partitions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rafts = p
+}
+
+func (s *StreamLayer) servers(partition uint32) []raft.Server {
+	s.mu.Lock()
+	rafts := s.rafts
+	s.mu.Unlock()
+	if rafts == nil {
+		return nil
+	}
+	r, err := rafts.Get(partition)
+	if err != nil {
+		return nil
+	}
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil
 	}
+	return future.Configuration().Servers
 }
 
-func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+func (s *StreamLayer) dial(id uint32, addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
 	dialer := &net.Dialer{Timeout: timeout}
 	conn, err := dialer.Dial("tcp", string(addr))
 	if err != nil {
 		return nil, err
 	}
-	// identify to mux this is a raft rpc
-	if _, err := conn.Write([]byte{byte(RPC)}); err != nil {
+	// identify to mux this is a raft rpc and to the peer which partition
+	header := make([]byte, 1+partitionWidth)
+	header[0] = byte(RPC)
+	log.Enc.PutUint32(header[1:], id)
+	if _, err := conn.Write(header); err != nil {
 		return nil, err
 	}
 	if s.peerTLSConfig != nil {
-		conn = tls.Client(conn, s.peerTLSConfig)
+		conn = tls.Client(conn, peerTLSConfig(s.peerTLSConfig, addr))
 	}
 	return conn, err
 }
 
-func (s *StreamLayer) Accept() (net.Conn, error) {
-	conn, err := s.ln.Accept()
+// peerTLSConfig verifies the peer against the host it was dialed by, the same
+// way grpc does, unless a server name is configured explicitly.
+func peerTLSConfig(cfg *tls.Config, addr raft.ServerAddress) *tls.Config {
+	host, _, err := net.SplitHostPort(string(addr))
 	if err != nil {
-		return nil, err
-	}
-	b := make([]byte, 1)
-	if _, err = conn.Read(b); err != nil {
-		return nil, err
-	}
-	if !bytes.Equal([]byte{byte(RPC)}, b) {
-		return nil, fmt.Errorf("not a raft rpc")
+		return cfg
 	}
-	if s.serverTLSConfig != nil {
-		return tls.Server(conn, s.serverTLSConfig), nil
-	}
-	return conn, nil
+	return innertls.ForServer(cfg, host)
 }
 
+// Close closes the mux listener, which the grpc server may already have
+// closed as they share the mux's root listener.
 func (s *StreamLayer) Close() error {
-	return s.ln.Close()
+	if err := s.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
 }
 
 func (s *StreamLayer) Addr() net.Addr {
 	return s.ln.Addr()
 }
+
+type partitionLayer struct {
+	id     uint32
+	parent *StreamLayer
+	conns  chan net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+var _ raft.StreamLayer = (*partitionLayer)(nil)
+
+func (p *partitionLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return p.parent.dial(p.id, addr, timeout)
+}
+
+func (p *partitionLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case <-p.closed:
+		return nil, errStreamLayerClosed
+	case <-p.parent.closed:
+		return nil, errStreamLayerClosed
+	}
+}
+
+// Close stops accepting the partition's connections. The shared listener is
+// closed by StreamLayer.Close.
+func (p *partitionLayer) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+func (p *partitionLayer) Addr() net.Addr {
+	return p.parent.Addr()
+}
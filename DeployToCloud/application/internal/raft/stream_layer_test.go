@@ -0,0 +1,98 @@
+package raft_test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/soheilhy/cmux"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+
+	. "github.com/travisjeffery/proglog/internal/raft"
+	innertls "github.com/travisjeffery/proglog/internal/tls"
+	"github.com/travisjeffery/proglog/test"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(test.Run(m))
+}
+
+func TestStreamLayerPeerIdentity(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		certFile, keyFile string
+		accepted          bool
+	}{
+		"node certificate is accepted":   {certFile: innertls.RootClientCertFile, keyFile: innertls.RootClientKeyFile, accepted: true},
+		"client certificate is rejected": {certFile: innertls.NobodyClientCertFile, keyFile: innertls.NobodyClientKeyFile},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			serverTLSConfig, err := innertls.SetupTLS(innertls.Args{
+				CertFile: innertls.ServerCertFile,
+				KeyFile:  innertls.ServerKeyFile,
+				CAFile:   innertls.CAFile,
+				Server:   true,
+			})
+			require.NoError(t, err)
+			mux := cmux.New(ln)
+			accepting, err := NewStreamLayer(mux, innertls.Config{ServerTLSConfig: serverTLSConfig}, "root")
+			require.NoError(t, err)
+			go func() {
+				_ = mux.Serve()
+			}()
+			defer func() {
+				_ = ln.Close()
+			}()
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				conn, err := accepting.Partition(0).Accept()
+				if err == nil {
+					accepted <- conn
+				}
+			}()
+
+			peerTLSConfig, err := innertls.SetupTLS(innertls.Args{
+				CertFile: tc.certFile,
+				KeyFile:  tc.keyFile,
+				CAFile:   innertls.CAFile,
+			})
+			require.NoError(t, err)
+			dialing, err := NewStreamLayer(cmux.New(ln), innertls.Config{PeerTLSConfig: peerTLSConfig}, "")
+			require.NoError(t, err)
+			conn, err := dialing.Partition(0).Dial(raft.ServerAddress(ln.Addr().String()), time.Second)
+			require.NoError(t, err)
+			defer func() {
+				_ = conn.Close()
+			}()
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			if tc.accepted {
+				server := <-accepted
+				b := make([]byte, 4)
+				_, err = server.Read(b)
+				require.NoError(t, err)
+				require.Equal(t, "ping", string(b))
+				return
+			}
+			_, err = conn.Read(make([]byte, 1))
+			require.Error(t, err)
+			require.Eventually(t, func() bool {
+				rows, err := view.RetrieveData(RejectedConnectionsView.Name)
+				require.NoError(t, err)
+				for _, row := range rows {
+					for _, tag := range row.Tags {
+						if tag.Key == KeyReason && tag.Value == RejectIdentity {
+							return true
+						}
+					}
+				}
+				return false
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
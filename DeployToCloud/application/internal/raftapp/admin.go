@@ -0,0 +1,287 @@
+package raftapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	innerraft "github.com/travisjeffery/proglog/internal/raft"
+)
+
+// IAdmin manages the raft group of a partition.
+type IAdmin interface {
+	RemoveServer(partition uint32, id string) error
+	AddVoter(partition uint32, id, addr string) error
+	AddNonvoter(partition uint32, id, addr string) error
+	TransferLeadership(partition uint32, id, addr string) error
+	Snapshot(partition uint32) (*pb.SnapshotMeta, error)
+	ListSnapshots(partition uint32) ([]*pb.SnapshotMeta, error)
+	RaftStats(partition uint32) (map[string]string, error)
+	MovePartition(ctx context.Context, partition uint32, fromID, toID, toAddr string) error
+	DescribePartitions() ([]*pb.PartitionReplicas, error)
+	Grant(policy *pb.Policy) error
+	Revoke(policy *pb.Policy) error
+	ListPolicies() (policies []*pb.Policy, replicated bool, err error)
+}
+
+// IPolicyModel checks acl rules against the casbin model and reads the
+// policy file that seeds the replicated policy.
+type IPolicyModel interface {
+	ValidatePolicy(policy *pb.Policy) error
+	FilePolicies() ([]*pb.Policy, error)
+}
+
+const (
+	catchUpInterval = 100 * time.Millisecond
+	policyTimeout   = 10 * time.Second
+)
+
+var ErrInvalidMove = errors.New("invalid partition move")
+
+type Admin struct {
+	partitions *innerraft.Partitions
+	peers      IPeers
+	model      IPolicyModel
+}
+
+func NewAdmin(p *innerraft.Partitions, peers IPeers, model IPolicyModel) *Admin {
+	return &Admin{partitions: p, peers: peers, model: model}
+}
+
+func (a *Admin) RemoveServer(partition uint32, id string) error {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return err
+	}
+	return r.RemoveServer(raft.ServerID(id), 0, 0).Error()
+}
+
+func (a *Admin) AddVoter(partition uint32, id, addr string) error {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return err
+	}
+	return r.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error()
+}
+
+func (a *Admin) AddNonvoter(partition uint32, id, addr string) error {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return err
+	}
+	return r.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error()
+}
+
+// TransferLeadership hands leadership to the given server, or to the most
+// up-to-date follower when id is empty.
+func (a *Admin) TransferLeadership(partition uint32, id, addr string) error {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return r.LeadershipTransfer().Error()
+	}
+	return r.LeadershipTransferToServer(raft.ServerID(id), raft.ServerAddress(addr)).Error()
+}
+
+func (a *Admin) Snapshot(partition uint32) (*pb.SnapshotMeta, error) {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := r.TakeSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return toSnapshotMeta(meta), nil
+}
+
+func (a *Admin) ListSnapshots(partition uint32) ([]*pb.SnapshotMeta, error) {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := r.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*pb.SnapshotMeta, 0, len(metas))
+	for _, meta := range metas {
+		snapshots = append(snapshots, toSnapshotMeta(meta))
+	}
+	return snapshots, nil
+}
+
+func (a *Admin) RaftStats(partition uint32) (map[string]string, error) {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return nil, err
+	}
+	return r.Stats(), nil
+}
+
+// MovePartition moves the partition's replica on fromID to toID. toID joins
+// as a nonvoter and is promoted once it has applied what the leader had
+// applied when it joined, then fromID is removed, or demoted to a nonvoter
+// of PolicyPartition so that it keeps applying the acl policy. It must be
+// called on the partition's leader.
+func (a *Admin) MovePartition(ctx context.Context, partition uint32, fromID, toID, toAddr string) error {
+	r, err := a.partitions.Get(partition)
+	if err != nil {
+		return err
+	}
+	if a.partitions.ReplicationFactor() == 0 {
+		return fmt.Errorf("%w: every voter replicates every partition", ErrInvalidMove)
+	}
+	if r.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+	configFuture := r.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	var isReplica bool
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(toID) && srv.Suffrage == raft.Voter {
+			return fmt.Errorf("%w: %s already replicates partition %d", ErrInvalidMove, toID, partition)
+		}
+		isReplica = isReplica || (srv.ID == raft.ServerID(fromID) && srv.Suffrage == raft.Voter)
+	}
+	if !isReplica {
+		return fmt.Errorf("%w: %s doesn't replicate partition %d", ErrInvalidMove, fromID, partition)
+	}
+
+	if err := r.AddNonvoter(raft.ServerID(toID), raft.ServerAddress(toAddr), 0, 0).Error(); err != nil {
+		return err
+	}
+	if err := a.waitForCatchUp(ctx, partition, toAddr, r.AppliedIndex()); err != nil {
+		return err
+	}
+	if err := r.AddVoter(raft.ServerID(toID), raft.ServerAddress(toAddr), 0, 0).Error(); err != nil {
+		return err
+	}
+	if partition == innerraft.PolicyPartition {
+		return r.DemoteVoter(raft.ServerID(fromID), 0, 0).Error()
+	}
+	return r.RemoveServer(raft.ServerID(fromID), 0, 0).Error()
+}
+
+// waitForCatchUp polls the server at addr until it has applied index.
+func (a *Admin) waitForCatchUp(ctx context.Context, partition uint32, addr string, index uint64) error {
+	return a.peers.Admin(ctx, addr, func(client pb.AdminClient) error {
+		ticker := time.NewTicker(catchUpInterval)
+		defer ticker.Stop()
+		for {
+			res, err := client.RaftStats(ctx, &pb.RaftStatsRequest{Partition: partition})
+			if err != nil {
+				return err
+			}
+			applied, err := strconv.ParseUint(res.Stats["applied_index"], 10, 64)
+			if err != nil {
+				return err
+			}
+			if applied >= index {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// DescribePartitions returns the replicas of the partitions this node leads.
+func (a *Admin) DescribePartitions() ([]*pb.PartitionReplicas, error) {
+	var partitions []*pb.PartitionReplicas
+	for id, r := range a.partitions.All() {
+		if r.State() != raft.Leader {
+			continue
+		}
+		configFuture := r.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			return nil, err
+		}
+		leader := r.Leader()
+		replicas := &pb.PartitionReplicas{Partition: uint32(id)}
+		for _, srv := range configFuture.Configuration().Servers {
+			if srv.Address == leader {
+				replicas.LeaderId = string(srv.ID)
+			}
+			replicas.Replicas = append(replicas.Replicas, &pb.Server{
+				Id:       string(srv.ID),
+				RpcAddr:  string(srv.Address),
+				Suffrage: toSuffrage(srv.Suffrage),
+			})
+		}
+		partitions = append(partitions, replicas)
+	}
+	return partitions, nil
+}
+
+func toSnapshotMeta(meta *raft.SnapshotMeta) *pb.SnapshotMeta {
+	return &pb.SnapshotMeta{
+		Id:    meta.ID,
+		Index: meta.Index,
+		Term:  meta.Term,
+		Size:  meta.Size,
+	}
+}
+
+// Grant adds the rule to the replicated acl policy. Like Revoke, it must be
+// called on the leader of the policy's partition, and the first change seeds
+// the policy with this node's policy file.
+func (a *Admin) Grant(policy *pb.Policy) error {
+	return a.changePolicy(&pb.PolicyCommand{Policy: policy})
+}
+
+func (a *Admin) Revoke(policy *pb.Policy) error {
+	return a.changePolicy(&pb.PolicyCommand{Policy: policy, Revoke: true})
+}
+
+// ListPolicies returns the replicated acl policy as this node applied it,
+// or the policy file's rules while the policy isn't seeded.
+func (a *Admin) ListPolicies() ([]*pb.Policy, bool, error) {
+	if policies, _, seeded := a.partitions.Policies().Policies(); seeded {
+		return policies, true, nil
+	}
+	policies, err := a.model.FilePolicies()
+	return policies, false, err
+}
+
+func (a *Admin) changePolicy(cmd *pb.PolicyCommand) error {
+	if err := a.model.ValidatePolicy(cmd.Policy); err != nil {
+		return err
+	}
+	r, err := a.partitions.Get(innerraft.PolicyPartition)
+	if err != nil {
+		return err
+	}
+	// the FSM ignores the seed once the policy is seeded, so a stale view
+	// here is harmless
+	if _, _, seeded := a.partitions.Policies().Policies(); !seeded {
+		if cmd.Seed, err = a.model.FilePolicies(); err != nil {
+			return err
+		}
+	}
+	b, err := proto.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	future := r.Apply(append([]byte{byte(innerraft.PolicyRequestType)}, b...), policyTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
@@ -1,41 +1,91 @@
 package raftapp
 
 import (
+	"sort"
+
 	"github.com/hashicorp/raft"
 
 	innerraft "github.com/travisjeffery/proglog/internal/raft"
 )
 
 type IMembershipHandler interface {
-	Join(name, addr string) error
+	Join(name, addr string, voter bool) error
 	Leave(name string) error
+	// Bootstrap forms a new cluster from the voters, keyed by name to
+	// address.
+	Bootstrap(voters map[string]string) error
+	HasState() (bool, error)
+	IsLeader() bool
 }
 
 type MembershipHandler struct {
-	raft *innerraft.Raft
+	partitions *innerraft.Partitions
+}
+
+func NewMembershipHandler(p *innerraft.Partitions) *MembershipHandler {
+	return &MembershipHandler{partitions: p}
 }
 
-func NewMembershipHandler(r *innerraft.Raft) *MembershipHandler {
-	return &MembershipHandler{raft: r}
+// Join adds the server to the raft group of every partition this node leads
+// as a voter, or as a nonvoter that replicates the log without counting
+// towards the quorum. Voters are only added to partitions with fewer than
+// ReplicationFactor voters, and otherwise only join PolicyPartition, as
+// nonvoters, to apply the acl policy. It returns raft.ErrNotLeader when this
+// node leads no partition.
+func (h *MembershipHandler) Join(id, addr string, voter bool) error {
+	rf := h.partitions.ReplicationFactor()
+	return h.eachLed(func(partition uint32, r *innerraft.Raft) error {
+		return join(r, id, addr, voter, rf, partition == innerraft.PolicyPartition)
+	})
 }
 
-func (h *MembershipHandler) Join(id, addr string) error {
-	configFuture := h.raft.GetConfiguration()
+func join(r *innerraft.Raft, id, addr string, voter bool, rf int, policy bool) error {
+	configFuture := r.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
 		return err
 	}
 	serverID := raft.ServerID(id)
 	serverAddr := raft.ServerAddress(addr)
+	voters := 0
 	for _, srv := range configFuture.Configuration().Servers {
 		if (srv.ID == serverID && srv.Address != serverAddr) || (srv.ID != serverID && srv.Address == serverAddr) {
 			// remove the existing server
-			removeFuture := h.raft.RemoveServer(serverID, 0, 0)
+			removeFuture := r.RemoveServer(serverID, 0, 0)
 			if err := removeFuture.Error(); err != nil {
 				return err
 			}
+			continue
+		}
+		if srv.Suffrage == raft.Voter && srv.ID != serverID {
+			voters++
+		}
+	}
+	member := false
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == serverID && srv.Address == serverAddr {
+			if srv.Suffrage == raft.Voter && !voter {
+				// AddNonvoter keeps an existing voter's vote
+				return r.DemoteVoter(serverID, 0, 0).Error()
+			}
+			if (srv.Suffrage == raft.Voter) == voter {
+				return nil
+			}
+			member = true
+		}
+	}
+	if voter && rf > 0 && voters >= rf {
+		// the partition is fully replicated, e.g. while a replica moves here
+		if !policy || member {
+			return nil
 		}
+		voter = false
+	}
+	var addFuture raft.IndexFuture
+	if voter {
+		addFuture = r.AddVoter(serverID, serverAddr, 0, 0)
+	} else {
+		addFuture = r.AddNonvoter(serverID, serverAddr, 0, 0)
 	}
-	addFuture := h.raft.AddVoter(serverID, serverAddr, 0, 0)
 	if err := addFuture.Error(); err != nil {
 		return err
 	}
@@ -43,6 +93,52 @@ func (h *MembershipHandler) Join(id, addr string) error {
 }
 
 func (h *MembershipHandler) Leave(id string) error {
-	removeFuture := h.raft.RemoveServer(raft.ServerID(id), 0, 0)
-	return removeFuture.Error()
+	return h.eachLed(func(_ uint32, r *innerraft.Raft) error {
+		return r.RemoveServer(raft.ServerID(id), 0, 0).Error()
+	})
+}
+
+// eachLed calls fn with every partition this node leads.
+func (h *MembershipHandler) eachLed(fn func(partition uint32, r *innerraft.Raft) error) error {
+	led := false
+	for id, r := range h.partitions.All() {
+		if r.State() != raft.Leader {
+			continue
+		}
+		led = true
+		if err := fn(uint32(id), r); err != nil {
+			return err
+		}
+	}
+	if !led {
+		return raft.ErrNotLeader
+	}
+	return nil
+}
+
+func (h *MembershipHandler) Bootstrap(voters map[string]string) error {
+	servers := make([]raft.Server, 0, len(voters))
+	for id, addr := range voters {
+		servers = append(servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(addr),
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+	return h.partitions.Bootstrap(servers)
+}
+
+func (h *MembershipHandler) HasState() (bool, error) {
+	return h.partitions.HasExistingState()
+}
+
+// IsLeader reports whether this node leads any partition.
+func (h *MembershipHandler) IsLeader() bool {
+	for _, r := range h.partitions.All() {
+		if r.State() == raft.Leader {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,40 @@
+package raftapp
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	innertls "github.com/travisjeffery/proglog/internal/tls"
+)
+
+// IPeers calls the admin service of other servers.
+type IPeers interface {
+	Admin(ctx context.Context, addr string, fn func(pb.AdminClient) error) error
+}
+
+// Peers dials other servers with the peer TLS config raft uses, so the peer
+// certificate needs the admin action.
+type Peers struct {
+	dialOpts []grpc.DialOption
+}
+
+func NewPeers(cfg innertls.Config) *Peers {
+	dialOpt := grpc.WithInsecure()
+	if cfg.PeerTLSConfig != nil {
+		dialOpt = grpc.WithTransportCredentials(innertls.NewClientCredentials(cfg.PeerTLSConfig))
+	}
+	return &Peers{dialOpts: []grpc.DialOption{dialOpt}}
+}
+
+// Admin calls fn with a client of the admin service at addr and closes the
+// connection once fn returns.
+func (p *Peers) Admin(ctx context.Context, addr string, fn func(pb.AdminClient) error) error {
+	conn, err := grpc.DialContext(ctx, addr, p.dialOpts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(pb.NewAdminClient(conn))
+}
@@ -2,9 +2,13 @@ package raftapp
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"fmt"
+	"sync"
 	"time"
 
+	hraft "github.com/hashicorp/raft"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/travisjeffery/proglog/internal/log"
@@ -12,14 +16,31 @@ import (
 	"github.com/travisjeffery/proglog/internal/raft"
 )
 
+// IResources looks up the resource of a partition.
+type IResources interface {
+	Resource(partition uint32) (IResource, error)
+}
+
 type IResource interface {
 	Append(*pb.Record) (uint64, error)
+	AppendAsync(*pb.Record, pb.Acks) AppendFuture
+	AppendBatchAsync([]*pb.Record, pb.Acks) AppendFuture
 	Read(uint64) (*pb.Record, error)
+	ReadRange(offset uint64, maxRecords int, maxBytes uint64) ([]*pb.Record, uint64, error)
+	Wait(context.Context, uint64) error
+}
+
+// AppendFuture is the pending result of AppendAsync. Offset blocks until
+// the record reaches the requested acks level; the returned offset is only
+// known for pb.Acks_ACKS_QUORUM and is zero otherwise.
+type AppendFuture interface {
+	Offset() (uint64, error)
 }
 
 type Resource struct {
-	log  *log.Log
-	raft *raft.Raft
+	log     *log.Log
+	raft    *raft.Raft
+	applied completions
 }
 
 func NewResource(l *log.Log, r *raft.Raft) *Resource {
@@ -29,20 +50,76 @@ func NewResource(l *log.Log, r *raft.Raft) *Resource {
 	}
 }
 
-func (r *Resource) Append(record *pb.Record) (uint64, error) {
-	res, err := r.apply(raft.AppendRequestType, &pb.ProduceRequest{Record: record})
-	if err != nil {
-		return 0, err
+type Resources struct {
+	resources []*Resource
+}
+
+func NewResources(p *raft.Partitions) *Resources {
+	resources := make([]*Resource, 0, p.Count())
+	for _, r := range p.All() {
+		resources = append(resources, NewResource(r.Log(), r))
 	}
-	rs, ok := res.(*pb.ProduceResponse)
-	if !ok {
-		return 0, fmt.Errorf("failed to cast response %v", res)
+	return &Resources{resources: resources}
+}
+
+// Resource returns the partition's resource, or raft.ErrNotReplica when this
+// node doesn't replicate the partition.
+func (r *Resources) Resource(partition uint32) (IResource, error) {
+	if int(partition) >= len(r.resources) {
+		return nil, fmt.Errorf("%w: %d", raft.ErrUnknownPartition, partition)
+	}
+	resource := r.resources[partition]
+	if !resource.raft.IsReplica() {
+		return nil, fmt.Errorf("%w: %d", raft.ErrNotReplica, partition)
 	}
+	return resource, nil
+}
 
-	return rs.Offset, nil
+func (r *Resource) Append(record *pb.Record) (uint64, error) {
+	return r.AppendAsync(record, pb.Acks_ACKS_QUORUM).Offset()
+}
+
+// AppendAsync hands the record to raft without waiting for it to commit.
+// Records are committed in the order AppendAsync is called.
+func (r *Resource) AppendAsync(record *pb.Record, acks pb.Acks) AppendFuture {
+	return r.appendAsync(raft.AppendRequestType, &pb.ProduceRequest{Record: record}, acks)
+}
+
+// AppendBatchAsync is AppendAsync for records raft commits as one command,
+// at consecutive offsets. The future's offset is the first record's, and its
+// error is a raft.PartialAppendError when only some records were appended.
+func (r *Resource) AppendBatchAsync(records []*pb.Record, acks pb.Acks) AppendFuture {
+	return r.appendAsync(raft.AppendBatchRequestType, &pb.ProduceBatch{Records: records}, acks)
 }
 
-func (r *Resource) apply(reqType raft.RequestType, req proto.Message) (interface{}, error) {
+func (r *Resource) appendAsync(reqType raft.RequestType, req proto.Message, acks pb.Acks) AppendFuture {
+	//nolint:exhaustive //reason: quorum is the default
+	switch acks {
+	case pb.Acks_ACKS_NONE:
+		_, err := r.apply(reqType, req, nil)
+		return &appendFuture{err: err}
+	case pb.Acks_ACKS_LEADER:
+		// tag the raft log so the log store can tell us when it is stored
+		ext := make([]byte, 16)
+		if _, err := rand.Read(ext); err != nil {
+			return &appendFuture{err: err}
+		}
+		stored, cancel := r.raft.NotifyStored(ext)
+		future, err := r.apply(reqType, req, ext)
+		if err != nil {
+			cancel()
+			return &appendFuture{err: err}
+		}
+		f := &appendFuture{future: future, stored: stored, cancel: cancel, done: make(chan error, 1)}
+		r.applied.add(f)
+		return f
+	default:
+		future, err := r.apply(reqType, req, nil)
+		return &appendFuture{future: future, err: err}
+	}
+}
+
+func (r *Resource) apply(reqType raft.RequestType, req proto.Message, ext []byte) (hraft.ApplyFuture, error) {
 	var buf bytes.Buffer
 	_, err := buf.Write([]byte{byte(reqType)})
 	if err != nil {
@@ -57,17 +134,102 @@ func (r *Resource) apply(reqType raft.RequestType, req proto.Message) (interface
 		return nil, err
 	}
 	timeout := 10 * time.Second
-	future := r.raft.Apply(buf.Bytes(), timeout)
-	if future.Error() != nil {
-		return nil, future.Error()
+	return r.raft.ApplyLog(hraft.Log{Data: buf.Bytes(), Extensions: ext}, timeout), nil
+}
+
+func (r *Resource) Read(offset uint64) (*pb.Record, error) {
+	return r.log.Read(offset)
+}
+
+func (r *Resource) ReadRange(offset uint64, maxRecords int, maxBytes uint64) ([]*pb.Record, uint64, error) {
+	return r.log.ReadRange(offset, maxRecords, maxBytes)
+}
+
+func (r *Resource) Wait(ctx context.Context, offset uint64) error {
+	return r.log.Wait(ctx, offset)
+}
+
+type appendFuture struct {
+	future hraft.ApplyFuture
+	err    error
+	stored <-chan struct{}
+	cancel func()
+	// done receives the future's error once raft has finished with it.
+	done chan error
+}
+
+func (f *appendFuture) Offset() (uint64, error) {
+	if f.err != nil || f.future == nil {
+		return 0, f.err
+	}
+	if f.stored != nil {
+		return f.leaderOffset()
+	}
+	return f.quorumOffset()
+}
+
+// leaderOffset returns once the leader has stored the record or raft has
+// finished with it, whichever happens first.
+func (f *appendFuture) leaderOffset() (uint64, error) {
+	defer f.cancel()
+	select {
+	case <-f.stored:
+		return 0, nil
+	case err := <-f.done:
+		if err != nil {
+			return 0, err
+		}
+		return f.quorumOffset()
+	}
+}
+
+func (f *appendFuture) quorumOffset() (uint64, error) {
+	if err := f.future.Error(); err != nil {
+		return 0, err
 	}
-	res := future.Response()
+	res := f.future.Response()
 	if err, ok := res.(error); ok {
-		return nil, err
+		return 0, err
+	}
+	rs, ok := res.(*pb.ProduceResponse)
+	if !ok {
+		return 0, fmt.Errorf("failed to cast response %v", res)
 	}
-	return res, nil
+	return rs.Offset, nil
 }
 
-func (r *Resource) Read(offset uint64) (*pb.Record, error) {
-	return r.log.Read(offset)
+// completions waits on the futures of a resource's ACKS_LEADER appends so
+// that they can be selected on along with the stored notification. Raft
+// finishes with them in the order they were applied, so a single goroutine
+// waits on them one after the other, running only while any are pending.
+type completions struct {
+	mu      sync.Mutex
+	pending []*appendFuture
+	running bool
+}
+
+func (c *completions) add(f *appendFuture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, f)
+	if !c.running {
+		c.running = true
+		go c.run()
+	}
+}
+
+func (c *completions) run() {
+	for {
+		c.mu.Lock()
+		if len(c.pending) == 0 {
+			c.running = false
+			c.mu.Unlock()
+			return
+		}
+		f := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+		f.done <- f.future.Error()
+	}
 }
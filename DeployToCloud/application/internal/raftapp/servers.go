@@ -1,34 +1,133 @@
 package raftapp
 
 import (
+	"context"
+
+	"github.com/hashicorp/raft"
+	"go.uber.org/zap"
+
 	pb "github.com/travisjeffery/proglog/internal/proto/v1"
-	"github.com/travisjeffery/proglog/internal/raft"
+	innerraft "github.com/travisjeffery/proglog/internal/raft"
 )
 
 type IServers interface {
-	GetServers() ([]*pb.Server, error)
+	GetServers(ctx context.Context) ([]*pb.Server, error)
+	Partitions() uint32
 }
 
 type Servers struct {
-	raft *raft.Raft
+	partitions *innerraft.Partitions
+	peers      IPeers
+	logger     *zap.Logger
 }
 
-func NewGetServers(r *raft.Raft) *Servers {
-	return &Servers{raft: r}
+func NewGetServers(p *innerraft.Partitions, peers IPeers) *Servers {
+	return &Servers{partitions: p, peers: peers, logger: zap.L().Named("servers")}
 }
 
-func (s *Servers) GetServers() ([]*pb.Server, error) {
-	future := s.raft.GetConfiguration()
-	if err := future.Error(); err != nil {
-		return nil, err
+// GetServers returns the servers of every partition's raft group along with
+// the partitions each leads and replicates. A node only knows the raft
+// configuration of the partitions it replicates, so the others are described
+// by their leaders, and left out when those can't be reached. Suffrage is
+// voter when the server is a voter of any partition.
+func (s *Servers) GetServers(ctx context.Context) ([]*pb.Server, error) {
+	v := &serversView{byID: map[string]*pb.Server{}, described: map[uint32]bool{}}
+	for id, r := range s.partitions.All() {
+		if !r.IsReplica() {
+			continue
+		}
+		future := r.GetConfiguration()
+		if err := future.Error(); err != nil {
+			return nil, err
+		}
+		leaderAddr, leaderID := r.LeaderWithID()
+		replicas := make([]*pb.Server, 0, len(future.Configuration().Servers))
+		for _, server := range future.Configuration().Servers {
+			replicas = append(replicas, &pb.Server{
+				Id:       string(server.ID),
+				RpcAddr:  string(server.Address),
+				Suffrage: toSuffrage(server.Suffrage),
+			})
+		}
+		if leaderAddr == "" {
+			leaderID = ""
+		}
+		v.add(uint32(id), replicas, string(leaderID))
 	}
-	servers := make([]*pb.Server, 0, len(future.Configuration().Servers))
-	for _, server := range future.Configuration().Servers {
-		servers = append(servers, &pb.Server{
-			Id:       string(server.ID),
-			RpcAddr:  string(server.Address),
-			IsLeader: s.raft.Leader() == server.Address,
+	if len(v.described) < s.partitions.Count() {
+		s.describePeers(ctx, v)
+	}
+	return v.servers, nil
+}
+
+// describePeers adds the partitions this node doesn't replicate as their
+// leaders describe them.
+func (s *Servers) describePeers(ctx context.Context, v *serversView) {
+	for _, peer := range append([]*pb.Server(nil), v.servers...) {
+		err := s.peers.Admin(ctx, peer.RpcAddr, func(client pb.AdminClient) error {
+			res, err := client.DescribePartitions(ctx, &pb.DescribePartitionsRequest{})
+			if err != nil {
+				return err
+			}
+			for _, partition := range res.Partitions {
+				if !v.described[partition.Partition] {
+					v.add(partition.Partition, partition.Replicas, partition.LeaderId)
+				}
+			}
+			return nil
 		})
+		if err != nil {
+			s.logger.Warn("failed to describe peer's partitions",
+				zap.String("peer", peer.Id), zap.Error(err))
+		}
+		if len(v.described) == s.partitions.Count() {
+			return
+		}
+	}
+}
+
+// serversView merges the replicas of each partition into the servers.
+type serversView struct {
+	servers   []*pb.Server
+	byID      map[string]*pb.Server
+	described map[uint32]bool
+}
+
+func (v *serversView) add(partition uint32, replicas []*pb.Server, leaderID string) {
+	v.described[partition] = true
+	for _, replica := range replicas {
+		srv, ok := v.byID[replica.Id]
+		if !ok {
+			srv = &pb.Server{Id: replica.Id, RpcAddr: replica.RpcAddr, Suffrage: replica.Suffrage}
+			v.byID[replica.Id] = srv
+			v.servers = append(v.servers, srv)
+		}
+		if replica.Suffrage == pb.Suffrage_SUFFRAGE_NONVOTER {
+			srv.NonvoterPartitions = append(srv.NonvoterPartitions, partition)
+		} else {
+			srv.VoterPartitions = append(srv.VoterPartitions, partition)
+		}
+		if replica.Suffrage == pb.Suffrage_SUFFRAGE_VOTER {
+			srv.Suffrage = replica.Suffrage
+		}
+		if replica.Id == leaderID {
+			srv.LeaderPartitions = append(srv.LeaderPartitions, partition)
+			srv.IsLeader = srv.IsLeader || partition == 0
+		}
+	}
+}
+
+func (s *Servers) Partitions() uint32 {
+	return uint32(s.partitions.Count())
+}
+
+func toSuffrage(s raft.ServerSuffrage) pb.Suffrage {
+	switch s {
+	case raft.Nonvoter:
+		return pb.Suffrage_SUFFRAGE_NONVOTER
+	case raft.Staging:
+		return pb.Suffrage_SUFFRAGE_STAGING
+	default:
+		return pb.Suffrage_SUFFRAGE_VOTER
 	}
-	return servers, nil
 }
@@ -0,0 +1,186 @@
+package rebalance
+
+import (
+	"sort"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+)
+
+// Member is a voter the partitions may be placed on.
+type Member struct {
+	ID      string
+	RPCAddr string
+}
+
+// Plan returns the moves that even out the voting replicas and then the
+// leaders of the partitions across members, so that no member has more than
+// one of either above another. Replicas are preferably moved off followers,
+// and a partition whose leader moves away keeps no planned leader. Replicas
+// on servers that aren't members are left alone.
+func Plan(members []Member, partitions []*pb.PartitionReplicas) []*pb.Move {
+	p := newPlanner(members, partitions)
+	moves := p.planReplicas()
+	return append(moves, p.planLeaders()...)
+}
+
+type planner struct {
+	members    []Member
+	addrs      map[string]string
+	partitions []*placement
+}
+
+type placement struct {
+	id     uint32
+	leader string
+	voters map[string]bool
+}
+
+func newPlanner(members []Member, partitions []*pb.PartitionReplicas) *planner {
+	p := &planner{addrs: map[string]string{}}
+	for _, member := range members {
+		p.addrs[member.ID] = member.RPCAddr
+		p.members = append(p.members, member)
+	}
+	sort.Slice(p.members, func(i, j int) bool {
+		return p.members[i].ID < p.members[j].ID
+	})
+	for _, partition := range partitions {
+		pl := &placement{
+			id:     partition.Partition,
+			leader: partition.LeaderId,
+			voters: map[string]bool{},
+		}
+		for _, replica := range partition.Replicas {
+			if replica.Suffrage == pb.Suffrage_SUFFRAGE_VOTER {
+				pl.voters[replica.Id] = true
+			}
+		}
+		p.partitions = append(p.partitions, pl)
+	}
+	sort.Slice(p.partitions, func(i, j int) bool {
+		return p.partitions[i].id < p.partitions[j].id
+	})
+	return p
+}
+
+// byCount returns the member ids ordered by count, fewest first.
+func (p *planner) byCount(count func(id string) int) []string {
+	ids := make([]string, 0, len(p.members))
+	for _, member := range p.members {
+		ids = append(ids, member.ID)
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return count(ids[i]) < count(ids[j])
+	})
+	return ids
+}
+
+func (p *planner) replicaCount(id string) int {
+	n := 0
+	for _, pl := range p.partitions {
+		if pl.voters[id] {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *planner) leaderCount(id string) int {
+	n := 0
+	for _, pl := range p.partitions {
+		if pl.leader == id {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *planner) planReplicas() []*pb.Move {
+	var moves []*pb.Move
+	if len(p.members) < 2 {
+		return nil
+	}
+	for {
+		ids := p.byCount(p.replicaCount)
+		to, from := ids[0], ids[len(ids)-1]
+		if p.replicaCount(from)-p.replicaCount(to) <= 1 {
+			return moves
+		}
+		pl := p.replicaToMove(from, to)
+		if pl == nil {
+			return moves
+		}
+		delete(pl.voters, from)
+		pl.voters[to] = true
+		if pl.leader == from {
+			pl.leader = ""
+		}
+		moves = append(moves, &pb.Move{
+			Partition: pl.id,
+			FromId:    from,
+			ToId:      to,
+			ToRpcAddr: p.addrs[to],
+		})
+	}
+}
+
+// replicaToMove picks a partition from is a voter of and to isn't,
+// preferring one from doesn't lead. to may be a nonvoter of the partition,
+// like every node of PolicyPartition.
+func (p *planner) replicaToMove(from, to string) *placement {
+	var led *placement
+	for _, pl := range p.partitions {
+		if !pl.voters[from] || pl.voters[to] {
+			continue
+		}
+		if pl.leader != from {
+			return pl
+		}
+		if led == nil {
+			led = pl
+		}
+	}
+	return led
+}
+
+func (p *planner) planLeaders() []*pb.Move {
+	var moves []*pb.Move
+	for {
+		move := p.leaderToMove()
+		if move == nil {
+			return moves
+		}
+		moves = append(moves, move)
+	}
+}
+
+// leaderToMove hands a partition from the member leading the most partitions
+// to one of its replicas leading at least two fewer.
+func (p *planner) leaderToMove() *pb.Move {
+	ids := p.byCount(p.leaderCount)
+	for i := len(ids) - 1; i >= 0; i-- {
+		from := ids[i]
+		for _, pl := range p.partitions {
+			if pl.leader != from {
+				continue
+			}
+			for _, to := range ids {
+				if p.leaderCount(from)-p.leaderCount(to) <= 1 {
+					break
+				}
+				if !pl.voters[to] {
+					continue
+				}
+				pl.leader = to
+				return &pb.Move{
+					Partition:  pl.id,
+					FromId:     from,
+					ToId:       to,
+					ToRpcAddr:  p.addrs[to],
+					Leadership: true,
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package rebalance_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	. "github.com/travisjeffery/proglog/internal/rebalance"
+)
+
+func TestPlan(t *testing.T) {
+	members := []Member{
+		{ID: "a", RPCAddr: "a:1"},
+		{ID: "b", RPCAddr: "b:1"},
+		{ID: "c", RPCAddr: "c:1"},
+	}
+	for scenario, tc := range map[string]struct {
+		partitions []*pb.PartitionReplicas
+		want       []*pb.Move
+	}{
+		"balanced cluster needs no moves": {
+			partitions: []*pb.PartitionReplicas{
+				partition(0, "a", "a", "b"),
+				partition(1, "b", "b", "c"),
+				partition(2, "c", "c", "a"),
+			},
+		},
+		"replicas and leaders move onto a new member": {
+			partitions: []*pb.PartitionReplicas{
+				partition(0, "a", "a", "b"),
+				partition(1, "b", "b", "a"),
+				partition(2, "a", "a", "b"),
+				partition(3, "b", "b", "a"),
+			},
+			want: []*pb.Move{
+				{Partition: 0, FromId: "b", ToId: "c", ToRpcAddr: "c:1"},
+				{Partition: 1, FromId: "a", ToId: "c", ToRpcAddr: "c:1"},
+				{Partition: 1, FromId: "b", ToId: "c", ToRpcAddr: "c:1", Leadership: true},
+			},
+		},
+		"leaders spread over replicas": {
+			partitions: []*pb.PartitionReplicas{
+				partition(0, "a", "a", "b", "c"),
+				partition(1, "a", "a", "b", "c"),
+				partition(2, "a", "a", "b", "c"),
+			},
+			want: []*pb.Move{
+				{Partition: 0, FromId: "a", ToId: "b", ToRpcAddr: "b:1", Leadership: true},
+				{Partition: 1, FromId: "a", ToId: "c", ToRpcAddr: "c:1", Leadership: true},
+			},
+		},
+		"nonvoters of a partition can become its voters": {
+			partitions: []*pb.PartitionReplicas{
+				withNonvoters(partition(0, "a", "a", "b"), "c"),
+				partition(1, "b", "b", "a"),
+			},
+			want: []*pb.Move{
+				{Partition: 0, FromId: "b", ToId: "c", ToRpcAddr: "c:1"},
+			},
+		},
+		"replicas on departed servers are left alone": {
+			partitions: []*pb.PartitionReplicas{
+				partition(0, "a", "a", "d"),
+				partition(1, "b", "b", "d"),
+				partition(2, "c", "c", "d"),
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, tc.want, Plan(members, tc.partitions))
+		})
+	}
+}
+
+// partition builds a partition replicated by voters and led by leader.
+func partition(id uint32, leader string, voters ...string) *pb.PartitionReplicas {
+	p := &pb.PartitionReplicas{Partition: id, LeaderId: leader}
+	for _, voter := range voters {
+		p.Replicas = append(p.Replicas, &pb.Server{Id: voter, RpcAddr: voter + ":1"})
+	}
+	return p
+}
+
+func withNonvoters(p *pb.PartitionReplicas, nonvoters ...string) *pb.PartitionReplicas {
+	for _, nonvoter := range nonvoters {
+		p.Replicas = append(p.Replicas, &pb.Server{
+			Id:       nonvoter,
+			RpcAddr:  nonvoter + ":1",
+			Suffrage: pb.Suffrage_SUFFRAGE_NONVOTER,
+		})
+	}
+	return p
+}
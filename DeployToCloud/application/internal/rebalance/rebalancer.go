@@ -0,0 +1,147 @@
+package rebalance
+
+import (
+	"context"
+
+	"github.com/hashicorp/serf/serf"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/membership"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	"github.com/travisjeffery/proglog/internal/raftapp"
+)
+
+type IRebalancer interface {
+	// Rebalance plans the moves that even out replicas and leaders across
+	// the members and carries them out unless dryRun is set.
+	Rebalance(ctx context.Context, dryRun bool) ([]*pb.Move, error)
+}
+
+type Rebalancer struct {
+	membership *membership.Membership
+	peers      raftapp.IPeers
+	logger     *zap.Logger
+}
+
+func NewRebalancer(m *membership.Membership, peers raftapp.IPeers) *Rebalancer {
+	return &Rebalancer{
+		membership: m,
+		peers:      peers,
+		logger:     zap.L().Named("rebalancer"),
+	}
+}
+
+func (r *Rebalancer) Rebalance(ctx context.Context, dryRun bool) ([]*pb.Move, error) {
+	members := r.members()
+	partitions, err := r.describe(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+	moves := Plan(members, partitions)
+	if dryRun {
+		return moves, nil
+	}
+	addrs := map[string]string{}
+	for _, member := range members {
+		addrs[member.ID] = member.RPCAddr
+	}
+	leaders := toLeaders(partitions)
+	for _, move := range moves {
+		r.logger.Info("moving partition",
+			zap.Uint32("partition", move.Partition),
+			zap.String("from", move.FromId),
+			zap.String("to", move.ToId),
+			zap.Bool("leadership", move.Leadership))
+		if leaders[move.Partition] == "" {
+			// an earlier move took the replica of the leader away
+			if leaders, err = r.leaders(ctx, members); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.move(ctx, addrs[leaders[move.Partition]], move); err != nil {
+			return nil, err
+		}
+		switch {
+		case move.Leadership:
+			leaders[move.Partition] = move.ToId
+		case leaders[move.Partition] == move.FromId:
+			leaders[move.Partition] = ""
+		}
+	}
+	return moves, nil
+}
+
+// members returns the alive voters.
+func (r *Rebalancer) members() []Member {
+	var members []Member
+	for _, member := range r.membership.Members() {
+		if member.Status != serf.StatusAlive || member.Tags[membership.RoleTag] == membership.RoleNonvoter {
+			continue
+		}
+		members = append(members, Member{ID: member.Name, RPCAddr: member.Tags[membership.RPCAddrTag]})
+	}
+	return members
+}
+
+// describe asks every member for the replicas of the partitions it leads.
+// Partitions without a leader are left out of the plan.
+func (r *Rebalancer) describe(ctx context.Context, members []Member) ([]*pb.PartitionReplicas, error) {
+	var partitions []*pb.PartitionReplicas
+	seen := map[uint32]bool{}
+	for _, member := range members {
+		err := r.peers.Admin(ctx, member.RPCAddr, func(client pb.AdminClient) error {
+			res, err := client.DescribePartitions(ctx, &pb.DescribePartitionsRequest{})
+			if err != nil {
+				return err
+			}
+			for _, partition := range res.Partitions {
+				// a deposed leader may still report the partition
+				if !seen[partition.Partition] {
+					seen[partition.Partition] = true
+					partitions = append(partitions, partition)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return partitions, nil
+}
+
+func (r *Rebalancer) leaders(ctx context.Context, members []Member) (map[uint32]string, error) {
+	partitions, err := r.describe(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+	return toLeaders(partitions), nil
+}
+
+func toLeaders(partitions []*pb.PartitionReplicas) map[uint32]string {
+	leaders := map[uint32]string{}
+	for _, partition := range partitions {
+		leaders[partition.Partition] = partition.LeaderId
+	}
+	return leaders
+}
+
+func (r *Rebalancer) move(ctx context.Context, leaderAddr string, move *pb.Move) error {
+	return r.peers.Admin(ctx, leaderAddr, func(client pb.AdminClient) error {
+		if move.Leadership {
+			_, err := client.TransferLeadership(ctx, &pb.TransferLeadershipRequest{
+				Id:        move.ToId,
+				RpcAddr:   move.ToRpcAddr,
+				Partition: move.Partition,
+			})
+			return err
+		}
+		_, err := client.MovePartition(ctx, &pb.MovePartitionRequest{
+			Partition: move.Partition,
+			FromId:    move.FromId,
+			ToId:      move.ToId,
+			ToRpcAddr: move.ToRpcAddr,
+		})
+		return err
+	})
+}
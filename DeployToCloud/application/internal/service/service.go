@@ -2,20 +2,29 @@ package service
 
 import (
 	"sync"
+	"time"
 
+	hraft "github.com/hashicorp/raft"
 	"github.com/soheilhy/cmux"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
+	"github.com/travisjeffery/proglog/internal/audit"
+	"github.com/travisjeffery/proglog/internal/grpc/server"
 	"github.com/travisjeffery/proglog/internal/membership"
 	"github.com/travisjeffery/proglog/internal/raft"
 )
 
+const defaultDrainTimeout = 10 * time.Second
+
 type Service struct {
-	mux        cmux.CMux
-	raft       *raft.Raft
-	server     *grpc.Server
-	membership *membership.Membership
+	mux          cmux.CMux
+	partitions   *raft.Partitions
+	server       *grpc.Server
+	membership   *membership.Membership
+	drain        *server.Drain
+	auditor      audit.IAuditor
+	drainTimeout time.Duration
 
 	shutdown     bool
 	shutdowns    chan struct{}
@@ -24,14 +33,26 @@ type Service struct {
 	logger *zap.Logger
 }
 
-func NewService(m cmux.CMux, r *raft.Raft, server *grpc.Server, mb *membership.Membership) *Service {
+type Args struct {
+	// DrainTimeout bounds how long Shutdown waits for in-flight RPCs before
+	// closing connections.
+	DrainTimeout time.Duration
+}
+
+func NewService(m cmux.CMux, p *raft.Partitions, srv *grpc.Server, mb *membership.Membership, drain *server.Drain, auditor audit.IAuditor, args Args) *Service {
+	if args.DrainTimeout <= 0 {
+		args.DrainTimeout = defaultDrainTimeout
+	}
 	return &Service{
-		mux:        m,
-		raft:       r,
-		server:     server,
-		membership: mb,
-		shutdowns:  make(chan struct{}),
-		logger:     zap.L().Named("service"),
+		mux:          m,
+		partitions:   p,
+		server:       srv,
+		membership:   mb,
+		drain:        drain,
+		auditor:      auditor,
+		drainTimeout: args.DrainTimeout,
+		shutdowns:    make(chan struct{}),
+		logger:       zap.L().Named("service"),
 	}
 }
 
@@ -58,9 +79,41 @@ func (s *Service) Shutdown() error {
 	s.shutdown = true
 	close(s.shutdowns)
 
+	// hand leadership over first so the cluster doesn't wait for elections
+	for id, r := range s.partitions.All() {
+		if r.State() != hraft.Leader {
+			continue
+		}
+		if err := r.LeadershipTransfer().Error(); err != nil {
+			s.logger.Warn("failed to transfer leadership", zap.Int("partition", id), zap.Error(err))
+		}
+	}
+	s.drain.Start()
 	if err := s.membership.Leave(); err != nil {
 		return err
 	}
-	s.server.GracefulStop()
-	return s.raft.Close()
+	s.stopServer()
+	if s.auditor != nil {
+		if err := s.auditor.Close(); err != nil {
+			s.logger.Warn("failed to close audit log", zap.Error(err))
+		}
+	}
+	return s.partitions.Close()
+}
+
+// stopServer waits up to the drain timeout for in-flight RPCs to finish and
+// then closes the remaining connections.
+func (s *Service) stopServer() {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(s.drainTimeout):
+		s.logger.Warn("drain timed out, closing connections")
+		s.server.Stop()
+		<-stopped
+	}
 }
@@ -1,101 +1,938 @@
 package service_test
 
-// func TestService(t *testing.T) {
-// 	var services []*service.Service
-//
-// 	serverTLSConfig, err := tls.SetupTLS(tls.Args{
-// 		CertFile:      tls.ServerCertFile,
-// 		KeyFile:       tls.ServerKeyFile,
-// 		CAFile:        tls.CAFile,
-// 		Server:        true,
-// 		ServerAddress: "127.0.0.1",
-// 	})
-// 	require.NoError(t, err)
-//
-// 	peerTLSConfig, err := tls.SetupTLS(tls.Args{
-// 		CertFile:      tls.RootClientCertFile,
-// 		KeyFile:       tls.RootClientKeyFile,
-// 		CAFile:        tls.CAFile,
-// 		Server:        false,
-// 		ServerAddress: "127.0.0.1",
-// 	})
-// 	require.NoError(t, err)
-//
-// 	for i := 0; i < 3; i++ {
-// 		ports := dynaport.Get(2)
-// 		bindAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[0])
-// 		rpcPort := ports[1]
-//
-// 		dataDir, err := os.MkdirTemp("", "agent-test-log")
-// 		require.NoError(t, err)
-//
-// 		defer func(dir string) {
-// 			_ = os.RemoveAll(dir)
-// 		}(dataDir)
-//
-// 		var startJoinAddrs []string
-// 		if i != 0 {
-// 			startJoinAddrs = append(startJoinAddrs, services[0].Config.BindAddr)
-// 		}
-//
-// 		agent, err := service.NewService(service.Config{
-// 			NodeName:        fmt.Sprintf("%d", i),
-// 			Bootstrap:       i == 0,
-// 			StartJoinAddrs:  startJoinAddrs,
-// 			BindAddr:        bindAddr,
-// 			RPCPort:         rpcPort,
-// 			DataDir:         dataDir,
-// 			ACLModelFile:    tls.ACLModelFile,
-// 			ACLPolicyFile:   tls.ACLPolicyFile,
-// 			ServerTLSConfig: serverTLSConfig,
-// 			PeerTLSConfig:   peerTLSConfig,
-// 		})
-// 		require.NoError(t, err)
-//
-// 		services = append(services, agent)
-// 	}
-// 	defer func() {
-// 		for _, agent := range services {
-// 			_ = agent.Shutdown()
-// 		}
-// 	}()
-//
-// 	// wait until services have joined the cluster
-// 	time.Sleep(3 * time.Second)
-//
-// 	leaderClient := client(t, services[0], peerTLSConfig)
-// 	produceResponse, err := leaderClient.Produce(context.Background(),
-// 		&pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
-// 	require.NoError(t, err)
-//
-// 	// wait until replication has finished
-// 	time.Sleep(3 * time.Second)
-//
-// 	consumeResponse, err := leaderClient.Consume(context.Background(),
-// 		&pb.ConsumeRequest{Offset: produceResponse.Offset})
-// 	require.NoError(t, err)
-// 	require.Equal(t, consumeResponse.Record.Value, []byte("foo"))
-//
-// 	followerClient := client(t, services[1], peerTLSConfig)
-// 	consumeResponse, err = followerClient.Consume(context.Background(),
-// 		&pb.ConsumeRequest{Offset: produceResponse.Offset})
-// 	require.NoError(t, err)
-// 	require.Equal(t, consumeResponse.Record.Value, []byte("foo"))
-// }
-//
-// func client(
-// 	t *testing.T,
-// 	agent *service.Service,
-// 	tlsConfig *tls.Config,
-// ) pb.LogClient {
-// 	tlsCreds := credentials.NewTLS(tlsConfig)
-// 	opts := []grpc.DialOption{
-// 		grpc.WithTransportCredentials(tlsCreds),
-// 	}
-// 	rpcAddr, err := agent.Config.RPCAddr()
-// 	require.NoError(t, err)
-// 	conn, err := grpc.Dial(fmt.Sprintf("%s:///%s", loadbalance.Name, rpcAddr), opts...)
-// 	require.NoError(t, err)
-// 	client := pb.NewLogClient(conn)
-// 	return client
-// }
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/travisjeffery/proglog/internal/config"
+	"github.com/travisjeffery/proglog/internal/di"
+	"github.com/travisjeffery/proglog/internal/grpc/loadbalance"
+	"github.com/travisjeffery/proglog/internal/log"
+	pb "github.com/travisjeffery/proglog/internal/proto/v1"
+	"github.com/travisjeffery/proglog/internal/service"
+	innertls "github.com/travisjeffery/proglog/internal/tls"
+	"github.com/travisjeffery/proglog/test"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(test.Run(m))
+}
+
+type node struct {
+	env     *config.Env
+	service *service.Service
+}
+
+func (n *node) rpcAddr() string {
+	return fmt.Sprintf("127.0.0.1:%d", n.env.RpcPort)
+}
+
+// setupCluster starts count nodes joined through the first one and returns
+// them leader first. configure, if given, adjusts each node's env before it
+// starts.
+func setupCluster(tb testing.TB, count int, configure ...func(i int, env *config.Env)) (nodes []*node, teardown func()) {
+	tb.Helper()
+	for i := 0; i < count; i++ {
+		var joinAddrs []string
+		if i != 0 {
+			joinAddrs = []string{nodes[0].env.BindAddr}
+		}
+		nodes = append(nodes, startNode(tb, i, joinAddrs, func(env *config.Env) {
+			env.BootstrapExpect = count
+			for _, fn := range configure {
+				fn(i, env)
+			}
+		}))
+	}
+	teardown = func() {
+		for _, n := range nodes {
+			n.shutdown()
+		}
+	}
+	return leaderFirst(tb, nodes), teardown
+}
+
+// startNode starts the ith node, joining it through joinAddrs. configure
+// adjusts its env before it starts.
+func startNode(tb testing.TB, i int, joinAddrs []string, configure func(env *config.Env)) *node {
+	tb.Helper()
+	env := nodeEnv(tb, i, joinAddrs)
+	configure(env)
+	s, err := di.InitializeService(env)
+	require.NoError(tb, err)
+	s.Serve()
+	return &node{env: env, service: s}
+}
+
+// nodeEnv returns the env of the ith node, in a new data dir.
+func nodeEnv(tb testing.TB, i int, joinAddrs []string) *config.Env {
+	tb.Helper()
+	ports := dynaport.Get(2)
+	dataDir, err := os.MkdirTemp("", "service-test")
+	require.NoError(tb, err)
+
+	return &config.Env{
+		Environment:       config.Local,
+		DataDir:           dataDir,
+		NodeName:          fmt.Sprintf("proglog-%d", i),
+		RpcPort:           ports[1],
+		BindAddr:          fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		StartJoinAddrs:    joinAddrs,
+		AclModelFile:      innertls.ACLModelFile,
+		AclPolicyFile:     innertls.ACLPolicyFile,
+		ServerTLSCertFile: innertls.ServerCertFile,
+		ServerTLSKeyFile:  innertls.ServerKeyFile,
+		ServerTLSCaFile:   innertls.CAFile,
+		PeerTLSCertFile:   innertls.RootClientCertFile,
+		PeerTLSKeyFile:    innertls.RootClientKeyFile,
+		PeerTLSCaFile:     innertls.CAFile,
+		RaftPeerIdentity:  "root",
+		MaxStoreBytes:     1 << 20,
+		MaxIndexBytes:     1 << 20,
+		InitialOffset:     1,
+		BootstrapTimeout:  3 * time.Second,
+		ProduceWindow:     256,
+		BatchApplyCh:      true,
+		MaxAppendEntries:  256,
+		DrainTimeout:      5 * time.Second,
+	}
+}
+
+func (n *node) shutdown() {
+	_ = n.service.Shutdown()
+	_ = os.RemoveAll(n.env.DataDir)
+}
+
+// leaderFirst waits until every node is in the raft configuration and the
+// first node leads, and returns the nodes with the leader first. Bootstrap
+// hands partition 0's leadership to the first node once elected, so an
+// earlier leader would soon step down.
+func leaderFirst(tb testing.TB, nodes []*node) []*node {
+	tb.Helper()
+	var leaderID string
+	require.Eventually(tb, func() bool {
+		res, err := client(tb, nodes[0]).GetServers(context.Background(), &pb.GetServersRequest{})
+		if err != nil || len(res.Servers) != len(nodes) {
+			return false
+		}
+		for _, server := range res.Servers {
+			if server.IsLeader {
+				leaderID = server.Id
+			}
+		}
+		return leaderID == nodes[0].env.NodeName
+	}, 10*time.Second, 100*time.Millisecond)
+	sorted := make([]*node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.env.NodeName == leaderID {
+			sorted = append([]*node{n}, sorted...)
+		} else {
+			sorted = append(sorted, n)
+		}
+	}
+	return sorted
+}
+
+func client(tb testing.TB, n *node) pb.LogClient {
+	tb.Helper()
+	return pb.NewLogClient(dial(tb, n))
+}
+
+func adminClient(tb testing.TB, n *node) pb.AdminClient {
+	tb.Helper()
+	return pb.NewAdminClient(dial(tb, n))
+}
+
+func dial(tb testing.TB, n *node) *grpc.ClientConn {
+	tb.Helper()
+	return dialAs(tb, n, innertls.RootClientCertFile, innertls.RootClientKeyFile)
+}
+
+// dialAs connects to the node with the client certificate in certFile.
+func dialAs(tb testing.TB, n *node, certFile, keyFile string) *grpc.ClientConn {
+	tb.Helper()
+	tlsConfig, err := innertls.SetupTLS(innertls.Args{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   innertls.CAFile,
+	})
+	require.NoError(tb, err)
+	conn, err := grpc.Dial(n.rpcAddr(), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func waitForReplication(tb testing.TB, nodes []*node, offset uint64) {
+	tb.Helper()
+	require.Eventually(tb, func() bool {
+		for _, n := range nodes {
+			if _, err := client(tb, n).Consume(context.Background(), &pb.ConsumeRequest{Offset: offset}); err != nil {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestService(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3)
+	defer teardown()
+
+	leader := client(t, nodes[0])
+	var produce *pb.ProduceResponse
+	require.Eventually(t, func() bool {
+		var err error
+		produce, err = leader.Produce(context.Background(), &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	waitForReplication(t, nodes, produce.Offset)
+
+	consume, err := client(t, nodes[1]).Consume(context.Background(), &pb.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), consume.Record.Value)
+
+	// weaker acks levels don't report an offset but still replicate
+	for _, acks := range []pb.Acks{pb.Acks_ACKS_LEADER, pb.Acks_ACKS_NONE} {
+		res, err := leader.Produce(context.Background(), &pb.ProduceRequest{Record: &pb.Record{Value: []byte(acks.String())}, Acks: acks})
+		require.NoError(t, err)
+		require.Zero(t, res.Offset)
+	}
+	waitForReplication(t, nodes, produce.Offset+2)
+	consumeRange, err := client(t, nodes[2]).ConsumeRange(context.Background(), &pb.ConsumeRequest{Offset: produce.Offset + 1})
+	require.NoError(t, err)
+	require.Len(t, consumeRange.Records, 2)
+	require.Equal(t, []byte(pb.Acks_ACKS_LEADER.String()), consumeRange.Records[0].Value)
+	require.Equal(t, []byte(pb.Acks_ACKS_NONE.String()), consumeRange.Records[1].Value)
+}
+
+func TestAdmin(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3)
+	defer teardown()
+	ctx := context.Background()
+
+	produce := &pb.ProduceResponse{}
+	require.Eventually(t, func() bool {
+		var err error
+		produce, err = client(t, nodes[0]).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	waitForReplication(t, nodes, produce.Offset)
+
+	stats, err := adminClient(t, nodes[0]).RaftStats(ctx, &pb.RaftStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Leader", stats.Stats["state"])
+
+	snapshot, err := adminClient(t, nodes[0]).Snapshot(ctx, &pb.SnapshotRequest{})
+	require.NoError(t, err)
+	snapshots, err := adminClient(t, nodes[0]).ListSnapshots(ctx, &pb.ListSnapshotsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Snapshot.Id, snapshots.Snapshots[0].Id)
+
+	_, err = adminClient(t, nodes[1]).TransferLeadership(ctx, &pb.TransferLeadershipRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = adminClient(t, nodes[0]).TransferLeadership(ctx, &pb.TransferLeadershipRequest{
+		Id:      nodes[1].env.NodeName,
+		RpcAddr: nodes[1].rpcAddr(),
+	})
+	require.NoError(t, err)
+	stats, err = adminClient(t, nodes[1]).RaftStats(ctx, &pb.RaftStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Leader", stats.Stats["state"])
+
+	_, err = adminClient(t, nodes[1]).RemoveServer(ctx, &pb.RemoveServerRequest{Id: nodes[2].env.NodeName})
+	require.NoError(t, err)
+	servers, err := client(t, nodes[1]).GetServers(ctx, &pb.GetServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, servers.Servers, 2)
+}
+
+func TestACL(t *testing.T) {
+	nodes, teardown := setupCluster(t, 2)
+	defer teardown()
+	ctx := context.Background()
+	var partitions map[uint32]*pb.PartitionReplicas
+	require.Eventually(t, func() bool {
+		partitions = describePartitions(t, nodes)
+		return len(partitions) == 1
+	}, 10*time.Second, 100*time.Millisecond)
+	leader, follower := nodes[0], nodes[1]
+	if follower.env.NodeName == partitions[0].LeaderId {
+		leader, follower = follower, leader
+	}
+	produce, err := client(t, leader).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+	require.NoError(t, err)
+	waitForReplication(t, nodes, produce.Offset)
+	nobody := pb.NewLogClient(dialAs(t, follower, innertls.NobodyClientCertFile, innertls.NobodyClientKeyFile))
+	consume := &pb.ConsumeRequest{Offset: produce.Offset}
+	_, err = nobody.Consume(ctx, consume)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	policies, err := adminClient(t, follower).ListPolicies(ctx, &pb.ListPoliciesRequest{})
+	require.NoError(t, err)
+	require.False(t, policies.Replicated)
+	seed := len(policies.Policies)
+
+	// only the leader of partition 0 changes the policy
+	other := &pb.GrantRequest{Policy: &pb.Policy{Ptype: "p", Fields: []string{"nobody", "other", "consume"}}}
+	_, err = adminClient(t, follower).Grant(ctx, other)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = adminClient(t, leader).Grant(ctx, other)
+	require.NoError(t, err)
+
+	// the follower applies the seeded policy with the grant, which covers
+	// another log only
+	require.Eventually(t, func() bool {
+		policies, err = adminClient(t, follower).ListPolicies(ctx, &pb.ListPoliciesRequest{})
+		return err == nil && policies.Replicated && len(policies.Policies) == seed+1
+	}, 10*time.Second, 100*time.Millisecond)
+	_, err = nobody.Consume(ctx, consume)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	grant := &pb.GrantRequest{Policy: &pb.Policy{Ptype: "p", Fields: []string{"nobody", "proglog/0", "consume"}}}
+	_, err = adminClient(t, leader).Grant(ctx, grant)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := nobody.Consume(ctx, consume)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	_, err = adminClient(t, leader).Revoke(ctx, &pb.RevokeRequest{Policy: grant.Policy})
+	require.NoError(t, err)
+	_, err = adminClient(t, leader).Revoke(ctx, &pb.RevokeRequest{Policy: grant.Policy})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Eventually(t, func() bool {
+		_, err := nobody.Consume(ctx, consume)
+		return status.Code(err) == codes.PermissionDenied
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestACLReplicationFactor(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(_ int, env *config.Env) {
+		env.ReplicationFactor = 2
+	})
+	defer teardown()
+	ctx := context.Background()
+	var partitions map[uint32]*pb.PartitionReplicas
+	require.Eventually(t, func() bool {
+		partitions = describePartitions(t, nodes)
+		return len(partitions) == 1 && len(partitions[0].Replicas) == 3
+	}, 10*time.Second, 100*time.Millisecond)
+	byID := map[string]*node{}
+	for _, n := range nodes {
+		byID[n.env.NodeName] = n
+	}
+	leader := byID[partitions[0].LeaderId]
+	// the node outside the replication factor is a nonvoter of partition 0
+	require.Len(t, replicaIDs(partitions[0]), 2)
+
+	produce, err := client(t, leader).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+	require.NoError(t, err)
+	consume := &pb.ConsumeRequest{Offset: produce.Offset}
+	nobodies := make([]pb.LogClient, 0, len(nodes))
+	for _, n := range nodes {
+		nobodies = append(nobodies, pb.NewLogClient(dialAs(t, n, innertls.NobodyClientCertFile, innertls.NobodyClientKeyFile)))
+	}
+
+	grant := &pb.GrantRequest{Policy: &pb.Policy{Ptype: "p", Fields: []string{"nobody", "*", "consume"}}}
+	_, err = adminClient(t, leader).Grant(ctx, grant)
+	require.NoError(t, err)
+	for _, nobody := range nobodies {
+		require.Eventually(t, func() bool {
+			_, err := nobody.Consume(ctx, consume)
+			return err == nil
+		}, 10*time.Second, 100*time.Millisecond)
+	}
+
+	// a revoke takes effect on every node, not just the voters
+	_, err = adminClient(t, leader).Revoke(ctx, &pb.RevokeRequest{Policy: grant.Policy})
+	require.NoError(t, err)
+	for i, nobody := range nobodies {
+		require.Eventually(t, func() bool {
+			_, err := nobody.Consume(ctx, consume)
+			return status.Code(err) == codes.PermissionDenied
+		}, 10*time.Second, 100*time.Millisecond, nodes[i].env.NodeName)
+		policies, err := adminClient(t, nodes[i]).ListPolicies(ctx, &pb.ListPoliciesRequest{})
+		require.NoError(t, err)
+		require.True(t, policies.Replicated)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(_ int, env *config.Env) {
+		env.Partitions = 3
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	// partition leadership is spread over the voters
+	byID := map[string]*node{}
+	for _, n := range nodes {
+		byID[n.env.NodeName] = n
+	}
+	leaders := map[uint32]*node{}
+	require.Eventually(t, func() bool {
+		res, err := client(t, nodes[0]).GetServers(ctx, &pb.GetServersRequest{})
+		if err != nil || res.Partitions != 3 {
+			return false
+		}
+		for _, server := range res.Servers {
+			if len(server.LeaderPartitions) != 1 {
+				return false
+			}
+			leaders[server.LeaderPartitions[0]] = byID[server.Id]
+		}
+		return len(leaders) == 3
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// each partition is its own log
+	for partition := uint32(0); partition < 3; partition++ {
+		value := []byte(fmt.Sprintf("partition-%d", partition))
+		produce, err := client(t, leaders[partition]).Produce(ctx, &pb.ProduceRequest{
+			Record:    &pb.Record{Value: value},
+			Partition: partition,
+		})
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), produce.Offset)
+
+		for _, n := range nodes {
+			require.Eventually(t, func() bool {
+				consume, err := client(t, n).Consume(ctx, &pb.ConsumeRequest{Offset: produce.Offset, Partition: partition})
+				return err == nil && string(consume.Record.Value) == string(value)
+			}, 10*time.Second, 100*time.Millisecond)
+		}
+	}
+
+	_, err := client(t, nodes[0]).Consume(ctx, &pb.ConsumeRequest{Partition: 3})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestRebalance(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(_ int, env *config.Env) {
+		env.Partitions = 3
+		env.ReplicationFactor = 2
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	// partition i starts on proglog-i and proglog-i+1 and is led by proglog-i
+	byID := map[string]*node{}
+	for _, n := range nodes {
+		byID[n.env.NodeName] = n
+	}
+	var partitions map[uint32]*pb.PartitionReplicas
+	require.Eventually(t, func() bool {
+		partitions = describePartitions(t, nodes)
+		for id, partition := range partitions {
+			if partition.LeaderId != fmt.Sprintf("proglog-%d", id) {
+				return false
+			}
+		}
+		return len(partitions) == 3
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, []string{"proglog-0", "proglog-1"}, replicaIDs(partitions[0]))
+
+	leader := byID["proglog-0"]
+	produce, err := client(t, leader).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+	require.NoError(t, err)
+
+	// moving a replica copies the partition onto the new server
+	to := byID["proglog-2"]
+	_, err = adminClient(t, leader).MovePartition(ctx, &pb.MovePartitionRequest{
+		FromId:    "proglog-1",
+		ToId:      to.env.NodeName,
+		ToRpcAddr: to.rpcAddr(),
+	})
+	require.NoError(t, err)
+	consume, err := client(t, to).Consume(ctx, &pb.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), consume.Record.Value)
+	partitions = describePartitions(t, nodes)
+	require.Equal(t, []string{"proglog-0", "proglog-2"}, replicaIDs(partitions[0]))
+
+	// proglog-2 now has a replica of every partition and proglog-1 of one
+	_, err = adminClient(t, leader).MovePartition(ctx, &pb.MovePartitionRequest{
+		FromId:    "proglog-0",
+		ToId:      to.env.NodeName,
+		ToRpcAddr: to.rpcAddr(),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	plan, err := adminClient(t, nodes[1]).Rebalance(ctx, &pb.RebalanceRequest{DryRun: true})
+	require.NoError(t, err)
+	require.NotEmpty(t, plan.Moves)
+	require.Equal(t, partitions, describePartitions(t, nodes))
+
+	rebalance, err := adminClient(t, nodes[1]).Rebalance(ctx, &pb.RebalanceRequest{})
+	require.NoError(t, err)
+	require.Equal(t, len(plan.Moves), len(rebalance.Moves))
+	require.Eventually(t, func() bool {
+		partitions = describePartitions(t, nodes)
+		if len(partitions) != 3 {
+			return false
+		}
+		counts := map[string]int{}
+		for _, partition := range partitions {
+			for _, id := range replicaIDs(partition) {
+				counts[id]++
+			}
+		}
+		return counts["proglog-0"] == 2 && counts["proglog-1"] == 2 && counts["proglog-2"] == 2
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestGRPCTransport(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(_ int, env *config.Env) {
+		env.RaftTransport = "grpc"
+		env.Partitions = 3
+		env.ReplicationFactor = 2
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	var partitions map[uint32]*pb.PartitionReplicas
+	require.Eventually(t, func() bool {
+		partitions = describePartitions(t, nodes)
+		return len(partitions) == 3
+	}, 10*time.Second, 100*time.Millisecond)
+	byID := map[string]*node{}
+	for _, n := range nodes {
+		byID[n.env.NodeName] = n
+	}
+	leader := byID[partitions[0].LeaderId]
+	var produce *pb.ProduceResponse
+	for _, value := range []string{"foo", "bar", "baz"} {
+		var err error
+		produce, err = client(t, leader).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+
+	// move the follower's replica to the node without one
+	replicas := map[string]bool{}
+	var from string
+	for _, id := range replicaIDs(partitions[0]) {
+		replicas[id] = true
+		if id != leader.env.NodeName {
+			from = id
+		}
+	}
+	var to *node
+	for _, n := range nodes {
+		if !replicas[n.env.NodeName] {
+			to = n
+		}
+	}
+	_, err := adminClient(t, leader).MovePartition(ctx, &pb.MovePartitionRequest{
+		FromId:    from,
+		ToId:      to.env.NodeName,
+		ToRpcAddr: to.rpcAddr(),
+	})
+	require.NoError(t, err)
+
+	consume, err := client(t, to).ConsumeRange(ctx, &pb.ConsumeRequest{Offset: produce.Offset - 2})
+	require.NoError(t, err)
+	var values []string
+	for _, record := range consume.Records {
+		values = append(values, string(record.Value))
+	}
+	require.Equal(t, []string{"foo", "bar", "baz"}, values)
+}
+
+func TestReplicaRouting(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(_ int, env *config.Env) {
+		env.Partitions = 3
+		env.ReplicationFactor = 2
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	var partitions map[uint32]*pb.PartitionReplicas
+	require.Eventually(t, func() bool {
+		partitions = describePartitions(t, nodes)
+		return len(partitions) == 3
+	}, 10*time.Second, 100*time.Millisecond)
+	byID := map[string]*node{}
+	for _, n := range nodes {
+		byID[n.env.NodeName] = n
+	}
+
+	// every node reports the replicas of every partition, including the
+	// ones it doesn't replicate
+	for _, n := range nodes {
+		res, err := client(t, n).GetServers(ctx, &pb.GetServersRequest{})
+		require.NoError(t, err)
+		replicas := map[uint32][]string{}
+		for _, server := range res.Servers {
+			for _, partition := range server.VoterPartitions {
+				replicas[partition] = append(replicas[partition], server.Id)
+			}
+		}
+		for id, partition := range partitions {
+			sort.Strings(replicas[id])
+			require.Equal(t, replicaIDs(partition), replicas[id])
+		}
+	}
+
+	for id, partition := range partitions {
+		produce, err := client(t, byID[partition.LeaderId]).Produce(ctx, &pb.ProduceRequest{
+			Record:    &pb.Record{Value: []byte("foo")},
+			Partition: id,
+		})
+		require.NoError(t, err)
+
+		// the node outside the replica set rejects the partition instead of
+		// serving the log it never received
+		replicas := map[string]bool{}
+		for _, replica := range partition.Replicas {
+			replicas[replica.Id] = true
+		}
+		for _, n := range nodes {
+			if replicas[n.env.NodeName] {
+				continue
+			}
+			req := &pb.ConsumeRequest{Offset: produce.Offset, Partition: id}
+			_, err := client(t, n).Consume(ctx, req)
+			require.Equal(t, codes.FailedPrecondition, status.Code(err))
+			stream, err := client(t, n).ConsumeStream(ctx, req)
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			require.Equal(t, codes.FailedPrecondition, status.Code(err))
+		}
+
+		// the load balancer only consumes from the partition's replicas,
+		// which catch up with the leader
+		resolved := pb.NewLogClient(dialResolved(t, nodes[0]))
+		for i := 0; i < 4; i++ {
+			var consume *pb.ConsumeResponse
+			require.Eventually(t, func() bool {
+				consume, err = resolved.Consume(loadbalance.WithPartition(ctx, id), &pb.ConsumeRequest{
+					Offset:    produce.Offset,
+					Partition: id,
+				})
+				require.NotEqual(t, codes.FailedPrecondition, status.Code(err))
+				return err == nil
+			}, 10*time.Second, 100*time.Millisecond)
+			require.Equal(t, []byte("foo"), consume.Record.Value)
+		}
+	}
+}
+
+// dialResolved connects to the cluster through the proglog resolver and
+// load balancer, starting from the node.
+func dialResolved(tb testing.TB, n *node) *grpc.ClientConn {
+	tb.Helper()
+	tlsConfig, err := innertls.SetupTLS(innertls.Args{
+		CertFile: innertls.RootClientCertFile,
+		KeyFile:  innertls.RootClientKeyFile,
+		CAFile:   innertls.CAFile,
+	})
+	require.NoError(tb, err)
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", loadbalance.Name, n.rpcAddr()),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+	)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// describePartitions returns the replicas of every partition as reported by
+// its leader.
+func describePartitions(tb testing.TB, nodes []*node) map[uint32]*pb.PartitionReplicas {
+	tb.Helper()
+	partitions := map[uint32]*pb.PartitionReplicas{}
+	for _, n := range nodes {
+		res, err := adminClient(tb, n).DescribePartitions(context.Background(), &pb.DescribePartitionsRequest{})
+		require.NoError(tb, err)
+		for _, partition := range res.Partitions {
+			partitions[partition.Partition] = partition
+		}
+	}
+	return partitions
+}
+
+// replicaIDs returns the voters of the partition. With a replication factor
+// the other nodes are nonvoters of partition 0.
+func replicaIDs(partition *pb.PartitionReplicas) []string {
+	var ids []string
+	for _, replica := range partition.Replicas {
+		if replica.Suffrage == pb.Suffrage_SUFFRAGE_VOTER {
+			ids = append(ids, replica.Id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestNonvoter(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(i int, env *config.Env) {
+		env.BootstrapExpect = 2
+		if i == 2 {
+			env.Role = "nonvoter"
+		}
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	produce := &pb.ProduceResponse{}
+	require.Eventually(t, func() bool {
+		var err error
+		produce, err = client(t, nodes[0]).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	waitForReplication(t, nodes, produce.Offset)
+
+	servers, err := client(t, nodes[0]).GetServers(ctx, &pb.GetServersRequest{})
+	require.NoError(t, err)
+	suffrages := map[string]pb.Suffrage{}
+	for _, server := range servers.Servers {
+		suffrages[server.Id] = server.Suffrage
+	}
+	require.Equal(t, map[string]pb.Suffrage{
+		"proglog-0": pb.Suffrage_SUFFRAGE_VOTER,
+		"proglog-1": pb.Suffrage_SUFFRAGE_VOTER,
+		"proglog-2": pb.Suffrage_SUFFRAGE_NONVOTER,
+	}, suffrages)
+}
+
+// TestJoinAfterBootstrap joins a voter to a bootstrapped cluster. Its empty
+// raft configuration leaves the peer identity to verify its leader with.
+func TestJoinAfterBootstrap(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3, func(i int, env *config.Env) {
+		env.BootstrapExpect = 2
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	produce := &pb.ProduceResponse{}
+	require.Eventually(t, func() bool {
+		var err error
+		produce, err = client(t, nodes[0]).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	waitForReplication(t, nodes, produce.Offset)
+
+	servers, err := client(t, nodes[0]).GetServers(ctx, &pb.GetServersRequest{})
+	require.NoError(t, err)
+	for _, server := range servers.Servers {
+		require.Equal(t, pb.Suffrage_SUFFRAGE_VOTER, server.Suffrage)
+	}
+}
+
+// TestJoinWithoutBootstrapExpect starts a node with the default bootstrap
+// expect before it can reach the running cluster, as when its join addresses
+// don't resolve yet. It waits to be added instead of bootstrapping a cluster
+// of its own, which it would lead.
+func TestJoinWithoutBootstrapExpect(t *testing.T) {
+	nodes, teardown := setupCluster(t, 2)
+	defer teardown()
+	ctx := context.Background()
+	withoutExpect := func(env *config.Env) {
+		env.BootstrapExpect = 0
+	}
+
+	alone := startNode(t, 2, nil, withoutExpect)
+	defer alone.shutdown()
+	isLeader := func() bool {
+		stats, err := adminClient(t, alone).RaftStats(ctx, &pb.RaftStatsRequest{})
+		return err == nil && stats.Stats["state"] == "Leader"
+	}
+	require.Never(t, isLeader, 2*time.Second, 100*time.Millisecond)
+
+	// a node joining through both merges it into the cluster's gossip
+	bridge := startNode(t, 3, []string{nodes[0].env.BindAddr, alone.env.BindAddr}, withoutExpect)
+	defer bridge.shutdown()
+	require.Eventually(t, func() bool {
+		servers, err := client(t, alone).GetServers(ctx, &pb.GetServersRequest{})
+		return err == nil && len(servers.Servers) == 4
+	}, 10*time.Second, 100*time.Millisecond)
+	require.False(t, isLeader())
+	servers, err := client(t, nodes[0]).GetServers(ctx, &pb.GetServersRequest{})
+	require.NoError(t, err)
+	for _, server := range servers.Servers {
+		require.Equal(t, server.Id == nodes[0].env.NodeName, server.IsLeader)
+	}
+}
+
+// TestRaftPeerIdentityRequired refuses to start a node with peer TLS and no
+// peer identity rather than guessing one that may admit clients as peers.
+func TestRaftPeerIdentityRequired(t *testing.T) {
+	env := nodeEnv(t, 0, nil)
+	defer os.RemoveAll(env.DataDir)
+	env.RaftPeerIdentity = ""
+	_, err := di.InitializeService(env)
+	require.ErrorContains(t, err, "RAFT_PEER_IDENTITY")
+}
+
+func TestShutdownLeader(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3)
+	defer teardown()
+	ctx := context.Background()
+
+	require.Eventually(t, func() bool {
+		_, err := client(t, nodes[0]).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	stream, err := client(t, nodes[0]).ConsumeStream(ctx, &pb.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, nodes[0].service.Shutdown())
+	require.Less(t, time.Since(start), nodes[0].env.DrainTimeout)
+
+	// the open stream ends with a status clients retry elsewhere
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// leadership was handed over rather than lost to an election
+	leaders := 0
+	for _, n := range nodes[1:] {
+		stats, err := adminClient(t, n).RaftStats(ctx, &pb.RaftStatsRequest{})
+		require.NoError(t, err)
+		if stats.Stats["state"] == "Leader" {
+			leaders++
+		}
+	}
+	require.Equal(t, 1, leaders)
+}
+
+func TestRecover(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3)
+	defer teardown()
+	ctx := context.Background()
+
+	var offsets []uint64
+	for _, value := range []string{"foo", "bar"} {
+		produce, err := client(t, nodes[0]).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+		offsets = append(offsets, produce.Offset)
+	}
+	waitForReplication(t, nodes, offsets[1])
+
+	// lose two of three nodes, leaving the survivor without a quorum
+	for _, n := range nodes {
+		require.NoError(t, n.service.Shutdown())
+	}
+	survivor := nodes[1]
+	survivor.env.StartJoinAddrs = nil
+
+	recovery, err := di.InitializeRecovery(survivor.env)
+	require.NoError(t, err)
+	require.NoError(t, recovery.Recover(nil))
+	require.NoError(t, recovery.Close())
+
+	s, err := di.InitializeService(survivor.env)
+	require.NoError(t, err)
+	s.Serve()
+	defer s.Shutdown() //nolint:errcheck //reason: test teardown
+
+	c := client(t, survivor)
+	var produce *pb.ProduceResponse
+	require.Eventually(t, func() bool {
+		produce, err = c.Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("baz")}})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, offsets[1]+1, produce.Offset)
+
+	consume, err := c.ConsumeRange(ctx, &pb.ConsumeRequest{Offset: offsets[0]})
+	require.NoError(t, err)
+	var values []string
+	for _, record := range consume.Records {
+		values = append(values, string(record.Value))
+	}
+	require.Equal(t, []string{"foo", "bar", "baz"}, values)
+
+	servers, err := c.GetServers(ctx, &pb.GetServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, servers.Servers, 1)
+	require.True(t, servers.Servers[0].IsLeader)
+}
+
+// TestRecoverRefused keeps the data log of a node whose recovery raft
+// refuses, here for a configuration without a server id.
+func TestRecoverRefused(t *testing.T) {
+	nodes, teardown := setupCluster(t, 3)
+	defer teardown()
+	ctx := context.Background()
+
+	produce, err := client(t, nodes[0]).Produce(ctx, &pb.ProduceRequest{Record: &pb.Record{Value: []byte("foo")}})
+	require.NoError(t, err)
+	waitForReplication(t, nodes, produce.Offset)
+	for _, n := range nodes {
+		require.NoError(t, n.service.Shutdown())
+	}
+	survivor := nodes[1]
+	survivor.env.StartJoinAddrs = nil
+
+	recovery, err := di.InitializeRecovery(survivor.env)
+	require.NoError(t, err)
+	require.Error(t, recovery.Recover([]raft.Server{{Suffrage: raft.Voter, Address: raft.ServerAddress(survivor.rpcAddr())}}))
+	require.NoError(t, recovery.Close())
+
+	logConfig := di.ProvideSegmentConfig(survivor.env)
+	logConfig.DataDir = filepath.Join(survivor.env.DataDir, "partitions", "0", "log")
+	l, err := log.NewLog(logConfig)
+	require.NoError(t, err)
+	defer l.Close() //nolint:errcheck //reason: test teardown
+	record, err := l.Read(produce.Offset)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), record.Value)
+}
+
+func BenchmarkProduceStream(b *testing.B) {
+	nodes, teardown := setupCluster(b, 3)
+	defer teardown()
+	leader := client(b, nodes[0])
+	first, err := leader.Produce(context.Background(), &pb.ProduceRequest{Record: &pb.Record{Value: []byte("warmup")}})
+	require.NoError(b, err)
+	waitForReplication(b, nodes, first.Offset)
+
+	for _, window := range []int{1, 16, 64, 256} {
+		b.Run(fmt.Sprintf("window=%d", window), func(b *testing.B) {
+			benchmarkProduceStream(b, leader, window)
+		})
+	}
+}
+
+// benchmarkProduceStream keeps at most window records outstanding on one
+// stream; window=1 is the old request/response behaviour.
+func benchmarkProduceStream(b *testing.B, c pb.LogClient, window int) {
+	b.Helper()
+	stream, err := c.ProduceStream(context.Background())
+	require.NoError(b, err)
+	record := &pb.Record{Value: make([]byte, 128)}
+
+	b.ResetTimer()
+	acked := 0
+	for i := 0; i < b.N; i++ {
+		if i-acked >= window {
+			_, err := stream.Recv()
+			require.NoError(b, err)
+			acked++
+		}
+		require.NoError(b, stream.Send(&pb.ProduceRequest{Record: record, CorrelationId: uint64(i)}))
+	}
+	for ; acked < b.N; acked++ {
+		res, err := stream.Recv()
+		require.NoError(b, err)
+		require.Equal(b, uint64(acked), res.CorrelationId)
+	}
+	b.StopTimer()
+	require.NoError(b, stream.CloseSend())
+}
@@ -0,0 +1,51 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// ForServer returns a copy of the client config verifying the server as
+// serverName, unless a server name is configured explicitly. VerifyConnection
+// sees serverName too: the state of a connection to an ip address doesn't
+// carry it, as it isn't sent to the server.
+func ForServer(c *tls.Config, serverName string) *tls.Config {
+	if c.ServerName != "" {
+		return c
+	}
+	c = c.Clone()
+	c.ServerName = serverName
+	if verify := c.VerifyConnection; verify != nil {
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			cs.ServerName = serverName
+			return verify(cs)
+		}
+	}
+	return c
+}
+
+// NewClientCredentials is credentials.NewTLS for configs whose
+// VerifyConnection needs the server name, see ForServer.
+func NewClientCredentials(c *tls.Config) credentials.TransportCredentials {
+	return &clientCredentials{TransportCredentials: credentials.NewTLS(c), config: c}
+}
+
+type clientCredentials struct {
+	credentials.TransportCredentials
+	config *tls.Config
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	host, _, err := net.SplitHostPort(authority)
+	if err != nil {
+		host = authority
+	}
+	return credentials.NewTLS(ForServer(c.config, host)).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	return NewClientCredentials(c.config)
+}
@@ -5,26 +5,48 @@ import (
 	"path/filepath"
 )
 
+// The files proglog certs and the Makefile write to the config dir, which is
+// $CONFIG_DIR or ~/.proglog.
 var (
-	CAFile         = configFile("ca.pem")
-	ServerCertFile = configFile("server.pem")
-	ServerKeyFile  = configFile("server-key.pem")
+	CAFile         string
+	CAKeyFile      string
+	ServerCertFile string
+	ServerKeyFile  string
 
-	RootClientCertFile   = configFile("root-client.pem")
-	RootClientKeyFile    = configFile("root-client-key.pem")
-	NobodyClientCertFile = configFile("nobody-client.pem")
-	NobodyClientKeyFile  = configFile("nobody-client-key.pem")
-	ACLModelFile         = configFile("model.conf")
-	ACLPolicyFile        = configFile("policy.csv")
+	RootClientCertFile   string
+	RootClientKeyFile    string
+	NobodyClientCertFile string
+	NobodyClientKeyFile  string
+	ACLModelFile         string
+	ACLPolicyFile        string
 )
 
-func configFile(filename string) string {
-	if dir := os.Getenv("CONFIG_DIR"); dir != "" {
-		return filepath.Join(dir, filename)
+func init() {
+	SetConfigDir(os.Getenv("CONFIG_DIR"))
+}
+
+// SetConfigDir points the file names at dir, or at ~/.proglog when dir is
+// empty.
+func SetConfigDir(dir string) {
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			panic(err)
+		}
+		dir = filepath.Join(homeDir, ".proglog")
 	}
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		panic(err)
+	configFile := func(filename string) string {
+		return filepath.Join(dir, filename)
 	}
-	return filepath.Join(homeDir, ".proglog", filename)
+	CAFile = configFile("ca.pem")
+	CAKeyFile = configFile("ca-key.pem")
+	ServerCertFile = configFile("server.pem")
+	ServerKeyFile = configFile("server-key.pem")
+
+	RootClientCertFile = configFile("root-client.pem")
+	RootClientKeyFile = configFile("root-client-key.pem")
+	NobodyClientCertFile = configFile("nobody-client.pem")
+	NobodyClientKeyFile = configFile("nobody-client-key.pem")
+	ACLModelFile = configFile("model.conf")
+	ACLPolicyFile = configFile("policy.csv")
 }
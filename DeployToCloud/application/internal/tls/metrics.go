@@ -0,0 +1,48 @@
+package tls
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	KeyCertificate = tag.MustNewKey("proglog_certificate")
+
+	CertificateExpiry = stats.Int64("proglog/tls/certificate_expiry", "Unix time the loaded certificate expires at", stats.UnitSeconds)
+	ReloadFailures    = stats.Int64("proglog/tls/reload_failures", "Certificate edits that failed to load", stats.UnitDimensionless)
+
+	CertificateExpiryView = &view.View{
+		Name:        "proglog/tls/certificate_expiry",
+		Description: "Unix time the loaded certificate expires at, by certificate",
+		Measure:     CertificateExpiry,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{KeyCertificate},
+	}
+
+	ReloadFailuresView = &view.View{
+		Name:        "proglog/tls/reload_failures",
+		Description: "Count of certificate edits that failed to load, by certificate",
+		Measure:     ReloadFailures,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyCertificate},
+	}
+
+	DefaultWatcherViews = []*view.View{
+		CertificateExpiryView,
+		ReloadFailuresView,
+	}
+)
+
+func recordExpiry(name string, notAfter time.Time) {
+	//nolint:errcheck //reason: only fails for invalid tags
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeyCertificate, name)}, CertificateExpiry.M(notAfter.Unix()))
+}
+
+func recordReloadFailure(name string) {
+	//nolint:errcheck //reason: only fails for invalid tags
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeyCertificate, name)}, ReloadFailures.M(1))
+}
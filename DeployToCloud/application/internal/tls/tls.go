@@ -15,15 +15,16 @@ type Args struct {
 }
 
 func SetupTLS(args Args) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
 	if args.CertFile != "" && args.KeyFile != "" {
 		c, err := tls.LoadX509KeyPair(args.CertFile, args.KeyFile)
 		if err != nil {
 			return nil, err
 		}
-		return &tls.Config{Certificates: []tls.Certificate{c}}, nil
+		tlsConfig.Certificates = []tls.Certificate{c}
 	}
 	if args.CAFile == "" {
-		return &tls.Config{}, nil
+		return tlsConfig, nil
 	}
 	b, err := os.ReadFile(args.CAFile)
 	if err != nil {
@@ -34,8 +35,10 @@ func SetupTLS(args Args) (*tls.Config, error) {
 		return nil, fmt.Errorf("failed to parse root certificate: %q", args.CAFile)
 	}
 	if args.Server {
-		return &tls.Config{ClientCAs: ca, ClientAuth: tls.RequireAndVerifyClientCert}, nil
+		tlsConfig.ClientCAs = ca
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	} else {
-		return &tls.Config{RootCAs: ca}, nil
+		tlsConfig.RootCAs = ca
 	}
+	return tlsConfig, nil
 }
@@ -0,0 +1,238 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+
+	"github.com/travisjeffery/proglog/internal/filestamp"
+)
+
+const defaultReloadInterval = time.Second
+
+// CertWatcher serves a certificate and CA bundle read from files through
+// tls.Config callbacks, so rotating them doesn't need a restart. The files
+// are checked for changes at most every ReloadInterval, on the handshakes
+// that use them, and an edit that fails to load keeps the previous files.
+type CertWatcher struct {
+	args   WatcherArgs
+	loaded atomic.Value // *certs
+
+	mu        sync.Mutex
+	nextCheck int64
+	stamps    [3]filestamp.Stamp
+	logger    *zap.Logger
+}
+
+type WatcherArgs struct {
+	Args
+	// Name tags the watcher's metrics and logs, like server or peer.
+	Name string
+	// ReloadInterval is how often the files are checked for changes, 1s
+	// when zero.
+	ReloadInterval time.Duration
+}
+
+type certs struct {
+	cert *tls.Certificate
+	// cas is nil without a CA file.
+	cas *x509.CertPool
+}
+
+func NewCertWatcher(args WatcherArgs) (*CertWatcher, error) {
+	if args.CertFile == "" || args.KeyFile == "" {
+		return nil, errors.New("certificate watcher needs a cert and a key file")
+	}
+	if args.ReloadInterval <= 0 {
+		args.ReloadInterval = defaultReloadInterval
+	}
+	if err := view.Register(DefaultWatcherViews...); err != nil {
+		return nil, err
+	}
+	w := &CertWatcher{args: args, logger: zap.L().Named("tls").With(zap.String("certificate", args.Name))}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Config returns a config serving the watched files like SetupTLS does with
+// the same args. Servers verify client certificates in VerifyPeerCertificate
+// and clients verify servers in VerifyConnection, which sees the server
+// name, both against the CAs read last.
+func (w *CertWatcher) Config() *tls.Config {
+	hasCAs := w.args.CAFile != ""
+	if w.args.Server {
+		c := &tls.Config{GetCertificate: w.GetCertificate}
+		if hasCAs {
+			c.ClientAuth = tls.RequireAnyClientCert
+			c.VerifyPeerCertificate = w.verifyClient
+		}
+		return c
+	}
+	c := &tls.Config{GetClientCertificate: w.GetClientCertificate}
+	if hasCAs {
+		//nolint:gosec //reason: VerifyConnection verifies the server
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = w.verifyServer
+	}
+	return c
+}
+
+// WithClientAuth returns a copy of the server config asking for client
+// certificates as clientAuth does. A CertWatcher's config verifies them
+// itself, so it only has to ask for them.
+func WithClientAuth(c *tls.Config, clientAuth tls.ClientAuthType) *tls.Config {
+	c = c.Clone()
+	c.ClientAuth = clientAuth
+	if c.VerifyPeerCertificate != nil {
+		switch clientAuth {
+		case tls.RequireAndVerifyClientCert:
+			c.ClientAuth = tls.RequireAnyClientCert
+		case tls.VerifyClientCertIfGiven:
+			c.ClientAuth = tls.RequestClientCert
+		}
+	}
+	return c
+}
+
+func (w *CertWatcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return w.current().cert, nil
+}
+
+func (w *CertWatcher) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return w.current().cert, nil
+}
+
+// Reload reads the files and swaps them in when they're valid.
+func (w *CertWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload()
+}
+
+func (w *CertWatcher) current() *certs {
+	w.maybeReload()
+	//nolint:forcetypeassert //reason: only certs are stored
+	return w.loaded.Load().(*certs)
+}
+
+func (w *CertWatcher) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	// ClientAuth decides whether a certificate is required
+	if len(rawCerts) == 0 {
+		return nil
+	}
+	chain := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, cert)
+	}
+	return w.verify(chain, "", x509.ExtKeyUsageClientAuth)
+}
+
+func (w *CertWatcher) verifyServer(cs tls.ConnectionState) error {
+	if cs.ServerName == "" {
+		return errors.New("no server name to verify the certificate against")
+	}
+	return w.verify(cs.PeerCertificates, cs.ServerName, x509.ExtKeyUsageServerAuth)
+}
+
+func (w *CertWatcher) verify(chain []*x509.Certificate, dnsName string, usage x509.ExtKeyUsage) error {
+	if len(chain) == 0 {
+		return errors.New("no certificate to verify")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         w.current().cas,
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(opts)
+	return err
+}
+
+func (w *CertWatcher) maybeReload() {
+	now := time.Now().UnixNano()
+	if now < atomic.LoadInt64(&w.nextCheck) || !w.mu.TryLock() {
+		return
+	}
+	defer w.mu.Unlock()
+	atomic.StoreInt64(&w.nextCheck, now+int64(w.args.ReloadInterval))
+	stamps, err := w.stampFiles()
+	if err != nil {
+		w.logger.Error("failed to check certificate files", zap.Error(err))
+		return
+	}
+	if stamps == w.stamps {
+		return
+	}
+	if err := w.reload(); err != nil {
+		recordReloadFailure(w.args.Name)
+		w.logger.Error("failed to reload certificate, keeping the previous one",
+			zap.String("cert", w.args.CertFile),
+			zap.Error(err))
+		return
+	}
+	w.logger.Info("reloaded certificate", zap.String("cert", w.args.CertFile))
+}
+
+// reload remembers the files it read even when they're invalid, so that a
+// half-written rotation is retried once the next file changes.
+func (w *CertWatcher) reload() error {
+	stamps, err := w.stampFiles()
+	if err != nil {
+		return err
+	}
+	w.stamps = stamps
+	cert, err := tls.LoadX509KeyPair(w.args.CertFile, w.args.KeyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	loaded := &certs{cert: &cert}
+	if w.args.CAFile != "" {
+		b, err := os.ReadFile(w.args.CAFile)
+		if err != nil {
+			return err
+		}
+		loaded.cas = x509.NewCertPool()
+		if !loaded.cas.AppendCertsFromPEM(b) {
+			return fmt.Errorf("failed to parse root certificate: %q", w.args.CAFile)
+		}
+	}
+	w.loaded.Store(loaded)
+	recordExpiry(w.args.Name, cert.Leaf.NotAfter)
+	return nil
+}
+
+func (w *CertWatcher) stampFiles() ([3]filestamp.Stamp, error) {
+	var stamps [3]filestamp.Stamp
+	for i, file := range []string{w.args.CertFile, w.args.KeyFile, w.args.CAFile} {
+		if file == "" {
+			continue
+		}
+		var err error
+		if stamps[i], err = filestamp.Of(file); err != nil {
+			return stamps, err
+		}
+	}
+	return stamps, nil
+}
@@ -0,0 +1,157 @@
+package tls_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+
+	. "github.com/travisjeffery/proglog/internal/tls"
+	"github.com/travisjeffery/proglog/test"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(test.Run(m))
+}
+
+func TestCertWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	args := Args{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	install(t, ServerCertFile, args.CertFile)
+	install(t, ServerKeyFile, args.KeyFile)
+	install(t, CAFile, args.CAFile)
+	w, err := NewCertWatcher(WatcherArgs{Args: args, Name: "reload-test", ReloadInterval: time.Millisecond})
+	require.NoError(t, err)
+	requireCommonName(t, w, "127.0.0.1")
+
+	// rotate the certificate
+	install(t, RootClientCertFile, args.CertFile)
+	install(t, RootClientKeyFile, args.KeyFile)
+	require.Eventually(t, func() bool {
+		cert, err := w.GetClientCertificate(nil)
+		return err == nil && cert.Leaf.Subject.CommonName == "root"
+	}, time.Second, 5*time.Millisecond)
+
+	rows, err := view.RetrieveData(CertificateExpiryView.Name)
+	require.NoError(t, err)
+	require.NotEmpty(t, rows)
+
+	// a broken edit keeps the previous certificate
+	rewrite(t, args.CertFile, []byte("not a certificate"))
+	time.Sleep(5 * time.Millisecond)
+	requireCommonName(t, w, "root")
+	require.Error(t, w.Reload())
+	requireCommonName(t, w, "root")
+}
+
+func TestCertWatcherHandshake(t *testing.T) {
+	server, err := NewCertWatcher(WatcherArgs{Args: Args{
+		CertFile: ServerCertFile,
+		KeyFile:  ServerKeyFile,
+		CAFile:   CAFile,
+		Server:   true,
+	}, Name: "server"})
+	require.NoError(t, err)
+	client, err := NewCertWatcher(WatcherArgs{Args: Args{
+		CertFile: RootClientCertFile,
+		KeyFile:  RootClientKeyFile,
+		CAFile:   CAFile,
+	}, Name: "peer"})
+	require.NoError(t, err)
+	anonymous, err := SetupTLS(Args{CAFile: CAFile})
+	require.NoError(t, err)
+
+	for scenario, tc := range map[string]struct {
+		client     *tls.Config
+		serverName string
+		clientAuth tls.ClientAuthType
+		ok         bool
+	}{
+		"verified both ways":                  {client: client.Config(), serverName: "127.0.0.1", ok: true},
+		"server name isn't the certificate's": {client: client.Config(), serverName: "example.com"},
+		"client without a certificate":        {client: anonymous, serverName: "127.0.0.1"},
+		"optional client certificate":         {client: anonymous, serverName: "127.0.0.1", clientAuth: tls.VerifyClientCertIfGiven, ok: true},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			serverConfig := server.Config()
+			if tc.clientAuth != 0 {
+				serverConfig = WithClientAuth(serverConfig, tc.clientAuth)
+			}
+			clientConfig := ForServer(tc.client, tc.serverName)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+			serverErr := make(chan error, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					serverErr <- err
+					return
+				}
+				defer conn.Close()
+				serverErr <- tls.Server(conn, serverConfig).Handshake()
+			}()
+			conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+			if err == nil {
+				// the server verifies the client after the client's handshake
+				// and closes the connection either way
+				if _, err = conn.Read(make([]byte, 1)); errors.Is(err, io.EOF) {
+					err = nil
+				}
+				conn.Close()
+			}
+			if tc.ok {
+				require.NoError(t, err)
+				require.NoError(t, <-serverErr)
+				return
+			}
+			require.Error(t, errOr(err, <-serverErr))
+		})
+	}
+}
+
+func errOr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func requireCommonName(t *testing.T, w *CertWatcher, commonName string) {
+	t.Helper()
+	cert, err := w.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, commonName, cert.Leaf.Subject.CommonName)
+}
+
+func install(t *testing.T, src, dst string) {
+	t.Helper()
+	b, err := os.ReadFile(src)
+	require.NoError(t, err)
+	rewrite(t, dst, b)
+}
+
+// rewrite writes the file a second after its last modification, so that the
+// change is seen even within the file system's timestamp granularity.
+func rewrite(t *testing.T, file string, b []byte) {
+	t.Helper()
+	modTime := time.Now()
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	require.NoError(t, os.WriteFile(file, b, 0o600))
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+}
@@ -0,0 +1,55 @@
+// Package test sets up the config files the tests read, so that they run
+// without generating certificates beforehand.
+package test
+
+import (
+	"embed"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/travisjeffery/proglog/internal/ca"
+	innertls "github.com/travisjeffery/proglog/internal/tls"
+)
+
+//go:embed model.conf policy.csv
+var aclFiles embed.FS
+
+// Run runs the tests with the config files WriteConfigDir writes to a temp
+// dir, call it from TestMain.
+func Run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "proglog-config")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := WriteConfigDir(dir); err != nil {
+		panic(err)
+	}
+	return m.Run()
+}
+
+// WriteConfigDir writes a CA, a server certificate for 127.0.0.1 and
+// localhost, root and nobody client certificates, and the acl model and
+// policy to dir, and points innertls' file names at it.
+func WriteConfigDir(dir string) error {
+	err := ca.WriteConfigFiles(ca.FilesArgs{
+		Dir:         dir,
+		ServerHosts: []string{"127.0.0.1", "localhost"},
+		Clients:     []string{"root", "nobody"},
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"model.conf", "policy.csv"} {
+		b, err := aclFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o600); err != nil {
+			return err
+		}
+	}
+	innertls.SetConfigDir(dir)
+	return nil
+}